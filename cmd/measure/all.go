@@ -0,0 +1,201 @@
+/***************************************************
+* Author: Diana Cohen (sch.diana@gmail.com)
+* **************************************************
+* handle the entire trace as a single batch (all), counting:
+* n - the number of flows (distinct items),
+* N - stream length
+* also, compute for further analysis:
+* beta = (N/n) - the average frequency
+* with -hll, n is estimated by a HyperLogLog as well (exact n is kept for the error)
+* the flow-size distribution: a log-binned histogram (a file of its own), Zipf's exponent
+* along with the goodness-of-fit, and the share of traffic of the top 1% and 10% of flows
+* the Shannon entropy of the flow frequencies, with -entropy it is estimated by an entropy sketch as well
+ */
+
+package main
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+
+	"github.com/DianaCohenCS/measure-traces/output"
+	"github.com/DianaCohenCS/measure-traces/sketch"
+	"github.com/DianaCohenCS/measure-traces/stats"
+)
+
+func runAll(s *session, args []string) error {
+	fs, opts := newFlagSet(s, "all", []string{"[trace-name]"},
+		"Handle the entire trace as a single batch, counting N (stream length), n (flows) and beta (N/n).")
+	hll_p := fs.Uint("hll", 0, "estimate n by a HyperLogLog of a given precision (4-18), 0 is off")
+	entropy_k := fs.Int("entropy", 0, "estimate the entropy by a sketch of a given number of projections, 0 is off")
+	args, err := opts.parse(args)
+	if err != nil {
+		return err
+	}
+	if len(args) != 1 {
+		return usagef("trace parameter is required")
+	}
+	// get the trace-name and configure input/output files
+	trace_name := args[0]
+	batch_size := "all"
+
+	// define headers for detailed and metadata files
+	// the detailed file is normalized: the flows only, the trace is described by the metadata file
+	headers_meta := concatMultipleSlices([][]output.Column{output.Strings("trace"), output.Ints("N", "n"), output.Floats("beta (N/n)")})
+	if *hll_p > 0 { // estimated n, along with its relative error
+		headers_meta = append(headers_meta, output.Floats("n_hll", "n_err")...)
+	}
+	// the skew of the flow sizes: Zipf's exponent, R^2 and KS distance of the fit, and the traffic of the top flows
+	headers_meta = append(headers_meta, output.Floats("zipf_s", "zipf_r2", "zipf_ks", "top1% share", "top10% share")...)
+	// the entropy of the flow frequencies (bits), estimated along with its (absolute) error
+	headers_meta = append(headers_meta, output.Floats("entropy")...)
+	if *entropy_k > 0 {
+		headers_meta = append(headers_meta, output.Floats("entropy_est", "entropy_err")...)
+	}
+	headers := concatMultipleSlices([][]output.Column{output.Ints("idx", "val"), output.Strings("key")})
+	headers_hist := concatMultipleSlices([][]output.Column{output.Ints("bin#", "lower", "upper", "flows", "items"),
+		output.Floats("flows share", "items share")})
+
+	// estimate the number of flows
+	var hll *sketch.HLL
+	if *hll_p > 0 {
+		if *hll_p < 4 || *hll_p > 18 { // checked before the conversion, which truncates
+			return usagef("HyperLogLog precision must be within [4, 18]")
+		}
+		hll, _ = sketch.NewHLL(uint8(*hll_p), 0)
+	}
+	// estimate the entropy
+	var entropy_sketch *sketch.Entropy
+	if *entropy_k != 0 {
+		entropy_sketch, err = sketch.NewEntropy(*entropy_k, 0)
+		if err != nil {
+			return usagef("%v", err)
+		}
+	}
+
+	// open the trace (input) file
+	scanner, err := opts.open(trace_name)
+	if err != nil {
+		return fmt.Errorf("opening in-file: %w", err)
+	}
+	defer scanner.Close()
+
+	// create the detailed (output) file, listing the flows
+	writer, err := opts.create(trace_name, fmt.Sprintf("%s_%s_flows", trace_name, batch_size), headers)
+	if err != nil {
+		return fmt.Errorf("opening out-file-flows: %w", err)
+	}
+	defer writer.file.Close()
+
+	// create the metadata (output) file, aggregating the data per batch
+	writer_meta, err := opts.create(trace_name, fmt.Sprintf("%s_%s", trace_name, batch_size), headers_meta)
+	if err != nil {
+		return fmt.Errorf("opening out-file: %w", err)
+	}
+	defer writer_meta.file.Close()
+
+	// create the histogram (output) file, the flow sizes binned by powers of 2
+	writer_hist, err := opts.create(trace_name, fmt.Sprintf("%s_%s_hist", trace_name, batch_size), headers_hist)
+	if err != nil {
+		return fmt.Errorf("opening out-file-hist: %w", err)
+	}
+	defer writer_hist.file.Close()
+
+	// Creating a map using make() function.
+	// key-value pairs for flow-id (string) and frequency (integer)
+	// the frequency of a flow is a uint64, the entire trace is a single batch of no bounded counter
+	var flow_map = make(map[string]uint64)
+	flow_index := 1 // 1-based index of a current flow within a batch
+	B := 0          // number of currently delayed items within a given batch
+	b := 0          // number of currently delayed flows within a given batch
+
+	for scanner.Scan() {
+		// read the item-id
+		id := scanner.Item().ID
+		// update the frequency
+		value, found := flow_map[id]
+		if found {
+			flow_map[id] = value + 1
+		} else { // new flow
+			b++
+			flow_map[id] = 1
+		}
+		B++
+		if hll != nil {
+			hll.Add(id)
+		}
+	}
+	if B != 0 { // send/print the partial batch
+		// write to metadata file
+		data_csv_meta := []string{trace_name,
+			fmt.Sprintf("%d", B),
+			fmt.Sprintf("%d", b),
+			fmt.Sprintf("%.4f", float64(B)/float64(b))}
+		if hll != nil {
+			n_hll := hll.Estimate()
+			data_csv_meta = append(data_csv_meta,
+				fmt.Sprintf("%.2f", n_hll),
+				fmt.Sprintf("%.8f", (n_hll-float64(b))/float64(b)))
+		}
+		sizes := stats.SortDesc(slices.Collect(maps.Values(flow_map)))
+		zipf := stats.FitZipf(sizes)
+		data_csv_meta = append(data_csv_meta,
+			fmt.Sprintf("%.4f", zipf.S),
+			fmt.Sprintf("%.4f", zipf.R2),
+			fmt.Sprintf("%.4f", zipf.KS),
+			fmt.Sprintf("%.4f", stats.TopShare(sizes, 0.01)),
+			fmt.Sprintf("%.4f", stats.TopShare(sizes, 0.1)))
+		entropy := stats.Entropy(maps.Values(flow_map))
+		data_csv_meta = append(data_csv_meta, fmt.Sprintf("%.6f", entropy))
+		if entropy_sketch != nil { // the sketch is linear, a flow is added once along with its frequency
+			for id, frequency := range flow_map {
+				entropy_sketch.Update(id, int(frequency))
+			}
+			entropy_est := entropy_sketch.Estimate()
+			data_csv_meta = append(data_csv_meta,
+				fmt.Sprintf("%.6f", entropy_est),
+				fmt.Sprintf("%.6f", entropy_est-entropy))
+		}
+		writer_meta.Write(data_csv_meta)
+
+		// write to histogram file
+		for k, bin := range stats.LogHistogram(sizes) {
+			writer_hist.Write([]string{fmt.Sprintf("%d", k),
+				fmt.Sprintf("%d", bin.Lower),
+				fmt.Sprintf("%d", bin.Upper),
+				fmt.Sprintf("%d", bin.Flows),
+				fmt.Sprintf("%d", bin.Items),
+				fmt.Sprintf("%.8f", float64(bin.Flows)/float64(b)),
+				fmt.Sprintf("%.8f", float64(bin.Items)/float64(B))})
+		}
+
+		// write to detailed file
+		flow_index = 1 // reset
+		// iterate the flows in order of flow-id, so the outfile does not depend on the map order
+		for _, flow_id := range slices.Sorted(maps.Keys(flow_map)) {
+			flow_csv := []string{fmt.Sprintf("%d", flow_index),
+				fmt.Sprintf("%d", flow_map[flow_id]),
+				flow_id}
+			writer.Write(flow_csv)
+
+			// next flow
+			flow_index++
+		}
+
+		// clear leftovers
+		for k := range flow_map {
+			delete(flow_map, k)
+		}
+		B = 0
+		b = 0
+	}
+
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("reading from in-file: %w", err) // scanning is not done properly
+	}
+	if err := closeTables(writer, writer_meta, writer_hist); err != nil {
+		return fmt.Errorf("writing out-file: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,1108 @@
+/***************************************************
+* Author: Diana Cohen (sch.diana@gmail.com)
+* **************************************************
+* handle the trace using batches, counting:
+* b - the number of flows (distinct items) within a batch,
+* B - the number of items
+* also, compute for further analysis:
+* theta = (1 + len(counter)/len(flow-id))
+* beta = (B/b) - the average frequency
+
+* batches are cut either by the number of items (batch-size),
+* or by time (-window), when the trace carries timestamps (tstxt or pcap):
+* a batch holds the items of a time window [t0 + k*window, t0 + (k+1)*window),
+* where t0 is the arrival time of the first item, empty windows are not reported
+* several batch sizes (a comma separated list) are handled in a single pass over the trace,
+* each by its own batcher, writing the same outfiles as a run per size
+
+* sliding windows (-slide step), alongside the batches: every step items (or time units),
+* report b and beta of the latest W items (W = batch-size), or of the latest W time units (W = window),
+* both exactly and as estimated by a sliding CMS of k panes (-panes), where the mean relative error
+* of the estimated frequencies reflects both the sketch and the granularity of the panes
+
+* with -hll, b is estimated per batch by a HyperLogLog as well (exact b is kept for the error),
+* the batches are merged into a single HyperLogLog, estimating the flows of the entire trace
+* the Shannon entropy of the flow frequencies is reported per batch, with -entropy it is estimated
+* by an entropy sketch of k projections as well, the batches are merged into the entropy of the trace
+
+* the batch counters are of a fixed width (-counter-bits 8/16/32/64), by default the bits of B, so no
+* counter overflows, otherwise the model is validated: a counter that exceeds its width either saturates
+* or wraps around (-overflow), the flows that overflowed are counted per batch (the flows file keeps
+* the true frequencies, the encoded reports hold the values of the counters)
+
+* the churn between consecutive (reported) batches: the flows that are new since the previous batch,
+* the flows of the previous batch that are gone, and the Jaccard similarity of the flow sets,
+* the lifetime of a flow is the number of consecutive batches it appears in, the distribution of the
+* lifetimes is written to a file of its own, where the flows of the latest batch are still ongoing
+
+* the summary of the batches (a file of its own): mean, std, min, max and percentiles (-percentiles)
+* of B, b and beta, along with the thresholds: theta (traffic) and theta/alpha (space, with -alpha)
+* each batch is classified by the thresholds: batching saves traffic if beta >= theta, and it saves space
+* if beta >= theta/alpha, the mean of these (0/1) metrics in the summary is the fraction of the batches
+
+* with -encode, each batch is serialized as a batched report of (id, counter) pairs, in several encodings
+* (fixed width, varint counters, delta-sorted ids and zstd), reporting the real bytes per batch
+* along with the raw ids (B*id_len bits) and the theta model (b*(id_len+cnt_len) bits)
+
+* with -export, the (flow-id, count) table of each batch is exported as an IPFIX-like stream (package report),
+* over UDP, TCP or a unix socket to a given address, or into a file, an observation domain per batch size (or window),
+* see measure receive, or as a CMS delta per batch (-export-as cms, of -epsilon, -delta and -seed), see measure collect,
+* either a binary snapshot (cms) or a length-delimited BatchDelta of proto/sketch.proto (proto),
+* a delta is never split, so over UDP it is refused unless it fits in a datagram (1400 bytes)
+
+* the partial (last) batch, of less than batch-size items or the latest time window, is either
+* included, dropped, or merged into the previous batch (-partial), and marked in the metadata
+ */
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"maps"
+	"math"
+	"math/bits"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/DianaCohenCS/measure-traces/output"
+	"github.com/DianaCohenCS/measure-traces/report"
+	"github.com/DianaCohenCS/measure-traces/sketch"
+	"github.com/DianaCohenCS/measure-traces/stats"
+	"github.com/DianaCohenCS/measure-traces/trace"
+)
+
+func runBatch(s *session, args []string) error {
+	fs, opts := newFlagSet(s, "batch", []string{"[trace-name] [batch-size,...] [id-len]", "-window [duration] [trace-name] [id-len]"},
+		"Handle the trace using batches, counting B (items), b (flows) and beta (B/b) per batch,\n"+
+			"cut either by batch-size items or by time windows of timestamped traces.\n"+
+			"A comma separated list of batch sizes is handled in a single pass over the trace, writing the outfiles per size.")
+	window := fs.Duration("window", 0, "cut batches by time windows (e.g. 100ms) instead of batch-size")
+	slide := fs.String("slide", "", "report sliding windows every step: items, or time units with -window (e.g. 10ms)")
+	panes := fs.Int("panes", 8, "number of panes of the sliding CMS")
+	epsilon := fs.Float64("epsilon", 0.001, "error rate of the sliding CMS and of the exported CMS deltas")
+	delta := fs.Float64("delta", 0.01, "confidence of the sliding CMS and of the exported CMS deltas")
+	seed := fs.Uint64("seed", 0, "seed of the sliding CMS (and CMS deltas) hash functions, 0 draws a random seed (recorded in the metadata)")
+	hll_p := fs.Uint("hll", 0, "estimate b by a HyperLogLog of a given precision (4-18), 0 is off")
+	entropy_k := fs.Int("entropy", 0, "estimate the entropy by a sketch of a given number of projections, 0 is off")
+	counter_bits := fs.Int("counter-bits", 0, "width of a batch counter: 8, 16, 32 or 64, 0 is the bits of B")
+	overflow := fs.String("overflow", "saturate", "counter overflow: saturate or wrap")
+	partial := fs.String("partial", "include", "the partial (last) batch: include, drop or merge (into the previous batch)")
+	percentiles := floatList{5, 25, 50, 75, 95}
+	fs.Var(&percentiles, "percentiles", "percentiles of the summary, within [0, 100]")
+	encode := fs.Bool("encode", false, "serialize each batch as (id, counter) pairs, reporting the bytes per encoding")
+	export := fs.String("export", "", "export the batches: udp://host:port, tcp://host:port, unix://path or file://path")
+	export_as := fs.String("export-as", "ipfix", "the export of a batch: ipfix (the flow-count table), or a CMS delta of -epsilon, -delta and -seed, binary (cms) or protobuf (proto)")
+	alpha := fs.Float64("alpha", 0, "load factor of a data structure, within (0, 1], for the space threshold theta/alpha, 0 is off")
+	args, err := opts.parse(args)
+	if err != nil {
+		return err
+	}
+	if *window > 0 && len(args) != 2 {
+		return usagef("expected [trace-name] [id-len] with -window")
+	}
+	if *window <= 0 && len(args) != 3 {
+		return usagef("expected [trace-name] [batch-size,...] [id-len]")
+	}
+	// get the trace-name, batch-sizes (or window) and bit-length of identifier
+	trace_name := args[0]
+	batch_sizes := []int{0}
+	batch_labels := []string{window.String()}
+	id_arg := args[1]
+	if *window <= 0 {
+		batch_sizes, batch_labels = nil, nil
+		for _, label := range strings.Split(args[1], ",") {
+			batch_size, err := strconv.Atoi(label)
+			if err != nil || batch_size <= 0 {
+				return usagef("bad batch size %q", label)
+			}
+			if slices.Contains(batch_sizes, batch_size) {
+				return usagef("batch size %d is repeated", batch_size)
+			}
+			batch_sizes = append(batch_sizes, batch_size)
+			batch_labels = append(batch_labels, label)
+		}
+		id_arg = args[2]
+	}
+	id_length, err := strconv.Atoi(id_arg)
+	if err != nil || id_length <= 0 {
+		return usagef("bad id length %q", id_arg)
+	}
+	switch *counter_bits {
+	case 0, 8, 16, 32, 64:
+	default:
+		return usagef("counter bits must be 8, 16, 32 or 64 (0 for the bits of B)")
+	}
+	if *overflow != "saturate" && *overflow != "wrap" {
+		return usagef("overflow must be saturate or wrap")
+	}
+	if *partial != "include" && *partial != "drop" && *partial != "merge" {
+		return usagef("partial must be include, drop or merge")
+	}
+	for i, p := range percentiles {
+		if p < 0 || p > 100 || slices.Contains(percentiles[:i], p) {
+			return usagef("percentiles must be distinct, within [0, 100]")
+		}
+	}
+	if *export_as != "ipfix" && *export_as != "cms" && *export_as != "proto" {
+		return usagef("export-as must be ipfix, cms or proto")
+	}
+	if *export != "" && *export_as != "ipfix" && *seed == 0 {
+		return usagef("exporting CMS deltas requires -seed, the seed of the collector")
+	}
+	if *alpha < 0 || *alpha > 1 {
+		return usagef("alpha must be within (0, 1], 0 is off")
+	}
+	params := &batchParams{
+		window:       *window,
+		id_length:    id_length,
+		hll_p:        uint8(*hll_p),
+		entropy_k:    *entropy_k,
+		counter_bits: *counter_bits,
+		saturate:     *overflow == "saturate",
+		partial:      *partial,
+		percentiles:  percentiles,
+		alpha:        *alpha,
+	}
+	if *encode {
+		params.encoder, err = report.NewEncoder(id_length)
+		if err != nil {
+			return usagef("%v", err)
+		}
+	}
+	if *entropy_k < 0 {
+		return usagef("the number of entropy projections must not be negative")
+	}
+	if *hll_p > 0 {
+		if _, err := sketch.NewHLL(params.hll_p, 0); err != nil || *hll_p > 18 {
+			return usagef("HyperLogLog precision must be within [4, 18]")
+		}
+	}
+
+	// open the trace (input) file
+	scanner, err := opts.open(trace_name)
+	if err != nil {
+		return fmt.Errorf("opening in-file: %w", err)
+	}
+	defer scanner.Close()
+	if *window > 0 && !scanner.Timestamped() {
+		return usagef("time windows require a timestamped trace format (tstxt or pcap)")
+	}
+
+	// export the batches, the batchers share the connection (or file)
+	var exporter io.WriteCloser
+	max_message := 0
+	domains := make([]uint32, len(batch_sizes))
+	if *export != "" {
+		for i, batch_size := range batch_sizes {
+			if domains[i], err = exportDomain(batch_size, *window); err != nil {
+				return usagef("%v", err)
+			}
+		}
+		exporter, max_message, err = openExport(*export)
+		if err != nil {
+			return fmt.Errorf("opening export: %w", err)
+		}
+		defer exporter.Close()
+	}
+
+	// a batcher per batch size, all fed by a single pass over the trace
+	batchers := make([]*batcher, len(batch_sizes))
+	for i, batch_size := range batch_sizes {
+		bc := newBatcher(params, trace_name, batch_size, batch_labels[i])
+		batchers[i] = bc
+		// the outfiles of a batch size are keyed on it alone, not on the listed batch sizes
+		table_args := slices.Clone(args)
+		if *window <= 0 {
+			table_args[1] = batch_labels[i]
+		}
+		switch {
+		case exporter != nil && *export_as != "ipfix":
+			delta, err := sketch.NewWithEstimates(*epsilon, *delta)
+			if err != nil {
+				return usagef("%v", err)
+			}
+			delta.SetSeed(*seed)
+			e := &deltaExporter{w: exporter, delta: delta, proto: *export_as == "proto",
+				source: trace_name, domain: domains[i]}
+			if strings.HasPrefix(*export, "udp://") {
+				// a delta is never split, it is refused unless it fits in a datagram (as a delta of no flows at least)
+				e.datagram = max_message
+				if data, err := e.encode(0, 0, nil); err != nil || len(data) > e.datagram {
+					return usagef("a CMS delta of %d x %d counters (%d bytes) exceeds a UDP datagram of %d bytes, "+
+						"export it over tcp, unix or file, or raise -epsilon and -delta", delta.Depth(), delta.Width(), len(data), e.datagram)
+				}
+			}
+			bc.exporter = e
+		case exporter != nil:
+			if bc.exporter, err = report.NewExporter(exporter, domains[i], max_message); err != nil {
+				return usagef("%v", err)
+			}
+		}
+
+		// create the detailed (output) file, listing the batches and the associated flows
+		headers_meta, headers := bc.headers()
+		bc.writer, err = opts.createOf(trace_name, fmt.Sprintf("%s_%s_flows", trace_name, batch_labels[i]), table_args, headers)
+		if err != nil {
+			return fmt.Errorf("opening out-file-flows: %w", err)
+		}
+		defer bc.writer.file.Close()
+
+		// create the metadata (output) file, aggregating the data per batch
+		bc.writer_meta, err = opts.createOf(trace_name, fmt.Sprintf("%s_%s", trace_name, batch_labels[i]), table_args, headers_meta)
+		if err != nil {
+			return fmt.Errorf("opening out-file: %w", err)
+		}
+		defer bc.writer_meta.file.Close()
+
+		// create the lifetime (output) file, the distribution of the flow lifetimes in batches
+		bc.writer_life, err = opts.createOf(trace_name, fmt.Sprintf("%s_%s_lifetime", trace_name, batch_labels[i]), table_args,
+			concatMultipleSlices([][]output.Column{output.Ints("lifetime (batches)", "flows", "ongoing"), output.Floats("flows share")}))
+		if err != nil {
+			return fmt.Errorf("opening out-file-lifetime: %w", err)
+		}
+		defer bc.writer_life.file.Close()
+
+		// create the summary (output) file, summarizing the batches
+		bc.writer_summary, err = opts.createOf(trace_name, fmt.Sprintf("%s_%s_summary", trace_name, batch_labels[i]), table_args, bc.summaryHeaders())
+		if err != nil {
+			return fmt.Errorf("opening out-file-summary: %w", err)
+		}
+		defer bc.writer_summary.file.Close()
+
+		// create the sliding window (output) file, reporting the latest window every step
+		if *slide != "" {
+			bc.sliding, err = newSlider(trace_name, batch_labels[i], *slide, batch_size, *window, *panes, *epsilon, *delta)
+			if err != nil {
+				return usagef("configuring sliding windows: %v", err)
+			}
+			// a single seed for the sliding CMS of all the batch sizes
+			if *seed == 0 {
+				*seed = bc.sliding.approx.Seed()
+			}
+			bc.sliding.approx.SetSeed(*seed)
+			opts.note("seed", *seed)
+			bc.sliding.writer, err = opts.createOf(trace_name, fmt.Sprintf("%s_%s_sliding", trace_name, batch_labels[i]), table_args, bc.sliding.headers())
+			if err != nil {
+				return fmt.Errorf("opening out-file-sliding: %w", err)
+			}
+			defer bc.sliding.writer.file.Close()
+		}
+	}
+
+	// the batches are cut on this goroutine, counted by the workers and completed in order
+	pipe := newPipeline(opts.workers)
+	for _, bc := range batchers {
+		bc.pipe = pipe
+	}
+	for scanner.Scan() {
+		item := scanner.Item()
+		for _, bc := range batchers {
+			bc.add(item)
+		}
+	}
+	for _, bc := range batchers {
+		bc.finish()
+	}
+	pipe.close()
+
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("reading from in-file: %w", err) // scanning is not done properly
+	}
+	for _, bc := range batchers {
+		if bc.hll_trace != nil {
+			fmt.Printf("n_hll (merged batches of %s): %.2f\n", bc.trace_csv[1], bc.hll_trace.Estimate())
+		}
+		if bc.entropy_trace != nil {
+			fmt.Printf("entropy_est (merged batches of %s): %.4f\n", bc.trace_csv[1], bc.entropy_trace.Estimate())
+		}
+		if traffic := bc.samples[summaryMetrics[5]]; len(traffic) > 0 {
+			fmt.Printf("batches of %s saving traffic: %.2f%%", bc.trace_csv[1], 100*stats.Summarize(traffic, nil).Mean)
+			if space := bc.samples[summaryMetrics[6]]; len(space) > 0 {
+				fmt.Printf(", saving space (alpha %g): %.2f%%", bc.alpha, 100*stats.Summarize(space, nil).Mean)
+			}
+			fmt.Println()
+		}
+		bc.writeLifetimes()
+		bc.writeSummary()
+		if err := closeTables(bc.writer, bc.writer_meta, bc.writer_life, bc.writer_summary); err != nil {
+			return fmt.Errorf("writing out-file: %w", err)
+		}
+		if bc.sliding != nil {
+			if err := closeTables(bc.sliding.writer); err != nil {
+				return fmt.Errorf("writing out-file-sliding: %w", err)
+			}
+		}
+	}
+	// the outfiles do not depend on the export, so they are kept even if the export failed
+	if exporter != nil {
+		for _, bc := range batchers {
+			if bc.export_err == nil {
+				bc.export_err = bc.exporter.Flush()
+			}
+			if bc.export_err != nil {
+				return fmt.Errorf("exporting the batches of %s: %w", bc.trace_csv[1], bc.export_err)
+			}
+		}
+		if err := exporter.Close(); err != nil {
+			return fmt.Errorf("exporting the batches: %w", err)
+		}
+	}
+	return nil
+}
+
+// batchParams are shared by the batchers of all the batch sizes
+type batchParams struct {
+	window       time.Duration // cut batches by time windows, instead of batch size
+	id_length    int
+	hll_p        uint8 // precision of the HyperLogLog, 0 is off
+	entropy_k    int   // projections of the entropy sketch, 0 is off
+	counter_bits int   // 0 is the bits of B
+	saturate     bool
+	partial      string // include, drop or merge
+	percentiles  []float64
+	alpha        float64         // load factor, 0 is off
+	encoder      *report.Encoder // nil unless the batches are serialized
+}
+
+// batcher cuts the trace into the batches of a single size (or time window),
+// and writes them into the outfiles of that size
+type batcher struct {
+	*batchParams
+	batch_size int
+	cnt_length float64  // with time windows B varies, so it is computed per batch
+	trace_csv  []string // trace + batch-size specific header
+
+	// cutting the batches, in order of arrival
+	pipe    *pipeline
+	curr    *batch    // the current batch, collecting its items
+	t0      time.Time // time windows: the start of the trace
+	started bool
+	sliding *slider // nil unless sliding windows are reported
+
+	// completing the counted batches, in order of the batches
+	writer, writer_meta *table
+	writer_life         *table
+	writer_summary      *table
+	pending             *batch               // the latest full batch, held back when the partial batch is merged into it
+	hll_trace           *sketch.HLL          // the flows of the entire trace, merged from the batches
+	entropy_trace       *sketch.Entropy      // the entropy of the entire trace, merged from the batches
+	lifetimes           map[string]int       // the flows of the previous batch, along with their lifetime so far
+	ended               []int                // the number of flows per lifetime (1-based), which are gone
+	samples             map[string][]float64 // per metric, the values of the batches for the summary
+	exporter            batchExporter        // nil unless the batches are exported
+	export_err          error
+}
+
+// the metrics of the summary, theta/alpha and space are reported given alpha
+var summaryMetrics = []string{"B", "b", "beta (B/b)", "theta (1+cnt_len/id_len)", "theta/alpha",
+	"traffic (beta >= theta)", "space (beta >= theta/alpha)"}
+
+func newBatcher(params *batchParams, trace_name string, batch_size int, batch_label string) *batcher {
+	// compute the counter bit-length and corresponding theta value as a threshold
+	cnt_length := math.Ceil(math.Log2(float64(batch_size)))
+	theta := 1 + cnt_length/float64(params.id_length)
+
+	bc := &batcher{
+		batchParams: params,
+		batch_size:  batch_size,
+		cnt_length:  cnt_length,
+		trace_csv: []string{trace_name,
+			batch_label,
+			fmt.Sprintf("%d", int(cnt_length)),
+			fmt.Sprintf("%d", int(params.id_length)),
+			fmt.Sprintf("%.4f", theta)},
+		// the current batch, with 1-based index
+		// the frequency is exact, the batch counter of a fixed width is applied upon sending
+		curr: newBatch(1),
+	}
+	// estimate b per batch, and merge the batches to estimate the flows of the entire trace
+	if params.hll_p > 0 {
+		bc.hll_trace, _ = sketch.NewHLL(params.hll_p, 0)
+	}
+	if params.entropy_k > 0 {
+		bc.entropy_trace, _ = sketch.NewEntropy(params.entropy_k, 0)
+	}
+	return bc
+}
+
+// define headers for detailed and metadata files
+// the detailed file is normalized: the flows along with their batch#, the batch is described by the metadata file
+func (bc *batcher) headers() (headers_meta, headers []output.Column) {
+	headers_trace := concatMultipleSlices([][]output.Column{output.Strings("trace"), output.Ints("batch size"),
+		output.Ints("counter len", "id len"), output.Floats("theta (1+cnt_len/id_len)")})
+	headers_batch := concatMultipleSlices([][]output.Column{output.Ints("batch#", "B", "b"), output.Floats("beta (B/b)")})
+	if bc.window > 0 {
+		headers_trace[1] = output.Column{Name: "window", Kind: output.String}
+		headers_batch = concatMultipleSlices([][]output.Column{output.Ints("batch#"), output.Floats("duration (s)"),
+			output.Ints("B", "b"), output.Floats("beta (B/b)")})
+	}
+	// the width of the batch counter, the largest frequency and the number of flows that overflowed
+	headers_batch = append(headers_batch, output.Ints("counter bits", "max val", "overflows")...)
+	if bc.encoder != nil { // the bytes of the batch: raw ids, the theta model, and the real encodings
+		headers_batch = append(headers_batch, output.Ints("raw bytes", "model bytes", "fixed bytes", "varint bytes",
+			"delta bytes", "zstd bytes")...)
+	}
+	if bc.hll_p > 0 { // estimated b, along with its relative error
+		headers_batch = append(headers_batch, output.Floats("b_hll", "b_err")...)
+	}
+	// the entropy of the flow frequencies (bits), estimated along with its (absolute) error
+	headers_batch = append(headers_batch, output.Floats("entropy")...)
+	if bc.entropy_k > 0 {
+		headers_batch = append(headers_batch, output.Floats("entropy_est", "entropy_err")...)
+	}
+	// the classification of the batch by the thresholds: traffic (beta >= theta), and space (beta >= theta/alpha)
+	headers_batch = append(headers_batch, output.Ints("traffic")...)
+	if bc.alpha > 0 {
+		headers_batch = append(headers_batch, output.Ints("space")...)
+	}
+	// the churn since the previous batch
+	headers_batch = append(headers_batch, output.Ints("new", "gone")...)
+	headers_batch = append(headers_batch, output.Floats("jaccard")...)
+	// marks the partial batch, or the batch that the partial batch was merged into
+	headers_batch = append(headers_batch, output.Ints("partial")...)
+	headers_meta = concatMultipleSlices([][]output.Column{headers_trace, headers_batch})
+	headers = concatMultipleSlices([][]output.Column{output.Ints("batch#", "idx", "val"), output.Strings("key")})
+	return headers_meta, headers
+}
+
+// add the item to the current batch, cutting the batch once it is full (or its window is over)
+func (bc *batcher) add(item trace.Item) {
+	if bc.window > 0 {
+		if !bc.started { // the very first item
+			bc.t0 = item.Time
+			bc.started = true
+		}
+		// late (out of order) items are kept within the current window
+		w := trace.WindowIndex(bc.t0, item.Time, bc.window) + 1
+		if w > bc.curr.index {
+			if len(bc.curr.items) != 0 {
+				bc.cut(w)
+			}
+			bc.curr.index = w // empty windows are skipped
+		}
+	} else if len(bc.curr.items) >= bc.batch_size { // send/print the full batch
+		// next batch
+		bc.cut(bc.curr.index + 1)
+	}
+	bc.curr.items = append(bc.curr.items, item)
+
+	if bc.sliding != nil {
+		bc.sliding.add(item)
+	}
+}
+
+// the current batch is full: hand it over to be counted, and start the batch of a given index
+func (bc *batcher) cut(index int) {
+	bc.pipe.submit(bc, bc.curr, false)
+	bc.curr = newBatch(index)
+}
+
+// the remainder is the latest batch, it is partial if it is the latest time window,
+// or a batch of less than batch-size items
+func (bc *batcher) finish() {
+	if n := len(bc.curr.items); n != 0 && (bc.window > 0 || n < bc.batch_size) {
+		bc.curr.partial = true
+	}
+	bc.pipe.submit(bc, bc.curr, true)
+}
+
+// the batch is counted: send it, or hold it back in case the next one is the partial batch
+func (bc *batcher) complete(bt *batch) {
+	if bc.partial != "merge" {
+		bc.send(bt)
+		return
+	}
+	if bc.pending != nil {
+		bc.send(bc.pending)
+	}
+	bc.pending = bt
+}
+
+// the latest batch is counted: handle the remainder
+func (bc *batcher) completeLast(curr *batch) {
+	pending := bc.pending
+	switch {
+	case pending != nil && curr.partial: // merge the partial batch into the latest full batch
+		pending.merge(curr)
+		bc.send(pending)
+	case pending != nil:
+		bc.send(pending)
+		if curr.B != 0 {
+			bc.send(curr)
+		}
+	case curr.B != 0 && !(curr.partial && bc.partial == "drop"):
+		bc.send(curr)
+	}
+}
+
+// send/print the batch, full or partial
+func (bc *batcher) send(bt *batch) {
+	batch_csv := []string{fmt.Sprintf("%d", bt.index)}
+	cnt_length := bc.cnt_length
+	if bc.window > 0 {
+		cnt_length = math.Ceil(math.Log2(float64(bt.B)))
+		theta := 1 + cnt_length/float64(bc.id_length)
+		bc.trace_csv[2] = fmt.Sprintf("%d", int(cnt_length))
+		bc.trace_csv[4] = fmt.Sprintf("%.4f", theta)
+		batch_csv = append(batch_csv, fmt.Sprintf("%.6f", bt.last.Sub(bt.first).Seconds()))
+	}
+	batch_csv = append(batch_csv,
+		fmt.Sprintf("%d", bt.B),
+		fmt.Sprintf("%d", bt.b),
+		fmt.Sprintf("%.4f", float64(bt.B)/float64(bt.b)))
+
+	// apply the batch counter, counting the flows that overflowed
+	cnt := counter{bits: bc.counter_bits, saturate: bc.saturate}
+	if cnt.bits == 0 { // B itself fits, unlike in the counter len of theta (ceil(log2(B)))
+		cnt.bits = bits.Len(uint(bt.B))
+	}
+	max_val := uint64(0)
+	overflows := 0
+	for _, frequency := range bt.flows {
+		max_val = max(max_val, frequency)
+		if _, overflowed := cnt.value(frequency); overflowed {
+			overflows++
+		}
+	}
+	batch_csv = append(batch_csv,
+		fmt.Sprintf("%d", cnt.bits),
+		fmt.Sprintf("%d", max_val),
+		fmt.Sprintf("%d", overflows))
+	if bc.encoder != nil {
+		pairs := make([]report.Pair, 0, len(bt.flows))
+		for flow_id, frequency := range bt.flows {
+			value, _ := cnt.value(frequency)
+			pairs = append(pairs, report.Pair{ID: bc.encoder.HashID(flow_id), Count: value})
+		}
+		sizes := bc.encoder.Measure(pairs, bt.B, cnt.bits)
+		for _, size := range []int{sizes.Raw, sizes.Model, sizes.Fixed, sizes.Varint, sizes.Delta, sizes.Zstd} {
+			batch_csv = append(batch_csv, fmt.Sprintf("%d", size))
+		}
+	}
+	if bt.hll != nil {
+		b_hll := bt.hll.Estimate()
+		batch_csv = append(batch_csv,
+			fmt.Sprintf("%.2f", b_hll),
+			fmt.Sprintf("%.8f", (b_hll-float64(bt.b))/float64(bt.b)))
+		bc.hll_trace.Merge(bt.hll)
+	}
+	entropy := stats.Entropy(maps.Values(bt.flows))
+	batch_csv = append(batch_csv, fmt.Sprintf("%.6f", entropy))
+	if bt.entropy != nil {
+		entropy_est := bt.entropy.Estimate()
+		batch_csv = append(batch_csv,
+			fmt.Sprintf("%.6f", entropy_est),
+			fmt.Sprintf("%.6f", entropy_est-entropy))
+		bc.entropy_trace.Merge(bt.entropy)
+	}
+	// classify the batch: does batching save traffic, and space given alpha
+	beta := float64(bt.B) / float64(bt.b)
+	theta := 1 + cnt_length/float64(bc.id_length)
+	traffic := indicator(beta >= theta)
+	batch_csv = append(batch_csv, fmt.Sprintf("%d", int(traffic)))
+	bc.sample(summaryMetrics[0], float64(bt.B))
+	bc.sample(summaryMetrics[1], float64(bt.b))
+	bc.sample(summaryMetrics[2], beta)
+	bc.sample(summaryMetrics[3], theta)
+	bc.sample(summaryMetrics[5], traffic)
+	if bc.alpha > 0 {
+		space := indicator(beta >= theta/bc.alpha)
+		batch_csv = append(batch_csv, fmt.Sprintf("%d", int(space)))
+		bc.sample(summaryMetrics[4], theta/bc.alpha)
+		bc.sample(summaryMetrics[6], space)
+	}
+	added, gone, jaccard := bc.churn(bt)
+	batch_csv = append(batch_csv,
+		fmt.Sprintf("%d", added),
+		fmt.Sprintf("%d", gone),
+		fmt.Sprintf("%.6f", jaccard))
+	if bt.partial {
+		batch_csv = append(batch_csv, "1")
+	} else {
+		batch_csv = append(batch_csv, "0")
+	}
+	writeBatch(bc.writer, bc.writer_meta, bc.trace_csv, batch_csv, bt.flows)
+	if bc.exporter != nil && bc.export_err == nil { // the exact counts, in order of flow-id
+		flows := make([]report.Flow, 0, len(bt.flows))
+		for _, flow_id := range slices.Sorted(maps.Keys(bt.flows)) {
+			flows = append(flows, report.Flow{Count: bt.flows[flow_id], Key: flow_id})
+		}
+		bc.export_err = bc.exporter.Batch(uint32(bt.index), uint64(bt.B), flows)
+	}
+}
+
+// the churn between the previous batch and a given one: the number of new flows, the number of
+// flows that are gone, and the Jaccard similarity (NaN for the first batch), tracking the lifetimes
+func (bc *batcher) churn(bt *batch) (added, gone int, jaccard float64) {
+	lifetimes := make(map[string]int, len(bt.flows))
+	for id := range bt.flows {
+		lifetime, found := bc.lifetimes[id]
+		if !found {
+			added++
+		}
+		lifetimes[id] = lifetime + 1
+	}
+	for id, lifetime := range bc.lifetimes {
+		if _, found := bt.flows[id]; !found {
+			gone++
+			bc.end(lifetime)
+		}
+	}
+	jaccard = math.NaN()
+	if bc.lifetimes != nil {
+		jaccard = float64(bt.b-added) / float64(bt.b+gone)
+	}
+	bc.lifetimes = lifetimes
+	return added, gone, jaccard
+}
+
+// a flow is gone after a given lifetime
+func (bc *batcher) end(lifetime int) {
+	for len(bc.ended) < lifetime {
+		bc.ended = append(bc.ended, 0)
+	}
+	bc.ended[lifetime-1]++
+}
+
+// write the distribution of the flow lifetimes, the flows of the latest batch are ongoing
+func (bc *batcher) writeLifetimes() {
+	ongoing := []int{}
+	for _, lifetime := range bc.lifetimes {
+		bc.end(lifetime)
+		for len(ongoing) < lifetime {
+			ongoing = append(ongoing, 0)
+		}
+		ongoing[lifetime-1]++
+	}
+	total := 0
+	for _, flows := range bc.ended {
+		total += flows
+	}
+	for i, flows := range bc.ended {
+		if flows == 0 {
+			continue
+		}
+		still := 0
+		if i < len(ongoing) {
+			still = ongoing[i]
+		}
+		bc.writer_life.Write([]string{fmt.Sprintf("%d", i+1),
+			fmt.Sprintf("%d", flows),
+			fmt.Sprintf("%d", still),
+			fmt.Sprintf("%.8f", float64(flows)/float64(total))})
+	}
+}
+
+// define headers for the summary file: a row per metric
+func (bc *batcher) summaryHeaders() []output.Column {
+	headers := concatMultipleSlices([][]output.Column{output.Strings("trace"), output.Ints("batch size"), output.Strings("metric"),
+		output.Ints("count"), output.Floats("mean", "std", "min", "max")})
+	if bc.window > 0 {
+		headers[1] = output.Column{Name: "window", Kind: output.String}
+	}
+	for _, p := range bc.percentiles {
+		headers = append(headers, output.Floats("p"+strconv.FormatFloat(p, 'g', -1, 64))...)
+	}
+	return headers
+}
+
+// add the value of a metric of a batch to the summary
+func (bc *batcher) sample(metric string, value float64) {
+	if bc.samples == nil {
+		bc.samples = make(map[string][]float64)
+	}
+	bc.samples[metric] = append(bc.samples[metric], value)
+}
+
+func indicator(cond bool) float64 {
+	if cond {
+		return 1
+	}
+	return 0
+}
+
+// write the summary of the batches: B, b, beta and theta, and theta/alpha given alpha,
+// along with the fractions of the batches that save traffic (and space)
+func (bc *batcher) writeSummary() {
+	for _, metric := range summaryMetrics {
+		if (metric == summaryMetrics[4] || metric == summaryMetrics[6]) && bc.alpha == 0 {
+			continue
+		}
+		summary := stats.Summarize(bc.samples[metric], bc.percentiles)
+		row := []string{bc.trace_csv[0], bc.trace_csv[1], metric,
+			fmt.Sprintf("%d", summary.Count),
+			fmt.Sprintf("%.4f", summary.Mean),
+			fmt.Sprintf("%.4f", summary.Std),
+			fmt.Sprintf("%.4f", summary.Min),
+			fmt.Sprintf("%.4f", summary.Max)}
+		for _, value := range summary.Percentiles {
+			row = append(row, fmt.Sprintf("%.4f", value))
+		}
+		bc.writer_summary.Write(row)
+	}
+}
+
+// batch holds the delayed items of a batch
+type batch struct {
+	index       int               // 1-based index of a batch (time window)
+	items       []trace.Item      // the items of a batch, until counted
+	B           int               // number of currently delayed items within a batch
+	b           int               // number of currently delayed flows within a batch
+	flows       map[string]uint64 // key-value pairs for flow-id and frequency
+	hll         *sketch.HLL       // estimated b, if required
+	entropy     *sketch.Entropy   // estimated entropy, if required
+	first, last time.Time         // the first and the latest arrival within a batch
+	partial     bool
+}
+
+func newBatch(index int) *batch {
+	return &batch{index: index}
+}
+
+// count the flows of the batch items, estimating b as well with a HyperLogLog of a given precision,
+// and the entropy with a sketch of a given number of projections
+func (bt *batch) count(hll_p uint8, entropy_k int) {
+	bt.flows = make(map[string]uint64)
+	if hll_p > 0 {
+		bt.hll, _ = sketch.NewHLL(hll_p, 0)
+	}
+	for _, item := range bt.items {
+		bt.add(item)
+	}
+	bt.items = nil
+	if entropy_k > 0 { // the sketch is linear, a flow is added once along with its frequency
+		bt.entropy, _ = sketch.NewEntropy(entropy_k, 0)
+		for id, frequency := range bt.flows {
+			bt.entropy.Update(id, int(frequency))
+		}
+	}
+}
+
+// add an item to the batch, updating the frequency of its flow
+func (bt *batch) add(item trace.Item) {
+	// read the item-id
+	id := item.ID
+	// update the frequency
+	value, found := bt.flows[id]
+	if found {
+		bt.flows[id] = value + 1
+	} else { // new flow
+		bt.b++
+		bt.flows[id] = 1
+	}
+	bt.B++
+	if bt.hll != nil {
+		bt.hll.Add(id)
+	}
+	if bt.B == 1 {
+		bt.first = item.Time
+	}
+	if item.Time.After(bt.last) {
+		bt.last = item.Time
+	}
+}
+
+// merge a later batch into the current one
+func (bt *batch) merge(other *batch) {
+	for id, frequency := range other.flows {
+		if _, found := bt.flows[id]; !found {
+			bt.b++
+		}
+		bt.flows[id] += frequency
+	}
+	bt.B += other.B
+	if bt.hll != nil {
+		bt.hll.Merge(other.hll)
+	}
+	if bt.entropy != nil {
+		bt.entropy.Merge(other.entropy)
+	}
+	bt.last = other.last
+	bt.partial = true
+}
+
+// slider tracks the exact and estimated statistics of a sliding window
+type slider struct {
+	writer *table
+	row    []string // trace, window, step, panes
+	exact  *trace.Window
+	approx *sketch.SlidingCMS
+
+	// count-based: the latest size items, reported every step items, sliding the panes every pane items
+	size, step, pane int
+	n, in_pane       int // number of items so far, and within the newest pane
+
+	// time-based: the latest window, reported every step_dur, sliding the panes every pane_dur
+	window, step_dur, pane_dur time.Duration
+	start, next                time.Time // the first arrival and the next report
+	pane_idx                   int       // 0-based index of the newest pane
+}
+
+func newSlider(trace_name, batch_label, step string, size int, window time.Duration, panes int,
+	epsilon, delta float64) (*slider, error) {
+	approx, err := sketch.NewSlidingWithEstimates(epsilon, delta, panes)
+	if err != nil {
+		return nil, err
+	}
+	s := &slider{
+		row:    []string{trace_name, batch_label, step, fmt.Sprintf("%d", panes)},
+		exact:  trace.NewWindow(),
+		approx: approx,
+		size:   size,
+		window: window,
+	}
+	if window > 0 {
+		s.step_dur, err = time.ParseDuration(step)
+		if err != nil || s.step_dur <= 0 {
+			return nil, fmt.Errorf("bad time step %q", step)
+		}
+		s.pane_dur = (window + time.Duration(panes) - 1) / time.Duration(panes)
+	} else {
+		s.step, err = strconv.Atoi(step)
+		if err != nil || s.step <= 0 {
+			return nil, fmt.Errorf("bad step %q", step)
+		}
+		s.pane = (size + panes - 1) / panes
+	}
+	return s, nil
+}
+
+func (s *slider) headers() []output.Column {
+	position := output.Ints("item#")
+	if s.window > 0 {
+		position = output.Floats("time (s)")
+	}
+	return concatMultipleSlices([][]output.Column{output.Strings("trace", "window", "step"), output.Ints("panes"), position,
+		output.Ints("B", "b"), output.Floats("beta (B/b)", "b_est", "beta_est (B/b_est)", "mre")})
+}
+
+// add the item to the window, reporting the window whenever a step is completed
+func (s *slider) add(item trace.Item) {
+	if s.window > 0 {
+		if s.n == 0 {
+			s.start = item.Time
+			s.next = s.start.Add(s.step_dur)
+		}
+		// report all the steps completed before the arrival of this item
+		for !item.Time.Before(s.next) {
+			s.advance(s.next.Add(-1))
+			s.exact.ExpireBefore(s.next.Add(-s.window))
+			s.report(fmt.Sprintf("%.6f", s.next.Sub(s.start).Seconds()))
+			s.next = s.next.Add(s.step_dur)
+		}
+		s.advance(item.Time)
+	} else if s.in_pane == s.pane {
+		s.approx.Slide()
+		s.in_pane = 0
+	}
+	s.exact.Push(item)
+	s.approx.Update(item.ID, 1)
+	s.in_pane++
+	s.n++
+	if s.window <= 0 {
+		s.exact.ExpireCount(s.size)
+		if s.n%s.step == 0 {
+			s.report(fmt.Sprintf("%d", s.n))
+		}
+	}
+}
+
+// slide the panes of the sketch up to the pane containing t
+func (s *slider) advance(t time.Time) {
+	p := trace.WindowIndex(s.start, t, s.pane_dur)
+	for i := 0; i < min(p-s.pane_idx, s.approx.Panes()); i++ {
+		s.approx.Slide()
+	}
+	s.pane_idx = max(p, s.pane_idx)
+}
+
+// write the exact and the estimated statistics of the window
+func (s *slider) report(position string) {
+	B := s.exact.Len()
+	b := s.exact.Flows()
+	b_est := s.approx.Distinct()
+	mre := 0.0
+	for x, c_x := range s.exact.Counts() {
+		mre += float64(s.approx.Estimate(x)-c_x) / float64(c_x)
+	}
+	if b > 0 {
+		mre /= float64(b)
+	}
+	window_csv := []string{position,
+		fmt.Sprintf("%d", B),
+		fmt.Sprintf("%d", b),
+		fmt.Sprintf("%.4f", float64(B)/float64(b)),
+		fmt.Sprintf("%.2f", b_est),
+		fmt.Sprintf("%.4f", float64(B)/b_est),
+		fmt.Sprintf("%.8f", mre)}
+	s.writer.Write(concatMultipleSlices([][]string{s.row, window_csv}))
+}
+
+// counter is a batch counter of a fixed width
+type counter struct {
+	bits     int
+	saturate bool // stay at the maximal value, otherwise wrap around
+}
+
+// the value held by the counter after a given number of increments, and whether it overflowed
+func (c counter) value(frequency uint64) (uint64, bool) {
+	if c.bits >= 64 {
+		return frequency, false
+	}
+	max_val := uint64(1)<<c.bits - 1
+	if frequency <= max_val {
+		return frequency, false
+	}
+	if c.saturate {
+		return max_val, true
+	}
+	return frequency & max_val, true
+}
+
+// write the batch to the metadata file, and its flows to the detailed file
+func writeBatch(writer, writer_meta *table, trace_csv, batch_csv []string, flow_map map[string]uint64) {
+	// write to metadata file
+	data_csv_meta := concatMultipleSlices([][]string{trace_csv, batch_csv})
+	writer_meta.Write(data_csv_meta)
+
+	// write to detailed file
+	flow_index := 1 // 1-based index of a current flow within a batch
+	// iterate the flows in order of flow-id, so the outfile does not depend on the map order
+	for _, flow_id := range slices.Sorted(maps.Keys(flow_map)) {
+		flow_csv := []string{batch_csv[0], // batch#
+			fmt.Sprintf("%d", flow_index),
+			fmt.Sprintf("%d", flow_map[flow_id]),
+			flow_id}
+		writer.Write(flow_csv)
+
+		// next flow
+		flow_index++
+	}
+}
+
+// the observation domain of the exported batches: the batch size, or the window in microseconds,
+// of 32 bits (of both the IPFIX header and the BatchDelta)
+func exportDomain(batch_size int, window time.Duration) (uint32, error) {
+	if window > 0 {
+		if window.Microseconds() > math.MaxUint32 {
+			return 0, fmt.Errorf("a window of %v exceeds %v, the largest observation domain", window, math.MaxUint32*time.Microsecond)
+		}
+		return uint32(window.Microseconds()), nil
+	}
+	if batch_size > math.MaxUint32 {
+		return 0, fmt.Errorf("a batch size of %d exceeds %d, the largest observation domain", batch_size, uint32(math.MaxUint32))
+	}
+	return uint32(batch_size), nil
+}
+
+// batchExporter exports the counted batches, in order
+type batchExporter interface {
+	Batch(batch uint32, B uint64, flows []report.Flow) error
+	Flush() error
+}
+
+// deltaExporter exports each batch as a CMS delta, i.e., the snapshot of a CMS of the batch alone,
+// either binary or as a length-delimited BatchDelta message, over UDP a delta takes a single datagram
+type deltaExporter struct {
+	w        io.Writer
+	delta    *sketch.CMS
+	proto    bool
+	source   string
+	domain   uint32
+	datagram int // the largest delta over UDP, 0 over a stream (or file)
+}
+
+func (e *deltaExporter) Batch(batch uint32, B uint64, flows []report.Flow) error {
+	data, err := e.encode(batch, B, flows)
+	if err != nil {
+		return err
+	}
+	if e.datagram > 0 && len(data) > e.datagram {
+		return fmt.Errorf("the CMS delta of batch %d takes %d bytes, beyond a UDP datagram of %d bytes", batch, len(data), e.datagram)
+	}
+	_, err = e.w.Write(data)
+	return err
+}
+
+// encode the CMS delta of a batch
+func (e *deltaExporter) encode(batch uint32, B uint64, flows []report.Flow) ([]byte, error) {
+	e.delta.Clear()
+	for _, flow := range flows {
+		e.delta.Update(flow.Key, int(flow.Count))
+	}
+	var data []byte
+	var err error
+	if e.proto {
+		delta := sketch.Delta{Source: e.source, Domain: e.domain, Batch: batch, Items: B, Flows: uint64(len(flows)), CMS: e.delta}
+		if data, err = delta.MarshalProto(); err == nil {
+			data = sketch.AppendDelimited(nil, data)
+		}
+	} else {
+		data, err = e.delta.MarshalBinary()
+	}
+	return data, err
+}
+
+func (e *deltaExporter) Flush() error {
+	return nil
+}
+
+// the largest datagram of the export over UDP, so a message is not fragmented over Ethernet
+const exportDatagram = 1400
+
+// open the target of the export, returning the largest message it takes
+func openExport(target string) (io.WriteCloser, int, error) {
+	scheme, address, err := parseEndpoint(target, "udp", "tcp", "unix", "file")
+	if err != nil {
+		return nil, 0, err
+	}
+	switch scheme {
+	case "udp":
+		conn, err := net.Dial("udp", address)
+		return conn, exportDatagram, err
+	case "tcp", "unix":
+		conn, err := net.Dial(scheme, address)
+		if err != nil {
+			return nil, 0, err
+		}
+		return &bufferedCloser{bufio.NewWriter(conn), conn}, report.MaxMessage, nil
+	}
+	if err := os.MkdirAll(filepath.Dir(address), 0o755); err != nil {
+		return nil, 0, err
+	}
+	file, err := os.Create(address)
+	if err != nil {
+		return nil, 0, err
+	}
+	return &bufferedCloser{bufio.NewWriter(file), file}, report.MaxMessage, nil
+}
+
+// parse an endpoint "scheme://address" of one of the given schemes (udp, tcp or file)
+func parseEndpoint(target string, schemes ...string) (scheme, address string, err error) {
+	scheme, address, ok := strings.Cut(target, "://")
+	if !ok || address == "" || !slices.Contains(schemes, scheme) {
+		return "", "", fmt.Errorf("bad endpoint %q, expected %s://address", target, strings.Join(schemes, "|"))
+	}
+	return scheme, address, nil
+}
+
+// bufferedCloser flushes the buffer before closing the underlying writer
+type bufferedCloser struct {
+	*bufio.Writer
+	closer io.Closer
+}
+
+func (b *bufferedCloser) Close() error {
+	err := b.Flush()
+	if cerr := b.closer.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
@@ -0,0 +1,90 @@
+/***************************************************
+* Author: Diana Cohen (sch.diana@gmail.com)
+* **************************************************
+* test the pipeline of batch: the outfiles of a pool of workers are byte-identical to those of
+* the sequential path (a single worker), for batches of items and of time windows,
+* on small fixture traces whose last batch is partial
+ */
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// write a fixture trace of Zipf flows into the data directory, timestamped (1ms apart) if tstxt
+func writeFixture(t *testing.T, data_dir, name string, items int, timestamped bool) {
+	t.Helper()
+	r := rand.New(rand.NewSource(7))
+	zipf := rand.NewZipf(r, 1.2, 1, 500)
+	var b strings.Builder
+	for i := 0; i < items; i++ {
+		if timestamped {
+			fmt.Fprintf(&b, "%d.%06d ", i/1000, i%1000*1000)
+		}
+		fmt.Fprintf(&b, "flow%d\n", zipf.Uint64())
+	}
+	if err := os.WriteFile(filepath.Join(data_dir, name+".txt"), []byte(b.String()), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// the outfiles of a directory (by the relative path), the metadata sidecars excluded (they hold the times)
+func readOutfiles(t *testing.T, dir string) map[string][]byte {
+	t.Helper()
+	files := make(map[string][]byte)
+	err := filepath.WalkDir(dir, func(path string, d os.DirEntry, err error) error {
+		if err != nil || d.IsDir() || strings.HasSuffix(path, ".meta.json") {
+			return err
+		}
+		rel, _ := filepath.Rel(dir, path)
+		files[rel], err = os.ReadFile(path)
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return files
+}
+
+func TestBatchWorkers(t *testing.T) {
+	data_dir := t.TempDir()
+	writeFixture(t, data_dir, "zipf", 2345, false)
+	writeFixture(t, data_dir, "tszipf", 2345, true)
+	tests := []struct {
+		name string
+		args []string
+	}{
+		{"batch sizes", []string{"-hll", "10", "-entropy", "16", "zipf", "100,333", "64"}}, // last batches of 45 and 14 items
+		{"sliding", []string{"-slide", "30", "-seed", "7", "zipf", "250", "64"}},
+		{"time windows", []string{"-format", "tstxt", "-window", "100ms", "-hll", "10", "tszipf", "64"}}, // the last window of 45ms
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var outfiles []map[string][]byte
+			for _, workers := range []string{"1", "4"} {
+				out_dir := t.TempDir()
+				args := append([]string{"-data-dir", data_dir, "-out-dir", out_dir, "-workers", workers}, test.args...)
+				if err := runBatch(&session{}, args); err != nil {
+					t.Fatalf("-workers %s: %v", workers, err)
+				}
+				outfiles = append(outfiles, readOutfiles(t, out_dir))
+			}
+			sequential, pooled := outfiles[0], outfiles[1]
+			if len(sequential) == 0 || len(sequential) != len(pooled) {
+				t.Fatalf("%d outfiles of a single worker, %d of 4 workers", len(sequential), len(pooled))
+			}
+			for name, data := range sequential {
+				if !bytes.Equal(data, pooled[name]) {
+					t.Errorf("%s differs between -workers 1 and -workers 4", name)
+				}
+			}
+		})
+	}
+}
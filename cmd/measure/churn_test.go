@@ -0,0 +1,67 @@
+/***************************************************
+* Author: Diana Cohen (sch.diana@gmail.com)
+* **************************************************
+* test the churn between consecutive batches (the new, the departed and the persisting flows),
+* and the distribution of the flow lifetimes, on a hand-built sequence of three batches
+ */
+
+package main
+
+import (
+	"bytes"
+	"math"
+	"testing"
+
+	"github.com/DianaCohenCS/measure-traces/output"
+	"github.com/DianaCohenCS/measure-traces/trace"
+)
+
+// a counted batch of the given flow-ids (an item each)
+func batchOf(index int, ids ...string) *batch {
+	bt := newBatch(index)
+	for _, id := range ids {
+		bt.items = append(bt.items, trace.Item{ID: id})
+	}
+	bt.count(0, 0)
+	return bt
+}
+
+func TestChurn(t *testing.T) {
+	var buf bytes.Buffer
+	writer, err := output.NewWriter(&buf, output.FormatCSV,
+		concatMultipleSlices([][]output.Column{output.Ints("lifetime (batches)", "flows", "ongoing"), output.Floats("flows share")}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	bc := &batcher{writer_life: &table{Writer: writer}}
+
+	tests := []struct {
+		batch       *batch
+		added, gone int
+		jaccard     float64
+	}{
+		{batchOf(1, "a", "b", "c"), 3, 0, math.NaN()},   // no previous batch
+		{batchOf(2, "b", "c", "d", "d"), 1, 1, 2.0 / 4}, // d is new, a is gone, b and c persist
+		{batchOf(3, "c", "d", "e", "f"), 2, 1, 2.0 / 5}, // e and f are new, b is gone (after 2 batches)
+	}
+	for _, test := range tests {
+		added, gone, jaccard := bc.churn(test.batch)
+		if added != test.added || gone != test.gone || !(jaccard == test.jaccard || math.IsNaN(jaccard) && math.IsNaN(test.jaccard)) {
+			t.Errorf("batch %d: added %d, gone %d, jaccard %v, expected %d, %d, %v",
+				test.batch.index, added, gone, jaccard, test.added, test.gone, test.jaccard)
+		}
+	}
+
+	// gone: a (1 batch), b (2 batches), ongoing: c (3 batches), d (2 batches), e and f (1 batch)
+	bc.writeLifetimes()
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+	want := "lifetime (batches),flows,ongoing,flows share\n" +
+		"1,3,2,0.50000000\n" +
+		"2,2,1,0.33333333\n" +
+		"3,1,1,0.16666667\n"
+	if buf.String() != want {
+		t.Errorf("lifetimes:\n%s\nexpected:\n%s", buf.String(), want)
+	}
+}
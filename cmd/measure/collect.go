@@ -0,0 +1,343 @@
+/***************************************************
+* Author: Diana Cohen (sch.diana@gmail.com)
+* **************************************************
+* a long-running collector of batch reports, accumulating them into a single CMS:
+* the reports arrive over a local socket (TCP, UDP or a unix socket), either as the flow-count tables of
+* the batches (IPFIX-like messages of measure batch -export, see package report), whose flows update the CMS,
+* or as CMS deltas (snapshots of the batches, measure batch -export-as cms or proto), which are merged into the CMS
+* a stream may interleave them, a report is told by its first bytes: IPFIX starts by a zero byte (the version),
+* a binary snapshot by its magic, and otherwise it is a length-delimited BatchDelta message (proto/sketch.proto)
+* the accumulated CMS is snapshotted periodically (and at exit) into a file, and restored from it at start,
+* the heavy hitters are tracked by the keys of the flow-count tables, they are not restored
+* the queries are served over HTTP (see package server), along with the counters of the reports at /ingest
+* the collector runs until interrupted (SIGINT or SIGTERM)
+ */
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/DianaCohenCS/measure-traces/report"
+	"github.com/DianaCohenCS/measure-traces/server"
+	"github.com/DianaCohenCS/measure-traces/sketch"
+)
+
+func runCollect(s *session, args []string) error {
+	fs, opts := newFlagSet(s, "collect", []string{""},
+		"Collect the batch reports (flow-count tables or CMS deltas) over a local socket into an accumulated CMS,\n"+
+			"snapshot it periodically, and serve the point and top-k queries over HTTP, until interrupted.")
+	listen := fs.String("listen", "tcp://127.0.0.1:4739", "accept the reports on a local socket: tcp://host:port, udp://host:port or unix://path")
+	http_addr := fs.String("http", "127.0.0.1:8080", "serve the queries over HTTP on a local address")
+	epsilon := fs.Float64("epsilon", 0.001, "error rate of the CMS")
+	delta := fs.Float64("delta", 0.01, "confidence of the CMS")
+	seed := fs.Uint64("seed", 0, "seed of the CMS hash functions (the CMS deltas must share it), 0 draws a random seed")
+	top_k := fs.Int("top", 100, "number of heavy hitters to track, 0 is off")
+	snapshot := fs.String("snapshot", "", "the snapshot file, restored at start if it exists (default: [out-dir]/collector.cms)")
+	every := fs.Duration("snapshot-every", time.Minute, "the period of the snapshots, 0 snapshots only at exit")
+	args, err := opts.parse(args)
+	if err != nil {
+		return err
+	}
+	if len(args) != 0 {
+		return usagef("no arguments expected")
+	}
+	if *top_k < 0 {
+		return usagef("the number of heavy hitters must not be negative")
+	}
+	if *every < 0 {
+		return usagef("the snapshot period must not be negative")
+	}
+	scheme, address, err := parseEndpoint(*listen, "tcp", "udp", "unix")
+	if err != nil {
+		return usagef("%v", err)
+	}
+	if *snapshot == "" {
+		*snapshot = filepath.Join(opts.out_dir, "collector.cms")
+	}
+
+	// the accumulated CMS, either restored or a new one
+	cms, err := sketch.NewWithEstimates(*epsilon, *delta)
+	if err != nil {
+		return usagef("%v", err)
+	}
+	if *seed != 0 {
+		cms.SetSeed(*seed)
+	}
+	data, err := os.ReadFile(*snapshot)
+	switch {
+	case err == nil:
+		if err := cms.UnmarshalBinary(data); err != nil {
+			return fmt.Errorf("restoring %s: %w", *snapshot, err)
+		}
+		if *seed != 0 && cms.Seed() != *seed {
+			return usagef("the snapshot %s has seed %d, not %d", *snapshot, cms.Seed(), *seed)
+		}
+		fmt.Fprintf(os.Stderr, "measure collect: restored %s, %d x %d counters, total %d\n", *snapshot, cms.Depth(), cms.Width(), cms.Total())
+	case !errors.Is(err, os.ErrNotExist):
+		return fmt.Errorf("restoring %s: %w", *snapshot, err)
+	}
+	sk, err := server.NewSketch(cms, *top_k)
+	if err != nil {
+		return usagef("%v", err)
+	}
+	c := &collector{sketch: sk}
+
+	// accept the reports
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	var closer io.Closer
+	if scheme == "udp" {
+		conn, err := net.ListenPacket("udp", address)
+		if err != nil {
+			return err
+		}
+		if udp, ok := conn.(*net.UDPConn); ok {
+			udp.SetReadBuffer(8 << 20)
+		}
+		closer = conn
+		go c.serveDatagrams(conn)
+	} else {
+		listener, err := net.Listen(scheme, address)
+		if err != nil {
+			return err
+		}
+		closer = listener
+		go c.accept(listener)
+	}
+	defer closer.Close()
+
+	// serve the queries
+	mux := http.NewServeMux()
+	mux.Handle("/", server.NewHandler(sk))
+	mux.HandleFunc("GET /ingest", func(w http.ResponseWriter, r *http.Request) { server.WriteJSON(w, http.StatusOK, c.ingest()) })
+	http_listener, err := net.Listen("tcp", *http_addr)
+	if err != nil {
+		return err
+	}
+	srv := &http.Server{Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+	go srv.Serve(http_listener)
+	defer srv.Close()
+	fmt.Fprintf(os.Stderr, "measure collect: seed %d, accepting reports on %s, serving http://%s\n", cms.Seed(), *listen, http_listener.Addr())
+
+	// snapshot periodically, and at exit
+	var tick <-chan time.Time
+	if *every > 0 {
+		ticker := time.NewTicker(*every)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+	for {
+		select {
+		case <-tick:
+			if err := c.snapshot(*snapshot); err != nil {
+				fmt.Fprintf(os.Stderr, "measure collect: snapshot: %v\n", err)
+			}
+		case <-ctx.Done():
+			closer.Close()
+			srv.Shutdown(context.Background())
+			if err := c.snapshot(*snapshot); err != nil {
+				return fmt.Errorf("snapshot: %w", err)
+			}
+			in := c.ingest()
+			fmt.Fprintf(os.Stderr, "measure collect: %d messages (%d flows of %d batches, %d lost records), %d deltas (%d rejected), snapshot %s\n",
+				in.Messages, in.Flows, in.Batches, in.Lost, in.Deltas, in.Rejected, *snapshot)
+			return nil
+		}
+	}
+}
+
+// collector accumulates the reports into a sketch, the counters are updated by the connections concurrently
+type collector struct {
+	sketch   *server.Sketch
+	messages atomic.Uint64 // IPFIX-like messages
+	flows    atomic.Uint64 // flow records
+	batches  atomic.Uint64 // batch records
+	lost     atomic.Uint64 // data records, by the gaps of the sequence numbers
+	deltas   atomic.Uint64 // CMS deltas merged
+	rejected atomic.Uint64 // CMS deltas that do not match the sketch
+	errors   atomic.Uint64 // bad reports, the stream is dropped
+}
+
+// ingestStats are the counters of the reports
+type ingestStats struct {
+	Messages uint64 `json:"messages"`
+	Flows    uint64 `json:"flows"`
+	Batches  uint64 `json:"batches"`
+	Lost     uint64 `json:"lost"`
+	Deltas   uint64 `json:"deltas"`
+	Rejected uint64 `json:"rejected"`
+	Errors   uint64 `json:"errors"`
+}
+
+func (c *collector) ingest() ingestStats {
+	return ingestStats{c.messages.Load(), c.flows.Load(), c.batches.Load(), c.lost.Load(),
+		c.deltas.Load(), c.rejected.Load(), c.errors.Load()}
+}
+
+// accept the streams, each one on a goroutine of its own
+func (c *collector) accept(listener net.Listener) {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return // closed
+		}
+		go func() {
+			defer conn.Close()
+			if err := c.serveStream(conn); err != nil {
+				c.errors.Add(1)
+				fmt.Fprintf(os.Stderr, "measure collect: %s: %v\n", conn.RemoteAddr(), err)
+			}
+		}()
+	}
+}
+
+// the kinds of reports
+const (
+	reportIPFIX = iota
+	reportSnapshot
+	reportProto
+)
+
+// tell the kind of a report by its first bytes
+func reportKind(prefix []byte) int {
+	switch {
+	case bytes.HasPrefix(prefix, []byte(sketch.SnapshotMagic)):
+		return reportSnapshot
+	case len(prefix) > 0 && prefix[0] == 0: // the high byte of the IPFIX version
+		return reportIPFIX
+	}
+	return reportProto
+}
+
+// read the reports of a stream until it is over
+func (c *collector) serveStream(r io.Reader) error {
+	br := bufio.NewReader(r)
+	decoder := report.NewDecoder()
+	seqs := make(map[uint32]uint32) // domain -> the next sequence number
+	for {
+		prefix, err := br.Peek(len(sketch.SnapshotMagic))
+		if len(prefix) == 0 {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+		switch reportKind(prefix) {
+		case reportSnapshot:
+			delta, err := sketch.ReadSnapshot(br)
+			if err != nil {
+				return err
+			}
+			c.merge(delta)
+		case reportProto:
+			msg, err := sketch.ReadDelimited(br)
+			if err != nil {
+				return err
+			}
+			var delta sketch.Delta
+			if err := delta.UnmarshalProto(msg); err != nil {
+				return err
+			}
+			c.merge(delta.CMS)
+		default:
+			m, err := decoder.ReadMessage(br)
+			if err != nil {
+				return err
+			}
+			c.update(m, seqs)
+		}
+	}
+}
+
+// read the reports of the datagrams, a report per datagram
+func (c *collector) serveDatagrams(conn net.PacketConn) {
+	buf := make([]byte, 1<<16)
+	decoder := report.NewDecoder()
+	seqs := make(map[uint32]uint32)
+	for {
+		n, addr, err := conn.ReadFrom(buf)
+		if errors.Is(err, net.ErrClosed) {
+			return
+		}
+		if err != nil {
+			continue
+		}
+		data := buf[:n]
+		switch reportKind(data) {
+		case reportSnapshot:
+			delta := &sketch.CMS{}
+			if err = delta.UnmarshalBinary(data); err == nil {
+				c.merge(delta)
+			}
+		case reportProto:
+			var msg []byte
+			if msg, err = sketch.ReadDelimited(bytes.NewReader(data)); err == nil {
+				var delta sketch.Delta
+				if err = delta.UnmarshalProto(msg); err == nil {
+					c.merge(delta.CMS)
+				}
+			}
+		default:
+			var m *report.Message
+			if m, err = decoder.Decode(data); err == nil {
+				c.update(m, seqs)
+			}
+		}
+		if err != nil {
+			c.errors.Add(1)
+			fmt.Fprintf(os.Stderr, "measure collect: %s: %v\n", addr, err)
+		}
+	}
+}
+
+// update the sketch by the flows of a message
+func (c *collector) update(m *report.Message, seqs map[uint32]uint32) {
+	if next, found := seqs[m.Domain]; found && m.Seq > next {
+		c.lost.Add(uint64(m.Seq - next))
+	}
+	seqs[m.Domain] = max(seqs[m.Domain], m.Seq+m.Records)
+	updates := make([]server.Update, len(m.Flows))
+	for i, flow := range m.Flows {
+		updates[i] = server.Update{Key: flow.Key, Count: int(flow.Count)}
+	}
+	c.sketch.UpdateBatch(updates)
+	c.messages.Add(1)
+	c.flows.Add(uint64(len(m.Flows)))
+	c.batches.Add(uint64(len(m.Batches)))
+}
+
+// merge a CMS delta into the sketch
+func (c *collector) merge(delta *sketch.CMS) {
+	if err := c.sketch.Merge(delta); err != nil {
+		c.rejected.Add(1)
+		fmt.Fprintf(os.Stderr, "measure collect: rejecting a CMS delta: %v\n", err)
+		return
+	}
+	c.deltas.Add(1)
+}
+
+// write a snapshot of the sketch, atomically
+func (c *collector) snapshot(path string) error {
+	data, err := c.sketch.Snapshot()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	return writeFileAtomic(path, data)
+}
@@ -0,0 +1,107 @@
+/***************************************************
+* Author: Diana Cohen (sch.diana@gmail.com)
+* **************************************************
+* test the collector: the batches of a trace exported into a file (as flow-count tables or CMS deltas)
+* are collected into a CMS whose point queries equal those of a CMS updated directly by the trace,
+* and a CMS delta beyond a UDP datagram is refused rather than sent
+ */
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/DianaCohenCS/measure-traces/report"
+	"github.com/DianaCohenCS/measure-traces/server"
+	"github.com/DianaCohenCS/measure-traces/sketch"
+)
+
+// a CMS of the params of the exported deltas
+func newCollectedCMS(t *testing.T) *sketch.CMS {
+	t.Helper()
+	cms, err := sketch.NewWithEstimates(0.01, 0.1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cms.SetSeed(7)
+	return cms
+}
+
+func TestCollectExport(t *testing.T) {
+	data_dir := t.TempDir()
+	writeFixture(t, data_dir, "zipf", 2345, false) // 24 batches of 100, the last one of 45 items
+
+	// the CMS of the trace, updated directly by its items
+	direct := newCollectedCMS(t)
+	keys := map[string]bool{}
+	file, err := os.Open(filepath.Join(data_dir, "zipf.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	for lines := bufio.NewScanner(file); lines.Scan(); {
+		direct.Update(lines.Text(), 1)
+		keys[lines.Text()] = true
+	}
+
+	for _, export_as := range []string{"ipfix", "cms", "proto"} {
+		t.Run(export_as, func(t *testing.T) {
+			export := filepath.Join(t.TempDir(), "export")
+			err := runBatch(&session{}, []string{"-data-dir", data_dir, "-out-dir", t.TempDir(), "-workers", "4",
+				"-export", "file://" + export, "-export-as", export_as, "-epsilon", "0.01", "-delta", "0.1", "-seed", "7",
+				"zipf", "100", "64"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			sk, _ := server.NewSketch(newCollectedCMS(t), 0)
+			c := &collector{sketch: sk}
+			data, err := os.ReadFile(export)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := c.serveStream(bytes.NewReader(data)); err != nil {
+				t.Fatal(err)
+			}
+
+			stats := c.ingest()
+			if export_as == "ipfix" && stats.Batches != 24 || export_as != "ipfix" && stats.Deltas != 24 || stats.Rejected != 0 {
+				t.Errorf("ingested %+v, expected 24 batches", stats)
+			}
+			for key := range keys {
+				if estimate, _ := sk.Estimate(key); estimate != direct.Estimate(key) {
+					t.Errorf("the estimate of %s: collected %d, direct %d", key, estimate, direct.Estimate(key))
+				}
+			}
+			if estimate, _ := sk.Estimate("absent"); estimate != direct.Estimate("absent") {
+				t.Errorf("the estimate of an absent key: collected %d, direct %d", estimate, direct.Estimate("absent"))
+			}
+		})
+	}
+}
+
+func TestDeltaDatagram(t *testing.T) {
+	delta, _ := sketch.New(1, 1300)
+	delta.SetSeed(7)
+	var buf bytes.Buffer
+	e := &deltaExporter{w: &buf, delta: delta, proto: true, source: "zipf", datagram: exportDatagram}
+
+	// zero counters take a byte each, large counters take several
+	if err := e.Batch(1, 1, []report.Flow{{Count: 1, Key: "flow"}}); err != nil || buf.Len() > exportDatagram {
+		t.Fatalf("a delta of %d bytes, error %v", buf.Len(), err)
+	}
+	var flows []report.Flow
+	for i := 0; i < 1000; i++ {
+		flows = append(flows, report.Flow{Count: 1 << 20, Key: fmt.Sprintf("flow%d", i)})
+	}
+	sent := buf.Len()
+	err := e.Batch(2, 1000<<20, flows)
+	if err == nil || !strings.Contains(err.Error(), "datagram") || buf.Len() != sent {
+		t.Errorf("a delta beyond a datagram: error %v, %d bytes sent", err, buf.Len()-sent)
+	}
+}
@@ -0,0 +1,377 @@
+/***************************************************
+* Author: Diana Cohen (sch.diana@gmail.com)
+* **************************************************
+* handle the trace using batches, measuring estimation error after recovery
+* ability to recover the latest backup, where only the last batch is lost
+* latest backup is at time (batch) t, at time t+1 we discover a crash (up to B items have been lost)
+* N - number of overall items within a trace: data dependent, discover N on first pass
+* B - batch size: 100, 500, 1000, 4000
+* failed batch of a trace, as a percentile: 1/3, 1/2 or 2/3
+* failed item of a failed batch, as a percentile: 0.1, 0.5 or 0.9
+
+* true values are handled by maps:
+* - flow_map from the beginning of a trace up until the crash
+* - curr_map of the current batch (can find out exctly how many losses)
+* estimations are handled by Count-Min Sketches:
+* - cms_curr of the current batch, and
+* - cms_hist from the beginning of a trace up until the current batch
+
+* for each flow x (from the beginning):
+* - true frequency at time of crash: flow_map[x]
+* - estimation after recovery: cms_hist.Estimate(x) + B
+* - estimation at time of crash: cms_hist.Estimate(x) + cms_curr.Estimate(x)
+
+* with time windows (-window), a batch holds the items of a time window, and a crash loses a window:
+* - the failed batch is a percentile of the windows, Nt is the first item of the failed window
+* - the failed item is a percentile of the items within the failed window
+* - B is the number of items within the failed window, the bound added upon recovery
+* - the empty windows are skipped, as in trace_batch, so a crash never loses an empty window
+
+* the memory of the CMS is derived either from (epsilon, delta), or from a budget in bytes (-memory),
+* the counters are of a fixed width (-counter-bits), the memory is written alongside the error columns:
+* d, w, counter bits, bytes and saturations (of a counter at its maximal value)
+
+* the partial (last) batch, of less than B items or the latest time window, is either
+* dropped (by default, as in the original est_err_batch: q = N/B), included, or merged into the previous
+* batch (-partial): the crash points are the percentiles of the resulting batches, where B is the number
+* of items within the failed batch, and the rows of a partial (or merged) failed batch are marked
+ */
+
+package main
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/DianaCohenCS/measure-traces/output"
+	"github.com/DianaCohenCS/measure-traces/sketch"
+	"github.com/DianaCohenCS/measure-traces/trace"
+)
+
+func runError(s *session, args []string) error {
+	/* ****************************************
+	** handle arguments
+	**************************************** */
+	fs, opts := newFlagSet(s, "error", []string{"[trace-name] [batch-size]", "-window [duration] [trace-name]"},
+		"Handle the trace using batches, measuring the estimation error of a Count-Min Sketch\n"+
+			"after recovery from a crash that loses the latest batch (or time window).")
+	window := fs.Duration("window", 0, "lose a time window (e.g. 100ms) instead of batch-size items")
+	partial := fs.String("partial", "drop", "the partial (last) batch: drop, include or merge (into the previous batch)")
+	epsilon := fs.Float64("epsilon", math.Pow10(-6), "error rate of the CMS")
+	delta := fs.Float64("delta", math.Pow10(-2), "confidence of the CMS")
+	var memory byteSize
+	fs.Var(&memory, "memory", "memory budget of the CMS in bytes (e.g. 64KiB), deriving w instead of epsilon, 0 is off")
+	counter_bits := fs.Int("counter-bits", sketch.DefaultCounterBits, "width of a CMS counter: 16, 32 or 64")
+	seed := fs.Uint64("seed", 0, "seed of the CMS hash functions, 0 draws a random seed (recorded in the metadata)")
+	failed_batches_arg := floatList{1 / 3.0, 0.5, 2 / 3.0}
+	fs.Var(&failed_batches_arg, "failed-batches", "emulate crash after: the failed batch, as a fraction of the batches")
+	failed_items_arg := floatList{0.1, 0.5, 0.9}
+	fs.Var(&failed_items_arg, "failed-items", "emulate crash after: the failed item, as a fraction of the failed batch")
+	args, err := opts.parse(args)
+	if err != nil {
+		return err
+	}
+	if *window > 0 && len(args) != 1 {
+		return usagef("expected [trace-name] with -window")
+	}
+	if *window <= 0 && len(args) != 2 {
+		return usagef("expected [trace-name] [batch-size]")
+	}
+	// get the trace-name and batch-size (or window)
+	trace_name := args[0]
+	B := 0
+	batch_label := window.String()
+	if *window <= 0 {
+		B, err = strconv.Atoi(args[1])
+		if err != nil || B <= 0 {
+			return usagef("bad batch size %q", args[1])
+		}
+		batch_label = args[1]
+	}
+	if *partial != "include" && *partial != "drop" && *partial != "merge" {
+		return usagef("partial must be include, drop or merge")
+	}
+	if memory > 0 { // the outfile per memory budget
+		batch_label = fmt.Sprintf("%s_%s", batch_label, memory.String())
+	}
+
+	/* ****************************************
+	** define constants
+	**************************************** */
+	// emulate crash after
+	failed_batches := make([]float32, len(failed_batches_arg))
+	for i, fb := range failed_batches_arg {
+		if fb < 0 || fb >= 1 || (i > 0 && fb < failed_batches_arg[i-1]) {
+			return usagef("failed batches must be ascending, in range of [0, 1)")
+		}
+		failed_batches[i] = float32(fb)
+	}
+	failed_items := make([]float32, len(failed_items_arg))
+	for i, fi := range failed_items_arg {
+		if fi < 0 || fi > 1 || (i > 0 && fi < failed_items_arg[i-1]) {
+			return usagef("failed items must be ascending, in range of [0, 1]")
+		}
+		failed_items[i] = float32(fi)
+	}
+	// define header for file: Nt - latest backup item, Ni - latest non-failed item
+	headers_meta := output.Ints("N", "n")
+	if *window > 0 { // the failed window and the number of its items
+		headers_meta = append(headers_meta, output.Ints("window#", "B")...)
+	}
+	headers_meta = concatMultipleSlices([][]output.Column{headers_meta,
+		output.Ints("Nt", "Ni"),
+		output.Floats("rec_cms", "rec_true", "cms_true", "hist_true"),
+		output.Ints("partial"),
+		// the memory of the CMS
+		output.Ints("d", "w", "counter bits", "bytes", "saturations")})
+
+	/* ****************************************
+	** get stream size N by counting non-empty lines
+	**************************************** */
+	// open the trace (input) file
+	scanner, err := opts.open(trace_name)
+	if err != nil {
+		return fmt.Errorf("opening in-file: %w", err)
+	}
+	defer scanner.Close()
+	if *window > 0 && !scanner.Timestamped() {
+		return usagef("time windows require a timestamped trace format (tstxt or pcap)")
+	}
+
+	// get N by counting non-empty lines
+	// with time windows, count the items of each window as well
+	N := 0                   // number of items within a stream
+	window_counts := []int{} // number of items within each window, the empty ones are skipped below
+	var t0 time.Time         // the start of the first window
+	for scanner.Scan() {
+		// read the item-id
+		item := scanner.Item()
+		if len(strings.TrimSpace(item.ID)) > 0 {
+			if *window > 0 {
+				if N == 0 {
+					t0 = item.Time
+				}
+				w := max(trace.WindowIndex(t0, item.Time, *window), len(window_counts)-1)
+				for len(window_counts) <= w {
+					window_counts = append(window_counts, 0)
+				}
+				window_counts[w]++
+			}
+			N++
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("reading from in-file: %w", err) // scanning is not done properly
+	}
+	if N == 0 {
+		return fmt.Errorf("in-file contains no data")
+	}
+
+	/* ****************************************
+	** prepare out-file
+	**************************************** */
+	// create the metadata (output) file, aggregating the data per failing item
+	writer_meta, err := opts.create(trace_name, fmt.Sprintf("%s_%s_error", trace_name, batch_label), headers_meta)
+	if err != nil {
+		return fmt.Errorf("opening out-file: %w", err)
+	}
+	defer writer_meta.file.Close()
+
+	/* ****************************************
+	** create data structures to track the trace
+	**************************************** */
+	// Creating a map using make() function.
+	// key-value pairs for flow-id (string) and frequency (integer)
+	flow_map := make(map[string]int) // overall
+	curr_map := make(map[string]int) // within a failed batch
+	// the accumulative CMS, of the dimensions of (epsilon, delta) or of the memory budget, allocated once
+	var cms_hist *sketch.CMS
+	d, w, err := sketch.Dimensions(*epsilon, *delta)
+	if err == nil && memory > 0 {
+		cms_hist, err = sketch.NewWithMemory(int(memory), *delta, *counter_bits)
+	} else if err == nil {
+		cms_hist, err = sketch.NewWithWidth(d, w, *counter_bits)
+	}
+	if err != nil {
+		return usagef("%v", err)
+	}
+	if *seed != 0 {
+		cms_hist.SetSeed(*seed)
+	}
+	depth := cms_hist.Depth() // matrix dimensions based on (epsilon, delta), or on the memory budget
+	width := cms_hist.Width()
+	opts.note("seed", cms_hist.Seed())
+	opts.note("d", depth)
+	opts.note("w", width)
+	if memory > 0 {
+		fmt.Printf("memory: %s, δ: %f -> d: %d, w: %d (ε: %f)\n", memory.String(), *delta, depth, width, math.E/float64(width))
+	} else {
+		fmt.Printf("ε: %f, δ: %f -> d: %d, w: %d\n", *epsilon, *delta, depth, width)
+	}
+
+	// the number of items within each batch, the last one is partial
+	// with time windows, the batches are the non-empty windows, along with their 1-based indices
+	var batch_counts, window_ids []int
+	for w, cnt := range window_counts {
+		if cnt != 0 {
+			batch_counts = append(batch_counts, cnt)
+			window_ids = append(window_ids, w+1)
+		}
+	}
+	if *window <= 0 {
+		batch_counts = make([]int, N/B, N/B+1)
+		for i := range batch_counts {
+			batch_counts[i] = B
+		}
+		if N%B != 0 {
+			batch_counts = append(batch_counts, N%B)
+		}
+	}
+	partial_idx := -1 // 0-based index of the partial (or merged) batch
+	has_partial := *window > 0 || N%B != 0
+	if last := len(batch_counts) - 1; has_partial {
+		switch {
+		case *partial == "drop" || (*partial == "merge" && last == 0):
+			batch_counts = batch_counts[:last]
+		case *partial == "merge":
+			batch_counts[last-1] += batch_counts[last]
+			batch_counts = batch_counts[:last]
+			partial_idx = last - 1
+		default:
+			partial_idx = last
+		}
+	}
+	q := len(batch_counts) // the number of batches
+	if q == 0 {
+		return fmt.Errorf("in-file contains no whole batch")
+	}
+	item_idx := 0 // latest item# before crash
+	// second round
+	// back to the beginning of the file
+	scanner.Close()
+	scanner, err = opts.open(trace_name)
+	if err != nil {
+		return fmt.Errorf("opening in-file: %w", err)
+	}
+	defer scanner.Close()
+
+	for _, fb := range failed_batches { // don't care about index
+		t := int(float32(q) * fb) // latest backup batch#
+		B = batch_counts[t]       // up to B items are lost with the failed batch
+		Nt := 0                   // latest backup item#
+		for _, cnt := range batch_counts[:t] {
+			Nt += cnt
+		}
+
+		// fill the CMS up to the latest backup
+		for item_idx < Nt {
+			// readline from file into id
+			if !scanner.Scan() {
+				return fmt.Errorf("in-file ended before item %d: %v", Nt, scanner.Err())
+			}
+			id := scanner.Item().ID
+			if len(strings.TrimSpace(id)) > 0 {
+				// update the frequency
+				flow_map[id]++         // true frequency
+				cms_hist.Update(id, 1) // CMS after recovery = latest backup
+				item_idx++
+			}
+		}
+
+		// handle failed batch using cms_curr
+		cms_curr, _ := sketch.NewWithWidth(depth, width, *counter_bits) // tmp CMS for current batch
+		cms_curr.CopySeeds(cms_hist)                                    // use the same seeds for all
+		for _, fi := range failed_items {
+			Ni := Nt + int(float32(B)*fi) // latest item# before crash
+
+			// this is a failed batch
+			for item_idx < Ni {
+				// readline from file into id
+				if !scanner.Scan() {
+					return fmt.Errorf("in-file ended before item %d: %v", Ni, scanner.Err())
+				}
+				id := scanner.Item().ID
+				if len(strings.TrimSpace(id)) > 0 {
+					// update the frequency
+					flow_map[id]++         // true frequency until crash
+					curr_map[id]++         // true frequency within the batch
+					cms_curr.Update(id, 1) // diff matrix of lost batch
+					item_idx++
+
+					//fmt.Printf("id: %s, true: %d, extimation: %d\n", id, flow_map[id], cms_hist.Estimate(id)+cms_curr.Estimate(id))
+				}
+			}
+
+			// mark the partial batch
+			partial_csv := "0"
+			if t == partial_idx {
+				partial_csv = "1"
+			}
+
+			// EVALUATE ERROR
+			rec_cms := 0.0
+			rec_true := 0.0
+			cms_true := 0.0
+			hist_true := 0.0
+			n := 0 // number of keys in map
+			// iterate map using for range loop
+			//for x, c_x := range curr_map {
+			for x, c_x := range flow_map {
+				history_c_x := cms_hist.Estimate(x)                         // estimation of latest backup
+				hat_c_x := history_c_x + cms_curr.Estimate(x)               // estimation up until the crash
+				recovery_c_x := history_c_x + B                             // ensure one sided error
+				rec_cms += float64(recovery_c_x-hat_c_x) / float64(hat_c_x) // what is the impact of +B?
+				rec_true += float64(recovery_c_x-c_x) / float64(c_x)        // how far from ground truth?
+				cms_true += float64(hat_c_x-c_x) / float64(c_x)
+				hist_true += float64(history_c_x-c_x) / float64(c_x)
+				n++
+			}
+			flows := float64(n)
+			// write to file: trace_batch [N, n, Nt, Ni, rec_cms, rec_true, cms_true, hist_true]
+			batch_csv := []string{
+				fmt.Sprintf("%d", N),
+				fmt.Sprintf("%d", n)}
+			if *window > 0 { // 1-based index of the failed window
+				batch_csv = append(batch_csv, fmt.Sprintf("%d", window_ids[t]), fmt.Sprintf("%d", B))
+			}
+			batch_csv = append(batch_csv,
+				fmt.Sprintf("%d", Nt),
+				fmt.Sprintf("%d", Ni),
+				fmt.Sprintf("%.8f", (rec_cms/flows)),
+				fmt.Sprintf("%.8f", (rec_true/flows)),
+				fmt.Sprintf("%.8f", (cms_true/flows)),
+				fmt.Sprintf("%.8f", (hist_true/flows)),
+				partial_csv,
+				fmt.Sprintf("%d", depth),
+				fmt.Sprintf("%d", width),
+				fmt.Sprintf("%d", cms_hist.CounterBits()),
+				fmt.Sprintf("%d", cms_hist.Bytes()),
+				fmt.Sprintf("%d", cms_hist.Saturations()+cms_curr.Saturations()))
+			writer_meta.Write(batch_csv)
+		}
+		// catchup the failed batch into history
+		cms_hist.Merge(cms_curr)
+		cms_curr.Clear()
+		// clear leftovers
+		for k := range curr_map {
+			delete(curr_map, k)
+		}
+	}
+	// by the end of run we have a file with 3*3 rows
+	// this will be used for bar plot
+	// clear leftovers
+	for k := range flow_map {
+		delete(flow_map, k)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("reading from in-file: %w", err)
+	}
+	if err := closeTables(writer_meta); err != nil {
+		return fmt.Errorf("writing out-file: %w", err)
+	}
+	return nil
+}
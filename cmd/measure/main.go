@@ -0,0 +1,459 @@
+/***************************************************
+* Author: Diana Cohen (sch.diana@gmail.com)
+* **************************************************
+* measure the Internet traces, a single binary with a subcommand per step:
+* all   - handle the entire trace as a single batch
+* batch - handle the trace using batches
+* error - measure the estimation error after recovery
+* run   - run an experiment matrix declared by a JSON config file
+* receive - receive the batches exported by batch, and verify them
+* collect - collect the batch reports into a CMS, and serve the queries over HTTP
+* serve - serve the sketches over HTTP
+* run "measure help [command]" for the arguments and flags of a command
+* exit codes: 0 on success, 1 on failure, 2 on bad usage
+ */
+
+package main
+
+import (
+	"encoding/csv"
+	"errors"
+	"flag"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/DianaCohenCS/measure-traces/output"
+	"github.com/DianaCohenCS/measure-traces/trace"
+)
+
+// command is a subcommand of measure
+type command struct {
+	name    string
+	summary string
+	run     func(s *session, args []string) error
+}
+
+// session records the outfiles created by a single run of a command
+type session struct {
+	mu      sync.Mutex
+	outputs []string
+}
+
+func (s *session) record(path string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.outputs = append(s.outputs, path)
+}
+
+var commands []command
+
+func init() {
+	commands = []command{
+		{"all", "handle the entire trace as a single batch, counting N, n and beta", runAll},
+		{"batch", "handle the trace using batches, counting B, b and beta per batch", runBatch},
+		{"error", "measure the estimation error of a CMS after recovery from a crash", runError},
+		{"run", "run an experiment matrix declared by a JSON config file", runExperiment},
+		{"receive", "receive the batches exported by batch -export, and verify them against the trace", runReceive},
+		{"collect", "collect the batch reports into a CMS, serving the point and top-k queries over HTTP", runCollect},
+		{"serve", "serve the sketches over HTTP: create, update, estimate, heavy hitters, merge and snapshots", runServe},
+	}
+}
+
+// errUsage marks the errors caused by bad arguments
+var errUsage = errors.New("bad usage")
+
+// errFlags marks the bad flags, already reported by the flag package along with the usage
+var errFlags = errors.New("bad flags")
+
+func usagef(format string, a ...any) error {
+	return fmt.Errorf("%w: %s", errUsage, fmt.Sprintf(format, a...))
+}
+
+func main() {
+	os.Exit(run(os.Args[1:]))
+}
+
+func run(args []string) int {
+	if len(args) < 1 {
+		usage()
+		return 2
+	}
+	name := args[0]
+	if name == "help" || name == "-h" || name == "--help" || name == "-help" {
+		if len(args) > 1 { // help of a command
+			if cmd := lookup(args[1]); cmd != nil {
+				cmd.run(&session{}, []string{"-h"})
+				return 0
+			}
+		}
+		usage()
+		return 0
+	}
+	cmd := lookup(name)
+	if cmd == nil {
+		fmt.Fprintf(os.Stderr, "measure: unknown command %q\n", name)
+		usage()
+		return 2
+	}
+	err := cmd.run(&session{}, args[1:])
+	switch {
+	case err == nil:
+		return 0
+	case errors.Is(err, flag.ErrHelp):
+		return 0
+	case errors.Is(err, errFlags):
+		return 2
+	case errors.Is(err, errUsage):
+		fmt.Fprintf(os.Stderr, "measure %s: %v\n", name, err)
+		fmt.Fprintf(os.Stderr, "run \"measure help %s\" for usage\n", name)
+		return 2
+	}
+	fmt.Fprintf(os.Stderr, "measure %s: %v\n", name, err)
+	return 1
+}
+
+func lookup(name string) *command {
+	for i := range commands {
+		if commands[i].name == name {
+			return &commands[i]
+		}
+	}
+	return nil
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "Usage: measure [command] [flags] [args]")
+	fmt.Fprintln(os.Stderr, "\nCommands:")
+	for _, cmd := range commands {
+		fmt.Fprintf(os.Stderr, "  %-8s %s\n", cmd.name, cmd.summary)
+	}
+	fmt.Fprintln(os.Stderr, "\nRun \"measure help [command]\" for the arguments and flags of a command.")
+}
+
+// options shared by the commands that read a trace and write the outfiles
+type options struct {
+	data_dir   string
+	out_dir    string
+	format     string
+	out_format string
+	workers    int
+	force      bool
+	checksum   bool
+	session    *session
+
+	// the metadata of the outfiles
+	name       string
+	fs         *flag.FlagSet
+	config     runConfig
+	input_path string
+	params     map[string]any
+	inputs     []*input
+	started    time.Time
+}
+
+// newFlagSet creates the flags of a command along with the shared options,
+// the usage lists the positional arguments (one line per alternative) and describes the command
+func newFlagSet(s *session, name string, positional []string, description string) (*flag.FlagSet, *options) {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	opts := &options{session: s, name: name, fs: fs}
+	fs.StringVar(&opts.data_dir, "data-dir", "data", "directory of the traces")
+	fs.StringVar(&opts.out_dir, "out-dir", "outfiles", "directory of the outfiles, a sub-directory per trace")
+	fs.StringVar(&opts.format, "format", trace.FormatTxt, "trace format: txt, tstxt or pcap")
+	fs.StringVar(&opts.out_format, "out-format", output.FormatCSV, "outfile format: csv, jsonl or parquet")
+	fs.IntVar(&opts.workers, "workers", 1, "number of worker goroutines, 1 processes the trace sequentially")
+	fs.BoolVar(&opts.force, "force", false, "overwrite outfiles written by a different configuration")
+	fs.BoolVar(&opts.checksum, "checksum", false, "identify the trace by its sha256 as well, an extra pass over it")
+	fs.Usage = func() {
+		for i, p := range positional {
+			prefix := "Usage:"
+			if i > 0 {
+				prefix = "      "
+			}
+			fmt.Fprintln(fs.Output(), strings.TrimSpace(fmt.Sprintf("%s measure %s [flags] %s", prefix, name, p)))
+		}
+		fmt.Fprintf(fs.Output(), "\n%s\n\nFlags:\n", description)
+		fs.PrintDefaults()
+	}
+	return fs, opts
+}
+
+// parseArgs parses the flags of a command, which may appear before, between or after
+// the positional arguments, and returns the positional arguments
+func parseArgs(fs *flag.FlagSet, args []string) ([]string, error) {
+	var positional []string
+	for {
+		if err := fs.Parse(args); err != nil {
+			if errors.Is(err, flag.ErrHelp) {
+				return nil, err
+			}
+			return nil, errFlags
+		}
+		args = fs.Args()
+		if len(args) == 0 {
+			return positional, nil
+		}
+		positional = append(positional, args[0])
+		args = args[1:]
+	}
+}
+
+// parse the arguments of a command, see parseArgs, and snapshot its configuration for the metadata of the outfiles
+func (opts *options) parse(args []string) ([]string, error) {
+	positional, err := parseArgs(opts.fs, args)
+	if err != nil {
+		return nil, err
+	}
+	opts.configure(positional)
+	return positional, nil
+}
+
+// the path of an outfile of a given trace
+func (opts *options) outPath(trace_name, file string) string {
+	return filepath.Join(opts.out_dir, trace_name, file)
+}
+
+// the suffix of an outfile in progress, renamed to the outfile once complete
+const partialSuffix = ".partial"
+
+// table is an outfile, written as a table of rows in the output format
+type table struct {
+	output.Writer
+	file    *os.File
+	path    string
+	columns []string
+	rows    int
+	args    []string // the positional arguments of its configuration
+	opts    *options
+}
+
+func (t *table) Write(row []string) error {
+	t.rows++
+	return t.Writer.Write(row)
+}
+
+// create a table (outfile) of given columns for a given trace, the name of the file is given
+// with no extension (of the output format), the outfile is recorded within the session
+// an existing outfile is overwritten only if it was written by the same configuration (or with -force)
+// the table is written as a partial file (created along with its directories), marked as incomplete
+// by its sidecar, and it replaces the outfile once complete, see closeTables
+func (opts *options) create(trace_name, name string, columns []output.Column) (*table, error) {
+	return opts.createOf(trace_name, name, opts.config.Args, columns)
+}
+
+// create a table, see create, whose configuration is keyed on the given positional arguments
+// rather than those of the command, e.g., a single batch size out of the listed batch sizes
+func (opts *options) createOf(trace_name, name string, args []string, columns []output.Column) (*table, error) {
+	path := opts.outPath(trace_name, name+output.Ext(opts.out_format))
+	if err := opts.checkOverwrite(path, args); err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, err
+	}
+	file, err := os.Create(path + partialSuffix)
+	if err != nil {
+		return nil, err
+	}
+	writer, err := output.NewWriter(file, opts.out_format, columns)
+	if err != nil {
+		file.Close()
+		os.Remove(path + partialSuffix)
+		return nil, usagef("%v", err)
+	}
+	t := &table{Writer: writer, file: file, path: path, args: args, opts: opts}
+	for _, col := range columns {
+		t.columns = append(t.columns, col.Name)
+	}
+	if err := opts.writeMeta(t, false); err != nil {
+		file.Close()
+		return nil, err
+	}
+	opts.session.record(path)
+	return t, nil
+}
+
+// complete the table: replace the outfile by the partial file, then write its sidecar
+func (t *table) commit() error {
+	if err := os.Rename(t.path+partialSuffix, t.path); err != nil {
+		return err
+	}
+	if err := t.opts.writeMeta(t, true); err != nil {
+		return err
+	}
+	return os.Remove(t.path + partialSuffix + metaSuffix)
+}
+
+// close the tables: complete the writers and close the files, then commit the complete tables,
+// reporting the first error (a table that fails is left as a partial file)
+func closeTables(tables ...*table) error {
+	var first error
+	for _, t := range tables {
+		err := t.Writer.Close()
+		if close_err := t.file.Close(); err == nil {
+			err = close_err
+		}
+		if err == nil {
+			err = t.commit()
+		}
+		if err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+// write a file atomically: write a temporary file, then rename it
+func writeFileAtomic(path string, data []byte) error {
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// the path of the trace (input) file
+func (opts *options) inPath(trace_name string) string {
+	return trace.Path(opts.data_dir, trace_name, opts.format)
+}
+
+// the number of items per chunk handed over by the reader goroutine, and the number of chunks read ahead
+const (
+	chunkSize   = 4096
+	chunksAhead = 4
+)
+
+// input is an opened trace (input) file along with its scanner
+type input struct {
+	trace.Scanner
+	file  *os.File
+	ahead *trace.AheadScanner // nil unless read ahead
+	items int                 // the items scanned so far
+}
+
+// open the trace (input) file, with more than a single worker the items are read ahead by a reader goroutine
+// the input is identified (once), for the metadata of the outfiles
+func (opts *options) open(trace_name string) (*input, error) {
+	if err := opts.identify(trace_name); err != nil {
+		return nil, err
+	}
+	file, scanner, err := trace.Open(opts.inPath(trace_name), opts.format)
+	if err != nil {
+		return nil, err
+	}
+	in := &input{Scanner: scanner, file: file}
+	opts.inputs = append(opts.inputs, in)
+	if opts.workers > 1 {
+		in.ahead = trace.ReadAhead(scanner, chunkSize, chunksAhead)
+		in.Scanner = in.ahead
+	}
+	return in, nil
+}
+
+func (in *input) Scan() bool {
+	if !in.Scanner.Scan() {
+		return false
+	}
+	in.items++
+	return true
+}
+
+func (in *input) Close() error {
+	if in.ahead != nil {
+		in.ahead.Close()
+	}
+	return in.file.Close()
+}
+
+// floatList is a flag of comma separated numbers
+type floatList []float64
+
+func (l *floatList) String() string {
+	strs := make([]string, len(*l))
+	for i, v := range *l {
+		strs[i] = strconv.FormatFloat(v, 'g', 4, 64)
+	}
+	return strings.Join(strs, ",")
+}
+
+func (l *floatList) Set(value string) error {
+	*l = (*l)[:0]
+	for _, str := range strings.Split(value, ",") {
+		v, err := strconv.ParseFloat(strings.TrimSpace(str), 64)
+		if err != nil {
+			return err
+		}
+		*l = append(*l, v)
+	}
+	return nil
+}
+
+// byteSize is a flag of a number of bytes, with an optional binary suffix: KiB, MiB or GiB
+type byteSize int
+
+var byteUnits = []struct {
+	suffix string
+	size   int
+}{{"GiB", 1 << 30}, {"MiB", 1 << 20}, {"KiB", 1 << 10}}
+
+func (b *byteSize) String() string {
+	for _, unit := range byteUnits {
+		if *b != 0 && int(*b)%unit.size == 0 {
+			return fmt.Sprintf("%d%s", int(*b)/unit.size, unit.suffix)
+		}
+	}
+	return strconv.Itoa(int(*b))
+}
+
+func (b *byteSize) Set(value string) error {
+	given, size := value, 1
+	for _, unit := range byteUnits {
+		if strings.HasSuffix(value, unit.suffix) {
+			value, size = strings.TrimSuffix(value, unit.suffix), unit.size
+			break
+		}
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 0 {
+		return fmt.Errorf("bad number of bytes %q", value)
+	}
+	if n > math.MaxInt/size {
+		return fmt.Errorf("number of bytes %q is out of range", given)
+	}
+	*b = byteSize(n * size)
+	return nil
+}
+
+// flush the CSV writers, reporting the first error of writing
+func flushCSV(writers ...*csv.Writer) error {
+	for _, writer := range writers {
+		writer.Flush()
+		if err := writer.Error(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func concatMultipleSlices[T any](slices [][]T) []T {
+	var totalLen int
+
+	for _, s := range slices {
+		totalLen += len(s)
+	}
+
+	result := make([]T, totalLen)
+
+	var i int
+
+	for _, s := range slices {
+		i += copy(result[i:], s)
+	}
+
+	return result
+}
@@ -0,0 +1,41 @@
+/***************************************************
+* Author: Diana Cohen (sch.diana@gmail.com)
+* **************************************************
+* test the flag of a number of bytes: the units, the bad numbers, and the sizes beyond an int
+ */
+
+package main
+
+import (
+	"fmt"
+	"math"
+	"testing"
+)
+
+func TestByteSize(t *testing.T) {
+	tests := []struct {
+		value string
+		size  byteSize
+		ok    bool
+	}{
+		{"4096", 4096, true},
+		{"16KiB", 16 << 10, true},
+		{"3MiB", 3 << 20, true},
+		{"1GiB", 1 << 30, true},
+		{"0", 0, true},
+		{"-1KiB", 0, false},
+		{"KiB", 0, false},
+		{"1.5MiB", 0, false},
+		{fmt.Sprint(math.MaxInt), math.MaxInt, true},
+		{fmt.Sprintf("%dKiB", math.MaxInt>>10), math.MaxInt >> 10 << 10, true},
+		{fmt.Sprintf("%dKiB", math.MaxInt>>10+1), 0, false}, // overflows an int
+		{fmt.Sprintf("%dGiB", math.MaxInt), 0, false},
+	}
+	for _, test := range tests {
+		var b byteSize
+		err := b.Set(test.value)
+		if (err == nil) != test.ok || err == nil && b != test.size {
+			t.Errorf("%q: size %d, error %v, expected %d (ok %v)", test.value, b, err, test.size, test.ok)
+		}
+	}
+}
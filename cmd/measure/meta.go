@@ -0,0 +1,268 @@
+/***************************************************
+* Author: Diana Cohen (sch.diana@gmail.com)
+* **************************************************
+* the metadata of the outfiles: a JSON sidecar next to each outfile ([outfile].meta.json)
+* records how the outfile was produced - the configuration (command, arguments, all the flags
+* and the input file along with its size and modification time), the code version, the resolved params of the run
+* (e.g. the seed of a CMS), the start and end time, and the number of items and rows
+* while a run is in progress, the outfile is written as [outfile].partial along with its sidecar
+* ([outfile].partial.meta.json, "complete": false), both are left behind by a failed or killed run
+* the configuration identifies the outfile: a command refuses to overwrite an outfile
+* written by a different configuration, unless -force is given; the outfiles of a batch size (out of
+* the batch sizes listed by measure batch) record that batch size alone as their argument
+* the input is identified without reading it, by its size and modification time, so a run remains
+* a single pass over the trace; with -checksum its sha256 is computed as well (an extra pass), and
+* once both outfiles have it, the content identifies the input rather than its modification time
+ */
+
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"runtime"
+	"runtime/debug"
+	"slices"
+	"sort"
+	"time"
+)
+
+// the suffix of the sidecar of an outfile
+const metaSuffix = ".meta.json"
+
+// flags that do not affect the content of the outfiles, hence are not a part of the configuration
+var runtimeFlags = []string{"data-dir", "out-dir", "workers", "force", "checksum", "export", "export-as"}
+
+// runConfig is the configuration that produces an outfile
+type runConfig struct {
+	Command string            `json:"command"`
+	Args    []string          `json:"args"`
+	Flags   map[string]string `json:"flags"`
+	Input   *inputInfo        `json:"input,omitempty"`
+}
+
+// inputInfo identifies the trace (input) file
+type inputInfo struct {
+	Name     string    `json:"name"`
+	Format   string    `json:"format"`
+	Size     int64     `json:"size"`
+	Modified time.Time `json:"modified"`
+	SHA256   string    `json:"sha256,omitempty"` // with -checksum
+}
+
+// codeVersion is the version of the binary that produced an outfile
+type codeVersion struct {
+	Go       string `json:"go"`
+	Module   string `json:"module,omitempty"`
+	Revision string `json:"revision,omitempty"`
+	Modified bool   `json:"modified,omitempty"`
+}
+
+// sidecar is the metadata of a single outfile
+type sidecar struct {
+	Output    string         `json:"output"`
+	Format    string         `json:"format"`
+	Columns   []string       `json:"columns"`
+	Rows      int            `json:"rows"`
+	Config    runConfig      `json:"config"`
+	InputPath string         `json:"input_path,omitempty"`
+	Items     int            `json:"items"` // the items read from the trace, within a single pass
+	Params    map[string]any `json:"params,omitempty"`
+	Version   codeVersion    `json:"version"`
+	Complete  bool           `json:"complete"`
+	Started   time.Time      `json:"started"`
+	Finished  *time.Time     `json:"finished,omitempty"`
+}
+
+// snapshot the configuration of a command, once the flags and positional arguments are parsed
+func (opts *options) configure(args []string) {
+	opts.config = runConfig{Command: opts.name, Args: args, Flags: map[string]string{}}
+	opts.fs.VisitAll(func(f *flag.Flag) {
+		if !slices.Contains(runtimeFlags, f.Name) {
+			opts.config.Flags[f.Name] = f.Value.String()
+		}
+	})
+	opts.started = time.Now()
+}
+
+// note a param of the run that is resolved by the command, e.g. a random seed
+func (opts *options) note(key string, value any) {
+	if opts.params == nil {
+		opts.params = map[string]any{}
+	}
+	opts.params[key] = value
+}
+
+// identify the trace (input) file by its size and modification time, along with its checksum given -checksum, once per run
+func (opts *options) identify(trace_name string) error {
+	if opts.config.Input != nil {
+		return nil
+	}
+	path := opts.inPath(trace_name)
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	input := &inputInfo{Name: trace_name, Format: opts.format, Size: info.Size(), Modified: info.ModTime().UTC()}
+	if opts.checksum {
+		file, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		hash := sha256.New()
+		if _, err := io.Copy(hash, file); err != nil {
+			return err
+		}
+		input.SHA256 = hex.EncodeToString(hash.Sum(nil))
+	}
+	opts.config.Input = input
+	opts.input_path = path
+	return nil
+}
+
+// whether two inputs are the same file: by the checksums if both have one, otherwise by the modification time
+func (in *inputInfo) same(other *inputInfo) bool {
+	if in.Name != other.Name || in.Format != other.Format || in.Size != other.Size {
+		return false
+	}
+	if in.SHA256 != "" && other.SHA256 != "" {
+		return in.SHA256 == other.SHA256
+	}
+	return in.Modified.Equal(other.Modified)
+}
+
+func (in *inputInfo) String() string {
+	if in.SHA256 != "" {
+		return fmt.Sprintf("%s (%d bytes, sha256 %.12s)", in.Name, in.Size, in.SHA256)
+	}
+	return fmt.Sprintf("%s (%d bytes, modified %s)", in.Name, in.Size, in.Modified.Format(time.RFC3339Nano))
+}
+
+// the configuration of an outfile, keyed on the given positional arguments
+func (opts *options) configOf(args []string) runConfig {
+	config := opts.config
+	config.Args = args
+	return config
+}
+
+// check that an outfile may be written: either it does not exist, or it was written by the same configuration
+// (of the given positional arguments)
+func (opts *options) checkOverwrite(path string, args []string) error {
+	if opts.force {
+		return nil
+	}
+	data, err := os.ReadFile(path + metaSuffix)
+	if errors.Is(err, fs.ErrNotExist) {
+		if _, err := os.Stat(path); err == nil {
+			fmt.Fprintf(os.Stderr, "measure %s: overwriting %s, which has no metadata\n", opts.name, path)
+		}
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var prev sidecar
+	if err := json.Unmarshal(data, &prev); err != nil {
+		return fmt.Errorf("reading the metadata of %s: %w", path, err)
+	}
+	config := opts.configOf(args)
+	if diffs := prev.Config.diff(&config); len(diffs) > 0 {
+		return fmt.Errorf("%s was written by a different configuration (%v), remove it or use -force", path, diffs)
+	}
+	return nil
+}
+
+// the differences between two configurations, as "what: previous -> current"
+func (c *runConfig) diff(other *runConfig) []string {
+	var diffs []string
+	if c.Command != other.Command {
+		diffs = append(diffs, fmt.Sprintf("command: %s -> %s", c.Command, other.Command))
+	}
+	if !slices.Equal(c.Args, other.Args) {
+		diffs = append(diffs, fmt.Sprintf("args: %v -> %v", c.Args, other.Args))
+	}
+	names := []string{}
+	for name := range c.Flags {
+		names = append(names, name)
+	}
+	for name := range other.Flags {
+		if _, ok := c.Flags[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if c.Flags[name] != other.Flags[name] {
+			diffs = append(diffs, fmt.Sprintf("-%s: %q -> %q", name, c.Flags[name], other.Flags[name]))
+		}
+	}
+	switch {
+	case c.Input == nil || other.Input == nil:
+		if c.Input != other.Input {
+			diffs = append(diffs, "input")
+		}
+	case !c.Input.same(other.Input):
+		diffs = append(diffs, fmt.Sprintf("input: %s -> %s", c.Input, other.Input))
+	}
+	return diffs
+}
+
+// write the sidecar of a table (outfile), either complete or in progress
+func (opts *options) writeMeta(t *table, complete bool) error {
+	items := 0
+	for _, in := range opts.inputs {
+		items = max(items, in.items)
+	}
+	meta := sidecar{
+		Output:    filepath.Base(t.path),
+		Format:    opts.out_format,
+		Columns:   t.columns,
+		Rows:      t.rows,
+		Config:    opts.configOf(t.args),
+		InputPath: opts.input_path,
+		Items:     items,
+		Params:    opts.params,
+		Version:   buildVersion(),
+		Complete:  complete,
+		Started:   opts.started,
+	}
+	path := t.path + partialSuffix + metaSuffix
+	if complete {
+		finished := time.Now()
+		meta.Finished = &finished
+		path = t.path + metaSuffix
+	}
+	data, err := json.MarshalIndent(meta, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(path, append(data, '\n'))
+}
+
+// the version of the binary, as stamped by the go build (module version and VCS revision)
+func buildVersion() codeVersion {
+	v := codeVersion{Go: runtime.Version()}
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return v
+	}
+	v.Module = info.Main.Version
+	for _, setting := range info.Settings {
+		switch setting.Key {
+		case "vcs.revision":
+			v.Revision = setting.Value
+		case "vcs.modified":
+			v.Modified = setting.Value == "true"
+		}
+	}
+	return v
+}
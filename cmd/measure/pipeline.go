@@ -0,0 +1,143 @@
+/***************************************************
+* Author: Diana Cohen (sch.diana@gmail.com)
+* **************************************************
+* a pipeline of the batches, the stats of a batch are independent of the other batches:
+* the reader goroutine decodes the trace (read ahead, see input), the batches are cut in order
+* of arrival and handed over to a pool of workers that count the flows, and an ordered writer
+* completes the counted batches in the same order they were cut, so the outfiles are identical
+* to those of the sequential path (a single worker, no goroutines at all)
+* a panic of a worker or of the writer stops the completion of the batches, and is raised again on the
+* goroutine of the batcher (by submit or close), where a job of run recovers it as its failure
+ */
+
+package main
+
+import (
+	"fmt"
+	"sync"
+)
+
+// pipeline counts the batches by a pool of workers, and completes them in order
+type pipeline struct {
+	workers int
+	seq     int           // the sequence number of the next batch
+	tasks   chan *task    // cut batches, to be counted
+	results chan *task    // counted batches, in any order
+	tokens  chan struct{} // bounds the number of batches in flight
+	done    chan struct{} // closed once all the batches are completed
+
+	mu       sync.Mutex
+	panicked any // the first panic of a worker or of the writer, nil if none
+}
+
+// task is a batch along with the batcher that cut it
+type task struct {
+	seq  int
+	bc   *batcher
+	bt   *batch
+	last bool // the latest batch of the batcher
+}
+
+func newPipeline(workers int) *pipeline {
+	p := &pipeline{workers: workers}
+	if workers <= 1 {
+		return p
+	}
+	p.tasks = make(chan *task, workers)
+	p.results = make(chan *task, workers)
+	p.tokens = make(chan struct{}, 4*workers)
+	p.done = make(chan struct{})
+
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for t := range p.tasks {
+				p.protect(func() { t.bt.count(t.bc.hll_p, t.bc.entropy_k) })
+				p.results <- t // even if it panicked, so the writer releases its token
+			}
+		}()
+	}
+	go func() {
+		wg.Wait()
+		close(p.results)
+	}()
+	go p.write()
+	return p
+}
+
+// submit a cut batch, with a single worker it is counted and completed right away
+func (p *pipeline) submit(bc *batcher, bt *batch, last bool) {
+	t := &task{seq: p.seq, bc: bc, bt: bt, last: last}
+	p.seq++
+	if p.workers <= 1 {
+		bt.count(bc.hll_p, bc.entropy_k)
+		t.complete()
+		return
+	}
+	if p.failure() != nil { // stop cutting batches once the pipeline has failed
+		p.close()
+	}
+	p.tokens <- struct{}{}
+	p.tasks <- t
+}
+
+// the ordered writer: hold back the batches counted ahead of their turn
+func (p *pipeline) write() {
+	held := make(map[int]*task)
+	next := 0
+	for t := range p.results {
+		held[t.seq] = t
+		for t, ok := held[next]; ok; t, ok = held[next] {
+			delete(held, next)
+			if p.failure() == nil { // the batches after a panic are dropped
+				p.protect(t.complete)
+			}
+			<-p.tokens
+			next++
+		}
+	}
+	close(p.done)
+}
+
+// close the pipeline, waiting for all the batches to be completed,
+// and raise the panic of a worker or of the writer (if any) on the calling goroutine
+func (p *pipeline) close() {
+	if p.workers <= 1 {
+		return
+	}
+	close(p.tasks)
+	<-p.done
+	if r := p.failure(); r != nil {
+		panic(fmt.Sprintf("a goroutine of the pipeline panicked: %v", r))
+	}
+}
+
+// run f, recovering its panic as the failure of the pipeline
+func (p *pipeline) protect(f func()) {
+	defer func() {
+		if r := recover(); r != nil {
+			p.mu.Lock()
+			if p.panicked == nil {
+				p.panicked = r
+			}
+			p.mu.Unlock()
+		}
+	}()
+	f()
+}
+
+func (p *pipeline) failure() any {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.panicked
+}
+
+func (t *task) complete() {
+	if t.last {
+		t.bc.completeLast(t.bt)
+	} else {
+		t.bc.complete(t.bt)
+	}
+}
@@ -0,0 +1,435 @@
+/***************************************************
+* Author: Diana Cohen (sch.diana@gmail.com)
+* **************************************************
+* receive the batches exported by measure batch -export (IPFIX-like messages, see package report),
+* either listening on a local address (UDP or TCP) or reading an exported file, and reassemble them
+* per observation domain (a batch size, or a window in microseconds)
+* the stream is checked on its own: the lost records (gaps of the sequence numbers), duplicated messages
+* and flows, and the batch records against their flow records (the sum of the counts is B, the flows are b)
+* given the trace, the batches are recounted (by the same -window and -partial as the export) and the
+* received counts are verified against the original ones
+* UDP has no end of stream: the receiver waits for the first message, and stops once idle for -timeout
+ */
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"net"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/DianaCohenCS/measure-traces/report"
+	"github.com/DianaCohenCS/measure-traces/trace"
+)
+
+// the mismatches reported in detail, per domain
+const maxMismatches = 10
+
+func runReceive(s *session, args []string) error {
+	fs, opts := newFlagSet(s, "receive", []string{"[trace-name] [batch-size,...]", "-window [duration] [trace-name]"},
+		"Receive the batches exported by measure batch -export, and verify them.\n"+
+			"Without a trace, the stream is checked on its own (losses, duplicates, the batch records).\n"+
+			"Given the trace, the batches are recounted and the received counts are compared to the original ones,\n"+
+			"the batch sizes are those of the received domains unless listed.")
+	listen := fs.String("listen", "", "listen on a local address: udp://host:port or tcp://host:port")
+	in := fs.String("in", "", "read an exported file, instead of listening")
+	timeout := fs.Duration("timeout", 2*time.Second, "UDP: stop once no message arrives for a given duration")
+	window := fs.Duration("window", 0, "the batches were cut by time windows (as measure batch -window)")
+	partial := fs.String("partial", "include", "the partial (last) batch of the export: include, drop or merge")
+	args, err := opts.parse(args)
+	if err != nil {
+		return err
+	}
+	if (*listen == "") == (*in == "") {
+		return usagef("expected either -listen or -in")
+	}
+	if *window > 0 && len(args) > 1 || len(args) > 2 {
+		return usagef("too many arguments")
+	}
+	switch *partial {
+	case "include", "drop", "merge":
+	default:
+		return usagef("bad -partial %q, expected include, drop or merge", *partial)
+	}
+	var batch_sizes []int
+	if len(args) == 2 {
+		for _, label := range strings.Split(args[1], ",") {
+			batch_size, err := strconv.Atoi(label)
+			if err != nil || batch_size <= 0 {
+				return usagef("bad batch size %q", label)
+			}
+			batch_sizes = append(batch_sizes, batch_size)
+		}
+	}
+	// the domains to verify against the trace, unless the received ones
+	var listed []uint32
+	if *window > 0 {
+		batch_sizes = []int{0}
+	}
+	for _, batch_size := range batch_sizes {
+		domain, err := exportDomain(batch_size, *window)
+		if err != nil {
+			return usagef("%v", err)
+		}
+		listed = append(listed, domain)
+	}
+
+	// receive the whole stream
+	rc := newReceiver()
+	switch {
+	case *in != "":
+		err = rc.readFile(*in)
+	default:
+		var scheme, address string
+		scheme, address, err = parseEndpoint(*listen, "udp", "tcp")
+		if err != nil {
+			return usagef("%v", err)
+		}
+		if scheme == "udp" {
+			err = rc.listenUDP(address, *timeout)
+		} else {
+			err = rc.listenTCP(address)
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("receiving: %w", err)
+	}
+	if len(rc.domains) == 0 {
+		return errors.New("no batches were received")
+	}
+	fmt.Printf("received %d messages, %d records\n", rc.messages, rc.records)
+
+	// the domains to verify against the trace, either the listed ones or the received ones
+	domains := slices.Sorted(maps.Keys(rc.domains))
+	if listed != nil {
+		domains = listed
+	}
+	var recounted map[uint32]*recount
+	if len(args) > 0 {
+		if recounted, err = recountTrace(opts, args[0], domains, *window, *partial); err != nil {
+			return err
+		}
+	}
+
+	failed := false
+	for _, domain := range domains {
+		label := strconv.Itoa(int(domain))
+		if *window > 0 {
+			label = window.String()
+		}
+		if !rc.check(domain, label, recounted[domain]) {
+			failed = true
+		}
+	}
+	for domain := range rc.domains {
+		if !slices.Contains(domains, domain) {
+			fmt.Printf("domain %d: unexpected, not verified\n", domain)
+		}
+	}
+	if failed {
+		return errors.New("the received batches do not match")
+	}
+	return nil
+}
+
+// receiver reassembles the batches of every observation domain
+type receiver struct {
+	decoder  *report.Decoder
+	domains  map[uint32]*domainStream
+	messages int
+	records  int
+}
+
+// domainStream is the stream of a single observation domain
+type domainStream struct {
+	batches    map[uint32]*receivedBatch
+	seqs       map[uint32]bool // the sequence numbers of the messages, to detect duplicates
+	end_seq    uint32          // the records exported up to the latest message
+	records    uint32
+	duplicates int // messages
+	unknown    int // data sets of unknown templates
+}
+
+// receivedBatch is a batch as reassembled from its flow records and its batch record
+type receivedBatch struct {
+	flows      map[string]uint64
+	end        *report.BatchEnd
+	duplicates int // flows received more than once
+}
+
+func newReceiver() *receiver {
+	return &receiver{decoder: report.NewDecoder(), domains: make(map[uint32]*domainStream)}
+}
+
+// add a decoded message to the stream of its domain
+func (rc *receiver) add(m *report.Message) {
+	rc.messages++
+	ds, found := rc.domains[m.Domain]
+	if !found {
+		ds = &domainStream{batches: make(map[uint32]*receivedBatch), seqs: make(map[uint32]bool)}
+		rc.domains[m.Domain] = ds
+	}
+	if ds.seqs[m.Seq] && m.Records > 0 {
+		ds.duplicates++
+		return
+	}
+	ds.seqs[m.Seq] = true
+	ds.end_seq = max(ds.end_seq, m.Seq+m.Records)
+	ds.records += m.Records
+	ds.unknown += m.Unknown
+	rc.records += int(m.Records)
+	for _, flow := range m.Flows {
+		bt := ds.batch(flow.Batch)
+		if _, found := bt.flows[flow.Key]; found {
+			bt.duplicates++
+		}
+		bt.flows[flow.Key] += flow.Count
+	}
+	for _, end := range m.Batches {
+		ds.batch(end.Batch).end = &end
+	}
+}
+
+func (ds *domainStream) batch(index uint32) *receivedBatch {
+	bt, found := ds.batches[index]
+	if !found {
+		bt = &receivedBatch{flows: make(map[string]uint64)}
+		ds.batches[index] = bt
+	}
+	return bt
+}
+
+// read the messages of an exported file
+func (rc *receiver) readFile(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	return rc.readStream(file)
+}
+
+// accept a single exporter, and read its messages until it closes the connection
+func (rc *receiver) listenTCP(address string) error {
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return err
+	}
+	defer listener.Close()
+	fmt.Fprintf(os.Stderr, "measure receive: listening on tcp://%s\n", listener.Addr())
+	conn, err := listener.Accept()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	return rc.readStream(conn)
+}
+
+func (rc *receiver) readStream(r io.Reader) error {
+	for {
+		m, err := rc.decoder.ReadMessage(r)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		rc.add(m)
+	}
+}
+
+// receive the datagrams, waiting for the first one, until none arrives for the timeout
+func (rc *receiver) listenUDP(address string, timeout time.Duration) error {
+	conn, err := net.ListenPacket("udp", address)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if udp, ok := conn.(*net.UDPConn); ok {
+		udp.SetReadBuffer(8 << 20) // a burst of batches, the losses are reported anyway
+	}
+	fmt.Fprintf(os.Stderr, "measure receive: listening on udp://%s\n", conn.LocalAddr())
+	buf := make([]byte, report.MaxMessage)
+	for {
+		n, _, err := conn.ReadFrom(buf)
+		if errors.Is(err, os.ErrDeadlineExceeded) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		m, err := rc.decoder.Decode(buf[:n])
+		if err != nil {
+			return err
+		}
+		rc.add(m)
+		conn.SetReadDeadline(time.Now().Add(timeout))
+	}
+}
+
+// check the stream of a domain, and its batches against the recounted ones (if any), reporting the result
+func (rc *receiver) check(domain uint32, label string, expected *recount) bool {
+	ds := rc.domains[domain]
+	if ds == nil {
+		fmt.Printf("batches of %s: nothing received\n", label)
+		return false
+	}
+	var problems []string
+	ok := true
+	problem := func(format string, a ...any) {
+		ok = false
+		if len(problems) < maxMismatches {
+			problems = append(problems, fmt.Sprintf(format, a...))
+		}
+	}
+	lost := ds.end_seq - ds.records
+	if lost > 0 {
+		problem("%d records lost", lost)
+	}
+	if ds.duplicates > 0 {
+		problem("%d duplicated messages", ds.duplicates)
+	}
+	if ds.unknown > 0 {
+		problem("%d data sets of unknown templates", ds.unknown)
+	}
+	incomplete := 0
+	for _, index := range slices.Sorted(maps.Keys(ds.batches)) {
+		bt := ds.batches[index]
+		items := uint64(0)
+		for _, count := range bt.flows {
+			items += count
+		}
+		switch {
+		case bt.end == nil:
+			incomplete++
+			problem("batch %d: no batch record", index)
+		case bt.duplicates > 0:
+			incomplete++
+			problem("batch %d: %d duplicated flows", index, bt.duplicates)
+		case bt.end.Items != items || bt.end.Flows != uint64(len(bt.flows)):
+			incomplete++
+			problem("batch %d: B %d and b %d, the flow records sum up to %d and %d",
+				index, bt.end.Items, bt.end.Flows, items, len(bt.flows))
+		}
+	}
+	fmt.Printf("batches of %s: %d received, %d records, %d lost, %d incomplete", label, len(ds.batches), ds.records, lost, incomplete)
+
+	if expected != nil {
+		matched := 0
+		for _, index := range slices.Sorted(maps.Keys(expected.batches)) {
+			bt, found := ds.batches[index]
+			switch {
+			case !found:
+				problem("batch %d: missing", index)
+			case !maps.Equal(bt.flows, expected.batches[index]):
+				problem("batch %d: %s", index, diffFlows(bt.flows, expected.batches[index]))
+			default:
+				matched++
+			}
+		}
+		for index := range ds.batches {
+			if _, found := expected.batches[index]; !found {
+				problem("batch %d: unexpected", index)
+			}
+		}
+		fmt.Printf(", %d of %d matching the trace", matched, len(expected.batches))
+	}
+	fmt.Println()
+	for _, p := range problems {
+		fmt.Printf("  %s\n", p)
+	}
+	return ok
+}
+
+// describe the first difference between the received and the expected counts of a batch
+func diffFlows(received, expected map[string]uint64) string {
+	for _, key := range slices.Sorted(maps.Keys(expected)) {
+		if count, found := received[key]; !found {
+			return fmt.Sprintf("flow %q is missing", key)
+		} else if count != expected[key] {
+			return fmt.Sprintf("flow %q counts %d, expected %d", key, count, expected[key])
+		}
+	}
+	for _, key := range slices.Sorted(maps.Keys(received)) {
+		if _, found := expected[key]; !found {
+			return fmt.Sprintf("flow %q is unexpected", key)
+		}
+	}
+	return "no difference"
+}
+
+// recount are the batches of a domain, recounted from the trace
+type recount struct {
+	batch_size int
+	batches    map[uint32]map[string]uint64
+	curr       uint32 // the index of the current batch
+	items      int    // the items of the current batch
+	prev       uint32 // the index of the previous batch, 0 if none
+}
+
+// recount the batches of every domain in a single pass over the trace, as measure batch cuts them
+func recountTrace(opts *options, trace_name string, domains []uint32, window time.Duration, partial string) (map[uint32]*recount, error) {
+	scanner, err := opts.open(trace_name)
+	if err != nil {
+		return nil, fmt.Errorf("opening in-file: %w", err)
+	}
+	defer scanner.Close()
+	if window > 0 && !scanner.Timestamped() {
+		return nil, usagef("time windows require a timestamped trace format (tstxt or pcap)")
+	}
+	recounts := make(map[uint32]*recount, len(domains))
+	for _, domain := range domains {
+		recounts[domain] = &recount{batch_size: int(domain), batches: make(map[uint32]map[string]uint64)}
+	}
+	var t0 time.Time
+	for i := 0; scanner.Scan(); i++ {
+		item := scanner.Item()
+		if i == 0 {
+			t0 = item.Time
+		}
+		for _, rc := range recounts {
+			index := uint32(0)
+			if window > 0 { // late (out of order) items are kept within the current window
+				index = max(rc.curr, uint32(trace.WindowIndex(t0, item.Time, window)+1))
+			} else {
+				index = uint32(i/rc.batch_size + 1)
+			}
+			rc.add(index, item.ID)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("reading from in-file: %w", err)
+	}
+	// the latest batch is partial if it is the latest time window, or a batch of less than batch-size items
+	for _, rc := range recounts {
+		if rc.curr == 0 || (window <= 0 && rc.items == rc.batch_size) {
+			continue
+		}
+		switch {
+		case partial == "drop":
+			delete(rc.batches, rc.curr)
+		case partial == "merge" && rc.prev != 0:
+			for key, count := range rc.batches[rc.curr] {
+				rc.batches[rc.prev][key] += count
+			}
+			delete(rc.batches, rc.curr)
+		}
+	}
+	return recounts, nil
+}
+
+func (rc *recount) add(index uint32, flow_id string) {
+	if index != rc.curr {
+		rc.prev, rc.curr, rc.items = rc.curr, index, 0
+		rc.batches[index] = make(map[string]uint64)
+	}
+	rc.batches[index][flow_id]++
+	rc.items++
+}
@@ -0,0 +1,366 @@
+/***************************************************
+* Author: Diana Cohen (sch.diana@gmail.com)
+* **************************************************
+* run an experiment matrix, declared by a JSON config file:
+* the traces (name, id-length, format) are crossed with the batch sizes (or time windows)
+* of each of the commands (all, batch, error), along with the sketch params and crash points,
+* each combination is a job (the batch sizes of the batch command share a single job, a single pass
+* over the trace), and the jobs are executed by a bounded pool of workers
+* a failed job is retried, and the manifest (JSON) records the status and outfiles of every job,
+* it is rewritten whenever a job is done, so an interrupted run still leaves a manifest behind
+* a panic of a job, or of the goroutines of its pipeline, is recovered as the failure of that job alone (not retried),
+* the other jobs carry on
+*
+* an example of a config file:
+* {
+*   "data_dir": "data", "out_dir": "outfiles", "out_format": "parquet", "workers": 4, "retries": 1,
+*   "traces": [{"name": "ny19A", "id_len": 64}, {"name": "ny19B", "id_len": 64}],
+*   "all": {},
+*   "batch": {"batch_sizes": [50, 100, 250], "flags": ["-hll", "14"]},
+*   "error": {"batch_sizes": [100, 500], "epsilon": 1e-6, "delta": 0.01,
+*             "failed_batches": [0.33, 0.5, 0.66], "failed_items": [0.1, 0.5, 0.9]}
+* }
+ */
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"runtime/debug"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// experiment is the matrix of the jobs to run
+type experiment struct {
+	DataDir  string        `json:"data_dir"`
+	OutDir   string        `json:"out_dir"`
+	Format   string        `json:"out_format"` // csv (default), jsonl or parquet
+	Workers  int           `json:"workers"`    // default: number of CPUs
+	Retries  int           `json:"retries"`    // additional attempts of a failed job
+	Manifest string        `json:"manifest"`   // default: [out_dir]/manifest.json
+	Traces   []traceConfig `json:"traces"`
+	All      *allConfig    `json:"all"`   // nil to skip the command
+	Batch    *batchConfig  `json:"batch"` // nil to skip the command
+	Error    *errorConfig  `json:"error"` // nil to skip the command
+}
+
+type traceConfig struct {
+	Name   string `json:"name"`
+	IDLen  int    `json:"id_len"`
+	Format string `json:"format"`
+}
+
+type allConfig struct {
+	Flags []string `json:"flags"` // additional flags of the command
+}
+
+type batchConfig struct {
+	BatchSizes []int    `json:"batch_sizes"`
+	Windows    []string `json:"windows"`
+	Flags      []string `json:"flags"`
+}
+
+type errorConfig struct {
+	BatchSizes    []int     `json:"batch_sizes"`
+	Windows       []string  `json:"windows"`
+	Epsilon       float64   `json:"epsilon"`
+	Delta         float64   `json:"delta"`
+	FailedBatches []float64 `json:"failed_batches"`
+	FailedItems   []float64 `json:"failed_items"`
+	Memory        []string  `json:"memory"` // memory budgets (e.g. 64KiB), crossed with the batch sizes
+	CounterBits   int       `json:"counter_bits"`
+	Flags         []string  `json:"flags"`
+}
+
+// job is a single run of a command, along with its outcome
+type job struct {
+	Command  string    `json:"command"`
+	Args     []string  `json:"args"`
+	Status   string    `json:"status"` // pending, ok or failed
+	Attempts int       `json:"attempts"`
+	Error    string    `json:"error,omitempty"`
+	Outputs  []string  `json:"outputs,omitempty"` // the outfiles of a successful job
+	Started  time.Time `json:"started"`
+	Finished time.Time `json:"finished"`
+}
+
+// manifest records the jobs of a run
+type manifest struct {
+	Config   string    `json:"config"`
+	Started  time.Time `json:"started"`
+	Finished time.Time `json:"finished"`
+	Jobs     []*job    `json:"jobs"`
+}
+
+func runExperiment(s *session, args []string) error {
+	fs, opts := newFlagSet(s, "run", []string{"[config.json]"},
+		"Run the experiment matrix declared by a JSON config file, executing the jobs by a pool of workers,\n"+
+			"and write a manifest of the jobs and their outfiles. The shared flags are ignored, set them in the config,\n"+
+			"except for -workers, the number of concurrent jobs, which overrides the config.")
+	manifest_path := fs.String("manifest", "", "path of the manifest, overrides the config")
+	dry_run := fs.Bool("dry-run", false, "list the jobs without running them")
+	args, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(args) != 1 {
+		return usagef("expected [config.json]")
+	}
+
+	exp, err := loadExperiment(args[0])
+	if err != nil {
+		return err
+	}
+	fs.Visit(func(f *flag.Flag) {
+		if f.Name == "workers" && opts.workers > 0 {
+			exp.Workers = opts.workers
+		}
+	})
+	if *manifest_path != "" {
+		exp.Manifest = *manifest_path
+	}
+	jobs, err := exp.expand()
+	if err != nil {
+		return err
+	}
+	if *dry_run {
+		for _, j := range jobs {
+			fmt.Printf("measure %s %s\n", j.Command, strings.Join(j.Args, " "))
+		}
+		return nil
+	}
+
+	m := &manifest{Config: args[0], Started: time.Now(), Jobs: jobs}
+	var mu sync.Mutex // guards the jobs and the manifest file
+	save := func() error {
+		mu.Lock()
+		defer mu.Unlock()
+		return m.write(exp.Manifest)
+	}
+	if err := save(); err != nil {
+		return fmt.Errorf("writing manifest: %w", err)
+	}
+
+	// execute the jobs by a bounded pool of workers
+	queue := make(chan *job)
+	var wg sync.WaitGroup
+	for w := 0; w < exp.Workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := range queue {
+				j.run(exp.Retries, &mu)
+				if j.Status == "failed" {
+					fmt.Fprintf(os.Stderr, "measure run: job failed: measure %s %s: %s\n",
+						j.Command, strings.Join(j.Args, " "), j.Error)
+				}
+				if err := save(); err != nil {
+					fmt.Fprintln(os.Stderr, "measure run: writing manifest:", err)
+				}
+			}
+		}()
+	}
+	for _, j := range jobs {
+		queue <- j
+	}
+	close(queue)
+	wg.Wait()
+
+	m.Finished = time.Now()
+	if err := save(); err != nil {
+		return fmt.Errorf("writing manifest: %w", err)
+	}
+	failed := 0
+	for _, j := range jobs {
+		if j.Status != "ok" {
+			failed++
+		}
+	}
+	fmt.Printf("%d jobs done, %d failed, manifest: %s\n", len(jobs)-failed, failed, exp.Manifest)
+	if failed > 0 {
+		return fmt.Errorf("%d of %d jobs failed", failed, len(jobs))
+	}
+	return nil
+}
+
+func loadExperiment(path string) (*experiment, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading config: %w", err)
+	}
+	exp := &experiment{DataDir: "data", OutDir: "outfiles"}
+	if err := json.Unmarshal(data, exp); err != nil {
+		return nil, usagef("parsing config %s: %v", path, err)
+	}
+	if exp.Workers <= 0 {
+		exp.Workers = runtime.NumCPU()
+	}
+	if exp.Manifest == "" {
+		exp.Manifest = filepath.Join(exp.OutDir, "manifest.json")
+	}
+	if len(exp.Traces) == 0 {
+		return nil, usagef("config %s declares no traces", path)
+	}
+	return exp, nil
+}
+
+// expand the matrix into jobs: per trace, the all command, then the batch sizes (windows)
+// of the batch command, then those of the error command
+func (exp *experiment) expand() ([]*job, error) {
+	var jobs []*job
+	add := func(command string, flags []string, positional ...string) {
+		args := []string{"-data-dir", exp.DataDir, "-out-dir", exp.OutDir}
+		if exp.Format != "" {
+			args = append(args, "-out-format", exp.Format)
+		}
+		args = append(args, flags...)
+		jobs = append(jobs, &job{Command: command, Args: append(args, positional...), Status: "pending"})
+	}
+	for _, tr := range exp.Traces {
+		if tr.Name == "" {
+			return nil, usagef("a trace with no name")
+		}
+		format := []string{}
+		if tr.Format != "" {
+			format = []string{"-format", tr.Format}
+		}
+		id_len := strconv.Itoa(tr.IDLen)
+		if exp.All != nil {
+			add("all", concatMultipleSlices([][]string{format, exp.All.Flags}), tr.Name)
+		}
+		if exp.Batch != nil {
+			if tr.IDLen <= 0 {
+				return nil, usagef("trace %s: id_len is required by the batch command", tr.Name)
+			}
+			flags := concatMultipleSlices([][]string{format, exp.Batch.Flags})
+			if len(exp.Batch.BatchSizes) > 0 { // all the batch sizes in a single pass over the trace
+				sizes := make([]string, len(exp.Batch.BatchSizes))
+				for i, batch_size := range exp.Batch.BatchSizes {
+					sizes[i] = strconv.Itoa(batch_size)
+				}
+				add("batch", flags, tr.Name, strings.Join(sizes, ","), id_len)
+			}
+			for _, window := range exp.Batch.Windows {
+				add("batch", append([]string{"-window", window}, flags...), tr.Name, id_len)
+			}
+		}
+		if exp.Error != nil {
+			budgets := [][]string{nil} // derived from epsilon
+			if len(exp.Error.Memory) > 0 {
+				budgets = nil
+				for _, memory := range exp.Error.Memory {
+					budgets = append(budgets, []string{"-memory", memory})
+				}
+			}
+			for _, budget := range budgets {
+				flags := concatMultipleSlices([][]string{format, budget, exp.Error.flags()})
+				for _, batch_size := range exp.Error.BatchSizes {
+					add("error", flags, tr.Name, strconv.Itoa(batch_size))
+				}
+				for _, window := range exp.Error.Windows {
+					add("error", append([]string{"-window", window}, flags...), tr.Name)
+				}
+			}
+		}
+	}
+	if len(jobs) == 0 {
+		return nil, usagef("config declares no jobs")
+	}
+	return jobs, nil
+}
+
+// the flags of the error command: sketch params and crash points, when set
+func (c *errorConfig) flags() []string {
+	var flags []string
+	if c.Epsilon != 0 {
+		flags = append(flags, "-epsilon", strconv.FormatFloat(c.Epsilon, 'g', -1, 64))
+	}
+	if c.Delta != 0 {
+		flags = append(flags, "-delta", strconv.FormatFloat(c.Delta, 'g', -1, 64))
+	}
+	if len(c.FailedBatches) > 0 {
+		flags = append(flags, "-failed-batches", joinFloats(c.FailedBatches))
+	}
+	if len(c.FailedItems) > 0 {
+		flags = append(flags, "-failed-items", joinFloats(c.FailedItems))
+	}
+	if c.CounterBits != 0 {
+		flags = append(flags, "-counter-bits", strconv.Itoa(c.CounterBits))
+	}
+	return append(flags, c.Flags...)
+}
+
+func joinFloats(list []float64) string {
+	strs := make([]string, len(list))
+	for i, v := range list {
+		strs[i] = strconv.FormatFloat(v, 'g', -1, 64)
+	}
+	return strings.Join(strs, ",")
+}
+
+// errPanic marks the failures of the jobs that panicked
+var errPanic = errors.New("panic")
+
+// run the job, retrying upon failure, but not upon bad usage or a panic
+func (j *job) run(retries int, mu *sync.Mutex) {
+	cmd := lookup(j.Command)
+	started := time.Now()
+	var err error
+	var outputs []string
+	attempts := 0
+	for attempts <= retries {
+		attempts++
+		s := &session{}
+		err = j.attempt(cmd, s)
+		outputs = s.outputs
+		if err == nil || errors.Is(err, errUsage) || errors.Is(err, errFlags) || errors.Is(err, errPanic) {
+			break
+		}
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	j.Started = started
+	j.Finished = time.Now()
+	j.Attempts = attempts
+	if err != nil {
+		j.Status = "failed"
+		j.Error = err.Error()
+		return
+	}
+	j.Status = "ok"
+	j.Outputs = outputs
+}
+
+// a single attempt of the job, a panic is recovered as its error, so the other jobs and the manifest carry on
+func (j *job) attempt(cmd *command, s *session) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			fmt.Fprintf(os.Stderr, "measure run: measure %s %s panicked: %v\n%s",
+				j.Command, strings.Join(j.Args, " "), r, debug.Stack())
+			err = fmt.Errorf("%w: %v", errPanic, r)
+		}
+	}()
+	return cmd.run(s, j.Args)
+}
+
+// write the manifest, replacing the previous one only once it is complete
+func (m *manifest) write(path string) error {
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	return writeFileAtomic(path, data)
+}
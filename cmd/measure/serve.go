@@ -0,0 +1,86 @@
+/***************************************************
+* Author: Diana Cohen (sch.diana@gmail.com)
+* **************************************************
+* serve the sketches over HTTP (see server.API), so other services use the CMS without linking the go code:
+* the sketches are created by name, each of its own epsilon and delta (by default those of the flags),
+* updated by keys or by merging snapshots, and queried by point estimates and heavy hitters
+* the sketches are kept in memory (bounded by -memory, the counters of all the sketches), until interrupted (SIGINT or SIGTERM)
+ */
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/DianaCohenCS/measure-traces/server"
+	"github.com/DianaCohenCS/measure-traces/sketch"
+)
+
+func runServe(s *session, args []string) error {
+	fs, opts := newFlagSet(s, "serve", []string{"[sketch-name,...]"},
+		"Serve the sketches over HTTP with JSON responses: create, update (single or batched), point estimate,\n"+
+			"heavy hitters, merge a snapshot, download a snapshot and stats, until interrupted.\n"+
+			"The listed sketches are created at start, of the default params.")
+	http_addr := fs.String("http", "127.0.0.1:8080", "serve the API over HTTP on a local address")
+	epsilon := fs.Float64("epsilon", 0.001, "default error rate of a sketch")
+	delta := fs.Float64("delta", 0.01, "default confidence of a sketch")
+	top_k := fs.Int("top", 100, "default number of heavy hitters to track, 0 is off")
+	memory := byteSize(4 << 30)
+	fs.Var(&memory, "memory", "memory of the counters of all the sketches in bytes (e.g. 512MiB), 0 is unbounded")
+	args, err := opts.parse(args)
+	if err != nil {
+		return err
+	}
+	if len(args) > 1 {
+		return usagef("too many arguments")
+	}
+	if *top_k < 0 {
+		return usagef("the number of heavy hitters must not be negative")
+	}
+	// the defaults are checked once, rather than by the first request
+	d, w, err := sketch.Dimensions(*epsilon, *delta)
+	if err != nil {
+		return usagef("%v", err)
+	}
+	if d*w > server.MaxCounters {
+		return usagef("a sketch of %d x %d counters exceeds %d counters", d, w, server.MaxCounters)
+	}
+	api := server.NewAPI(server.Defaults{Epsilon: *epsilon, Delta: *delta, Top: *top_k}, int64(memory))
+	if len(args) == 1 {
+		for _, name := range strings.Split(args[0], ",") {
+			cms, _ := sketch.New(d, w)
+			sk, err := server.NewSketch(cms, *top_k)
+			if err != nil {
+				return usagef("%v", err)
+			}
+			if err := api.Add(name, sk); err != nil {
+				return usagef("%v", err)
+			}
+		}
+	}
+
+	listener, err := net.Listen("tcp", *http_addr)
+	if err != nil {
+		return err
+	}
+	srv := &http.Server{Handler: api, ReadHeaderTimeout: 10 * time.Second}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	go func() {
+		<-ctx.Done()
+		srv.Shutdown(context.Background())
+	}()
+	fmt.Fprintf(os.Stderr, "measure serve: serving http://%s\n", listener.Addr())
+	if err := srv.Serve(listener); err != http.ErrServerClosed {
+		return err
+	}
+	return nil
+}
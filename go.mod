@@ -3,3 +3,5 @@ module github.com/DianaCohenCS/measure-traces
 go 1.23.4
 
 require github.com/cespare/xxhash/v2 v2.3.0
+
+require github.com/klauspost/compress v1.18.0
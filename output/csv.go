@@ -0,0 +1,46 @@
+/***************************************************
+* Author: Diana Cohen (sch.diana@gmail.com)
+* **************************************************
+* CSV tables: a header-row of the column names, followed by the rows
+ */
+
+package output
+
+import (
+	"encoding/csv"
+	"io"
+)
+
+type csvWriter struct {
+	writer  *csv.Writer
+	columns []Column
+	err     error
+}
+
+func newCSVWriter(w io.Writer, columns []Column) *csvWriter {
+	c := &csvWriter{writer: csv.NewWriter(w), columns: columns}
+	header := make([]string, len(columns))
+	for i, col := range columns {
+		header[i] = col.Name
+	}
+	c.err = c.writer.Write(header)
+	return c
+}
+
+func (c *csvWriter) Write(row []string) error {
+	if c.err == nil {
+		c.err = checkRow(c.columns, row)
+	}
+	if c.err == nil {
+		c.err = c.writer.Write(row)
+	}
+	return c.err
+}
+
+func (c *csvWriter) Close() error {
+	c.writer.Flush()
+	if c.err != nil {
+		return c.err
+	}
+	return c.writer.Error()
+}
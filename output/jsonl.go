@@ -0,0 +1,83 @@
+/***************************************************
+* Author: Diana Cohen (sch.diana@gmail.com)
+* **************************************************
+* JSON Lines tables: a JSON object per row, keyed by the column names,
+* the values of integer and floating-point columns are JSON numbers (NaN and infinities are null)
+ */
+
+package output
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+)
+
+type jsonlWriter struct {
+	writer  *bufio.Writer
+	columns []Column
+	keys    [][]byte // the quoted column names
+	line    []byte
+	err     error
+}
+
+func newJSONLWriter(w io.Writer, columns []Column) *jsonlWriter {
+	j := &jsonlWriter{writer: bufio.NewWriter(w), columns: columns, keys: make([][]byte, len(columns))}
+	for i, col := range columns {
+		j.keys[i], _ = json.Marshal(col.Name)
+	}
+	return j
+}
+
+func (j *jsonlWriter) Write(row []string) error {
+	if j.err == nil {
+		j.err = checkRow(j.columns, row)
+	}
+	if j.err != nil {
+		return j.err
+	}
+	line := append(j.line[:0], '{')
+	for i, value := range row {
+		if i > 0 {
+			line = append(line, ',')
+		}
+		line = append(line, j.keys[i]...)
+		line = append(line, ':')
+		switch j.columns[i].Kind {
+		case Int:
+			if _, err := strconv.ParseInt(value, 10, 64); err != nil {
+				j.err = fmt.Errorf("output: column %q: bad integer %q", j.columns[i].Name, value)
+				return j.err
+			}
+			line = append(line, value...)
+		case Float:
+			f, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				j.err = fmt.Errorf("output: column %q: bad number %q", j.columns[i].Name, value)
+				return j.err
+			}
+			if math.IsNaN(f) || math.IsInf(f, 0) {
+				line = append(line, "null"...)
+			} else {
+				line = strconv.AppendFloat(line, f, 'g', -1, 64)
+			}
+		default:
+			quoted, _ := json.Marshal(value)
+			line = append(line, quoted...)
+		}
+	}
+	line = append(line, '}', '\n')
+	j.line = line
+	_, j.err = j.writer.Write(line)
+	return j.err
+}
+
+func (j *jsonlWriter) Close() error {
+	if j.err != nil {
+		return j.err
+	}
+	return j.writer.Flush()
+}
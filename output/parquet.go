@@ -0,0 +1,208 @@
+/***************************************************
+* Author: Diana Cohen (sch.diana@gmail.com)
+* **************************************************
+* Parquet tables, with no dependencies: a flat schema of required columns,
+* strings are BYTE_ARRAY (UTF8), integers are INT64, floating-point numbers are DOUBLE
+* the rows are buffered into row groups, a column chunk of a row group is a single data page
+* of plain encoded and uncompressed values, the metadata is written upon Close:
+* "PAR1" [column chunks of row group 1] ... [column chunks of row group k] [metadata] [len] "PAR1"
+ */
+
+package output
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+)
+
+// the number of rows of a row group
+const parquetGroupRows = 1 << 17
+
+// parquet physical types, encodings and the like
+const (
+	parquetInt64     = 2
+	parquetDouble    = 5
+	parquetByteArray = 6
+	parquetPlain     = 0
+	parquetRLE       = 3
+	parquetRequired  = 0
+	parquetUTF8      = 0
+	parquetDataPage  = 0
+)
+
+var parquetMagic = []byte("PAR1")
+
+type parquetWriter struct {
+	w       io.Writer
+	columns []Column
+	values  [][]byte // per column, the plain encoded values of the current row group
+	rows    int      // the rows of the current row group
+	groups  []parquetGroup
+	offset  int64 // the number of bytes written so far
+	err     error
+}
+
+type parquetGroup struct {
+	rows   int64
+	chunks []parquetChunk
+}
+
+// a column chunk: the offset of its data page, and its size (page header included)
+type parquetChunk struct {
+	offset, size int64
+}
+
+func newParquetWriter(w io.Writer, columns []Column) *parquetWriter {
+	return &parquetWriter{w: w, columns: columns, values: make([][]byte, len(columns))}
+}
+
+func (p *parquetWriter) Write(row []string) error {
+	if p.err == nil {
+		p.err = checkRow(p.columns, row)
+	}
+	if p.err != nil {
+		return p.err
+	}
+	for i, value := range row {
+		switch p.columns[i].Kind {
+		case Int:
+			v, err := strconv.ParseInt(value, 10, 64)
+			if err != nil {
+				p.err = fmt.Errorf("output: column %q: bad integer %q", p.columns[i].Name, value)
+				return p.err
+			}
+			p.values[i] = binary.LittleEndian.AppendUint64(p.values[i], uint64(v))
+		case Float:
+			v, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				p.err = fmt.Errorf("output: column %q: bad number %q", p.columns[i].Name, value)
+				return p.err
+			}
+			p.values[i] = binary.LittleEndian.AppendUint64(p.values[i], math.Float64bits(v))
+		default:
+			p.values[i] = binary.LittleEndian.AppendUint32(p.values[i], uint32(len(value)))
+			p.values[i] = append(p.values[i], value...)
+		}
+	}
+	p.rows++
+	if p.rows == parquetGroupRows {
+		p.flushGroup()
+	}
+	return p.err
+}
+
+func (p *parquetWriter) write(data []byte) {
+	if p.err != nil {
+		return
+	}
+	if p.offset == 0 {
+		_, p.err = p.w.Write(parquetMagic)
+		p.offset = int64(len(parquetMagic))
+		if p.err != nil {
+			return
+		}
+	}
+	_, p.err = p.w.Write(data)
+	p.offset += int64(len(data))
+}
+
+// write the buffered rows as a row group, a data page per column
+func (p *parquetWriter) flushGroup() {
+	group := parquetGroup{rows: int64(p.rows), chunks: make([]parquetChunk, len(p.columns))}
+	for i, data := range p.values {
+		var e thriftEncoder
+		e.beginStruct()
+		e.i32(1, parquetDataPage)
+		e.i32(2, int32(len(data))) // uncompressed
+		e.i32(3, int32(len(data))) // compressed
+		e.structField(5, func() {
+			e.i32(1, int32(p.rows))
+			e.i32(2, parquetPlain)
+			e.i32(3, parquetRLE) // definition levels, none of the required columns
+			e.i32(4, parquetRLE) // repetition levels, none of the flat schema
+		})
+		e.endStruct()
+
+		p.write(nil) // the magic, at the very beginning
+		group.chunks[i] = parquetChunk{offset: p.offset, size: int64(len(e.buf) + len(data))}
+		p.write(e.buf)
+		p.write(data)
+		p.values[i] = data[:0]
+	}
+	p.groups = append(p.groups, group)
+	p.rows = 0
+}
+
+func (p *parquetWriter) Close() error {
+	if p.rows > 0 {
+		p.flushGroup()
+	}
+	if p.err != nil {
+		return p.err
+	}
+	p.write(nil)
+
+	total := int64(0)
+	for _, group := range p.groups {
+		total += group.rows
+	}
+	var e thriftEncoder
+	e.beginStruct()
+	e.i32(1, 1) // version
+	e.structList(2, len(p.columns)+1, func(i int) {
+		if i == 0 { // the root of the schema
+			e.binary(4, "schema")
+			e.i32(5, int32(len(p.columns)))
+			return
+		}
+		col := p.columns[i-1]
+		e.i32(1, parquetType(col.Kind))
+		e.i32(3, parquetRequired)
+		e.binary(4, col.Name)
+		if col.Kind == String {
+			e.i32(6, parquetUTF8)
+		}
+	})
+	e.i64(3, total)
+	e.structList(4, len(p.groups), func(g int) {
+		group := p.groups[g]
+		size := int64(0)
+		e.structList(1, len(group.chunks), func(i int) {
+			chunk := group.chunks[i]
+			size += chunk.size
+			e.i64(2, chunk.offset)
+			e.structField(3, func() {
+				e.i32(1, parquetType(p.columns[i].Kind))
+				e.i32List(2, parquetPlain, parquetRLE)
+				e.binaryList(3, p.columns[i].Name)
+				e.i32(4, 0) // uncompressed
+				e.i64(5, group.rows)
+				e.i64(6, chunk.size)
+				e.i64(7, chunk.size)
+				e.i64(9, chunk.offset)
+			})
+		})
+		e.i64(2, size)
+		e.i64(3, group.rows)
+	})
+	e.binary(6, "measure-traces")
+	e.endStruct()
+
+	p.write(e.buf)
+	p.write(binary.LittleEndian.AppendUint32(nil, uint32(len(e.buf))))
+	p.write(parquetMagic)
+	return p.err
+}
+
+func parquetType(kind Kind) int32 {
+	switch kind {
+	case Int:
+		return parquetInt64
+	case Float:
+		return parquetDouble
+	}
+	return parquetByteArray
+}
@@ -0,0 +1,228 @@
+/***************************************************
+* Author: Diana Cohen (sch.diana@gmail.com)
+* **************************************************
+* test the Parquet tables: the bytes of a small table are pinned (its footer annotated by the fields
+* of the parquet-format spec), and the tables are read back by a minimal reader of the spec:
+* the magic at both ends, the footer (FileMetaData), the schema, and the plain data page of every
+* column chunk of every row group, located by the offsets of the metadata
+ */
+
+package output
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"strconv"
+	"testing"
+)
+
+// a table of the columns n (INT64), key (BYTE_ARRAY, UTF8) and beta (DOUBLE), of the rows [1 a 0.5] [-2 bc 2]
+var goldenParquet = "50415231" + // PAR1
+	// the data page of n: PageHeader{type DATA_PAGE, sizes 16, 16, DataPageHeader{2 values, PLAIN, RLE, RLE}}, 1, -2
+	"1500 1520 1520 2c 1504 1500 1506 1506 00 00" + "0100000000000000" + "feffffffffffffff" +
+	// key: the lengths (4 bytes) followed by the bytes
+	"1500 1516 1516 2c 1504 1500 1506 1506 00 00" + "01000000 61" + "02000000 6263" +
+	// beta
+	"1500 1520 1520 2c 1504 1500 1506 1506 00 00" + "000000000000e03f" + "0000000000000040" +
+	// FileMetaData{version 1, schema [root of 3 children, n REQUIRED INT64, key REQUIRED BYTE_ARRAY UTF8, beta REQUIRED DOUBLE]
+	"1502" + "194c" + "48 06 736368656d61 1506 00" + "1504 2500 18 01 6e 00" + "150c 2500 18 03 6b6579 2500 00" + "150a 2500 18 04 62657461 00" +
+	// num_rows 2, row_groups [RowGroup{columns [ColumnChunk{file_offset, ColumnMetaData{type, [PLAIN RLE], [name],
+	// UNCOMPRESSED, 2 values, sizes, data_page_offset}}], total_byte_size 124, num_rows 2}]
+	"1604" + "191c" + "193c" +
+	"2608 1c 1504 1925 0006 1918 01 6e 1500 1604 1642 1642 2608 00 00" +
+	"264a 1c 150c 1925 0006 1918 03 6b6579 1500 1604 1638 1638 264a 00 00" +
+	"268201 1c 150a 1925 0006 1918 04 62657461 1500 1604 1642 1642 268201 00 00" +
+	"16bc01 1604 00" +
+	// created_by, stop
+	"28 0e 6d6561737572652d747261636573 00" +
+	// the length of the footer (157), PAR1
+	"9d000000" + "50415231"
+
+func TestParquetGolden(t *testing.T) {
+	var buf bytes.Buffer
+	w := newParquetWriter(&buf, []Column{{"n", Int}, {"key", String}, {"beta", Float}})
+	w.Write([]string{"1", "a", "0.5"})
+	w.Write([]string{"-2", "bc", "2"})
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	want, _ := hex.DecodeString(removeSpaces(goldenParquet))
+	if !bytes.Equal(buf.Bytes(), want) {
+		t.Errorf("wrote\n%x\nexpected\n%x", buf.Bytes(), want)
+	}
+}
+
+func TestParquetRoundTrip(t *testing.T) {
+	columns := concat(Strings("trace"), Ints("B", "b"), Floats("beta"))
+	rows := func(n int) [][]string {
+		rows := make([][]string, n)
+		for i := range rows {
+			rows[i] = []string{fmt.Sprintf("trace%d", i%7), strconv.Itoa(i), strconv.Itoa(-i * 1000), strconv.FormatFloat(float64(i)/3, 'g', -1, 64)}
+		}
+		return rows
+	}
+	tests := []struct {
+		name string
+		rows [][]string
+	}{
+		{"no rows", nil},
+		{"a single row", [][]string{{"", "0", "-9223372036854775808", "-1e-300"}}},
+		{"a row group", rows(1000)},
+		{"row groups", rows(parquetGroupRows*2 + 5)},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			w, _ := NewWriter(&buf, FormatParquet, columns)
+			for _, row := range test.rows {
+				if err := w.Write(row); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := w.Close(); err != nil {
+				t.Fatal(err)
+			}
+			read_columns, read_rows, err := readParquet(buf.Bytes())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(read_columns, columns) {
+				t.Errorf("read the columns %v, expected %v", read_columns, columns)
+			}
+			if len(read_rows) != len(test.rows) {
+				t.Fatalf("read %d rows, expected %d", len(read_rows), len(test.rows))
+			}
+			for i := range test.rows {
+				if !reflect.DeepEqual(read_rows[i], test.rows[i]) {
+					t.Fatalf("row %d: read %v, expected %v", i, read_rows[i], test.rows[i])
+				}
+			}
+		})
+	}
+}
+
+func TestParquetBadValues(t *testing.T) {
+	for _, row := range [][]string{{"x", "1.5"}, {"1", "y"}, {"1"}} {
+		w := newParquetWriter(&bytes.Buffer{}, concat(Ints("B"), Floats("beta")))
+		if w.Write(row) == nil || w.Close() == nil {
+			t.Errorf("accepted the row %q", row)
+		}
+	}
+}
+
+func concat(columns ...[]Column) []Column {
+	var all []Column
+	for _, c := range columns {
+		all = append(all, c...)
+	}
+	return all
+}
+
+// read a Parquet file of a flat schema of required, plain encoded and uncompressed columns,
+// the values are formatted back as strings
+func readParquet(data []byte) ([]Column, [][]string, error) {
+	magic := []byte("PAR1")
+	if len(data) < 12 || !bytes.Equal(data[:4], magic) || !bytes.Equal(data[len(data)-4:], magic) {
+		return nil, nil, errors.New("parquet: no magic")
+	}
+	footer_len := int(binary.LittleEndian.Uint32(data[len(data)-8:]))
+	if footer_len > len(data)-12 {
+		return nil, nil, errors.New("parquet: bad footer length")
+	}
+	d := thriftDecoder{data: data[len(data)-8-footer_len : len(data)-8]}
+	meta := d.readStruct()
+	if d.err != nil || len(d.data) != 0 {
+		return nil, nil, fmt.Errorf("parquet: bad footer: %v", d.err)
+	}
+
+	// the schema: the root, followed by its children
+	schema := meta[2].([]any)
+	root := schema[0].(thriftFields)
+	if int(root[5].(int64)) != len(schema)-1 {
+		return nil, nil, errors.New("parquet: the root does not hold the columns")
+	}
+	var columns []Column
+	for _, element := range schema[1:] {
+		e := element.(thriftFields)
+		if e[3].(int64) != 0 {
+			return nil, nil, errors.New("parquet: a column is not required")
+		}
+		kind := map[int64]Kind{2: Int, 5: Float, 6: String}[e[1].(int64)]
+		if utf8, ok := e[6]; (kind == String) != (ok && utf8.(int64) == 0) {
+			return nil, nil, errors.New("parquet: the strings are not UTF8")
+		}
+		columns = append(columns, Column{Name: e[4].(string), Kind: kind})
+	}
+
+	var rows [][]string
+	for _, g := range meta[4].([]any) {
+		group := g.(thriftFields)
+		group_rows := int(group[3].(int64))
+		values := make([][]string, len(columns))
+		for i, c := range group[1].([]any) {
+			chunk := c.(thriftFields)[3].(thriftFields)
+			if chunk[4].(int64) != 0 || int(chunk[5].(int64)) != group_rows || chunk[3].([]any)[0] != columns[i].Name {
+				return nil, nil, fmt.Errorf("parquet: bad column chunk of %s", columns[i].Name)
+			}
+			offset := chunk[9].(int64)
+			if offset < 4 || offset+chunk[7].(int64) > int64(len(data)) {
+				return nil, nil, errors.New("parquet: bad data page offset")
+			}
+			d := thriftDecoder{data: data[offset : offset+chunk[7].(int64)]}
+			page := d.readStruct()
+			header := page[5].(thriftFields)
+			if d.err != nil || page[1].(int64) != 0 || int(header[1].(int64)) != group_rows || header[2].(int64) != 0 {
+				return nil, nil, fmt.Errorf("parquet: bad data page of %s: %v", columns[i].Name, d.err)
+			}
+			if int(page[3].(int64)) != len(d.data) {
+				return nil, nil, fmt.Errorf("parquet: a data page of %d bytes, %d expected", len(d.data), page[3])
+			}
+			if values[i], d.err = plainValues(d.data, columns[i].Kind, group_rows); d.err != nil {
+				return nil, nil, d.err
+			}
+		}
+		for r := 0; r < group_rows; r++ {
+			row := make([]string, len(columns))
+			for i := range columns {
+				row[i] = values[i][r]
+			}
+			rows = append(rows, row)
+		}
+	}
+	if int(meta[3].(int64)) != len(rows) {
+		return nil, nil, fmt.Errorf("parquet: %d rows, %d read", meta[3], len(rows))
+	}
+	return columns, rows, nil
+}
+
+// the plain encoded values of a page, formatted as strings
+func plainValues(data []byte, kind Kind, n int) ([]string, error) {
+	values := make([]string, 0, n)
+	for len(values) < n {
+		switch {
+		case kind != String && len(data) >= 8:
+			v := binary.LittleEndian.Uint64(data)
+			if kind == Int {
+				values = append(values, strconv.FormatInt(int64(v), 10))
+			} else {
+				values = append(values, strconv.FormatFloat(math.Float64frombits(v), 'g', -1, 64))
+			}
+			data = data[8:]
+		case kind == String && len(data) >= 4 && int(binary.LittleEndian.Uint32(data)) <= len(data)-4:
+			length := int(binary.LittleEndian.Uint32(data))
+			values = append(values, string(data[4:4+length]))
+			data = data[4+length:]
+		default:
+			return nil, errors.New("parquet: truncated values")
+		}
+	}
+	if len(data) != 0 {
+		return nil, errors.New("parquet: values beyond the rows")
+	}
+	return values, nil
+}
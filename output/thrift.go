@@ -0,0 +1,111 @@
+/***************************************************
+* Author: Diana Cohen (sch.diana@gmail.com)
+* **************************************************
+* a minimal encoder of the Thrift compact protocol, enough for the Parquet metadata:
+* a field header holds the delta of the field id (from the previous field of the struct)
+* along with the type, integers are zigzag varints, binaries are prefixed by a varint length
+ */
+
+package output
+
+import "encoding/binary"
+
+// compact protocol types
+const (
+	thriftI32    = 5
+	thriftI64    = 6
+	thriftBinary = 8
+	thriftList   = 9
+	thriftStruct = 12
+)
+
+type thriftEncoder struct {
+	buf  []byte
+	last []int16 // the id of the latest field, per nested struct
+}
+
+func (e *thriftEncoder) beginStruct() {
+	e.last = append(e.last, 0)
+}
+
+func (e *thriftEncoder) endStruct() {
+	e.buf = append(e.buf, 0) // stop
+	e.last = e.last[:len(e.last)-1]
+}
+
+func (e *thriftEncoder) field(id int16, typ byte) {
+	top := len(e.last) - 1
+	if delta := id - e.last[top]; delta > 0 && delta <= 15 {
+		e.buf = append(e.buf, byte(delta)<<4|typ)
+	} else {
+		e.buf = append(e.buf, typ)
+		e.varint(int64(id))
+	}
+	e.last[top] = id
+}
+
+// zigzag varint
+func (e *thriftEncoder) varint(v int64) {
+	e.buf = binary.AppendUvarint(e.buf, uint64(v<<1)^uint64(v>>63))
+}
+
+func (e *thriftEncoder) i32(id int16, v int32) {
+	e.field(id, thriftI32)
+	e.varint(int64(v))
+}
+
+func (e *thriftEncoder) i64(id int16, v int64) {
+	e.field(id, thriftI64)
+	e.varint(v)
+}
+
+func (e *thriftEncoder) binary(id int16, v string) {
+	e.field(id, thriftBinary)
+	e.buf = binary.AppendUvarint(e.buf, uint64(len(v)))
+	e.buf = append(e.buf, v...)
+}
+
+// a nested struct, the fields are encoded by fill
+func (e *thriftEncoder) structField(id int16, fill func()) {
+	e.field(id, thriftStruct)
+	e.beginStruct()
+	fill()
+	e.endStruct()
+}
+
+func (e *thriftEncoder) listHeader(id int16, elem byte, size int) {
+	e.field(id, thriftList)
+	if size < 15 {
+		e.buf = append(e.buf, byte(size)<<4|elem)
+	} else {
+		e.buf = append(e.buf, 0xf0|elem)
+		e.buf = binary.AppendUvarint(e.buf, uint64(size))
+	}
+}
+
+// a list of i32 (or enum) values
+func (e *thriftEncoder) i32List(id int16, values ...int32) {
+	e.listHeader(id, thriftI32, len(values))
+	for _, v := range values {
+		e.varint(int64(v))
+	}
+}
+
+// a list of strings
+func (e *thriftEncoder) binaryList(id int16, values ...string) {
+	e.listHeader(id, thriftBinary, len(values))
+	for _, v := range values {
+		e.buf = binary.AppendUvarint(e.buf, uint64(len(v)))
+		e.buf = append(e.buf, v...)
+	}
+}
+
+// a list of structs, the fields of the i-th struct are encoded by fill(i)
+func (e *thriftEncoder) structList(id int16, size int, fill func(i int)) {
+	e.listHeader(id, thriftStruct, size)
+	for i := 0; i < size; i++ {
+		e.beginStruct()
+		fill(i)
+		e.endStruct()
+	}
+}
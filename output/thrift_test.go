@@ -0,0 +1,189 @@
+/***************************************************
+* Author: Diana Cohen (sch.diana@gmail.com)
+* **************************************************
+* test the Thrift compact encoder against bytes derived by hand from the compact protocol spec,
+* and decode the encoded structs back by a decoder of the spec (used by the Parquet reader of the tests)
+ */
+
+package output
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"testing"
+)
+
+func TestThriftEncoder(t *testing.T) {
+	tests := []struct {
+		name string
+		fill func(e *thriftEncoder)
+		want string // the fields of a struct, followed by its stop
+	}{
+		{"i32 of a short delta", func(e *thriftEncoder) { e.i32(1, -1) }, "1501" + "00"},
+		{"i64 of a long delta", func(e *thriftEncoder) { e.i64(20, 300) }, "0628d804" + "00"},
+		{"a decreasing field id", func(e *thriftEncoder) { e.i32(5, 1); e.i32(2, 1) }, "5502" + "050402" + "00"},
+		{"binary", func(e *thriftEncoder) { e.binary(1, "x") }, "180178" + "00"},
+		{"a short list", func(e *thriftEncoder) { e.i32List(1, 1, 2, 3) }, "1935020406" + "00"},
+		{"a list of 15", func(e *thriftEncoder) { e.i32List(1, make([]int32, 15)...) }, "19f50f" + "000000000000000000000000000000" + "00"},
+		{"a list of strings", func(e *thriftEncoder) { e.binaryList(3, "a", "bc") }, "392801610262 63" + "00"},
+		{"a nested struct", func(e *thriftEncoder) { e.structField(1, func() { e.i32(1, 7) }); e.i32(2, 1) }, "1c150e00" + "1502" + "00"},
+		{"a list of structs", func(e *thriftEncoder) { e.structList(1, 2, func(i int) { e.i32(1, int32(i)) }) }, "192c" + "150000" + "150200" + "00"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var e thriftEncoder
+			e.beginStruct()
+			test.fill(&e)
+			e.endStruct()
+			want, _ := hex.DecodeString(removeSpaces(test.want))
+			if !reflect.DeepEqual(e.buf, want) {
+				t.Errorf("encoded %x, expected %x", e.buf, want)
+			}
+		})
+	}
+}
+
+func TestThriftRoundTrip(t *testing.T) {
+	var e thriftEncoder
+	e.beginStruct()
+	e.i32(1, math.MinInt32)
+	e.i64(2, math.MaxInt64)
+	e.binary(40, "a field beyond a delta of 15")
+	e.structList(41, 20, func(i int) { e.i64(1, int64(-i)) })
+	e.binaryList(42)
+	e.endStruct()
+
+	d := thriftDecoder{data: e.buf}
+	fields := d.readStruct()
+	if d.err != nil || len(d.data) != 0 {
+		t.Fatalf("decoding: %v, %d bytes left", d.err, len(d.data))
+	}
+	items := make([]any, 20)
+	for i := range items {
+		items[i] = thriftFields{1: int64(-i)}
+	}
+	want := thriftFields{1: int64(math.MinInt32), 2: int64(math.MaxInt64), 40: "a field beyond a delta of 15", 41: items, 42: []any{}}
+	if !reflect.DeepEqual(fields, want) {
+		t.Errorf("decoded %v, expected %v", fields, want)
+	}
+}
+
+func removeSpaces(s string) string {
+	out := []byte{}
+	for i := 0; i < len(s); i++ {
+		if s[i] != ' ' {
+			out = append(out, s[i])
+		}
+	}
+	return string(out)
+}
+
+// thriftFields are the fields of a decoded struct by their ids: the integers are int64,
+// the binaries are strings, the lists are []any and the nested structs are thriftFields
+type thriftFields map[int16]any
+
+// thriftDecoder decodes the compact protocol, as the spec (not as the encoder) defines it
+type thriftDecoder struct {
+	data []byte
+	err  error
+}
+
+func (d *thriftDecoder) fail(err error) {
+	if d.err == nil {
+		d.err = err
+	}
+	d.data = nil
+}
+
+func (d *thriftDecoder) byte() byte {
+	if len(d.data) == 0 {
+		d.fail(errors.New("thrift: truncated"))
+		return 0
+	}
+	b := d.data[0]
+	d.data = d.data[1:]
+	return b
+}
+
+func (d *thriftDecoder) uvarint() uint64 {
+	v, n := binary.Uvarint(d.data)
+	if n <= 0 {
+		d.fail(errors.New("thrift: bad varint"))
+		return 0
+	}
+	d.data = d.data[n:]
+	return v
+}
+
+func (d *thriftDecoder) zigzag() int64 {
+	v := d.uvarint()
+	return int64(v>>1) ^ -int64(v&1)
+}
+
+func (d *thriftDecoder) readStruct() thriftFields {
+	fields := thriftFields{}
+	id := int16(0)
+	for d.err == nil {
+		header := d.byte()
+		if header == 0 { // stop
+			return fields
+		}
+		typ := header & 0x0f
+		if delta := int16(header >> 4); delta != 0 {
+			id += delta
+		} else {
+			id = int16(d.zigzag())
+		}
+		if typ == 1 || typ == 2 { // a bool is held by the type of its field
+			fields[id] = typ == 1
+			continue
+		}
+		fields[id] = d.value(typ)
+	}
+	return fields
+}
+
+func (d *thriftDecoder) value(typ byte) any {
+	switch typ {
+	case 3: // byte
+		return int64(int8(d.byte()))
+	case 4, 5, 6: // i16, i32, i64
+		return d.zigzag()
+	case 7: // double
+		if len(d.data) < 8 {
+			d.fail(errors.New("thrift: truncated"))
+			return nil
+		}
+		v := math.Float64frombits(binary.LittleEndian.Uint64(d.data))
+		d.data = d.data[8:]
+		return v
+	case 8: // binary
+		n := d.uvarint()
+		if n > uint64(len(d.data)) {
+			d.fail(errors.New("thrift: truncated binary"))
+			return nil
+		}
+		v := string(d.data[:n])
+		d.data = d.data[n:]
+		return v
+	case 9, 10: // list, set
+		header := d.byte()
+		size, elem := uint64(header>>4), header&0x0f
+		if size == 15 {
+			size = d.uvarint()
+		}
+		list := []any{}
+		for i := uint64(0); i < size && d.err == nil; i++ {
+			list = append(list, d.value(elem))
+		}
+		return list
+	case 12:
+		return d.readStruct()
+	}
+	d.fail(fmt.Errorf("thrift: unsupported type %d", typ))
+	return nil
+}
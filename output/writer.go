@@ -0,0 +1,95 @@
+/***************************************************
+* Author: Diana Cohen (sch.diana@gmail.com)
+* **************************************************
+* write the outfiles as tables, in one of the supported formats:
+* csv     - a header-row followed by the rows (the default)
+* jsonl   - JSON Lines, an object per row, numbers are written as JSON numbers
+* parquet - a Parquet file of plain encoded, uncompressed columns, in row groups
+* the commands format the values of a row as strings, the columns of a table
+* declare their kinds, so the typed formats parse the values accordingly
+ */
+
+package output
+
+import (
+	"fmt"
+	"io"
+)
+
+// supported output formats
+const (
+	FormatCSV     = "csv"
+	FormatJSONL   = "jsonl"
+	FormatParquet = "parquet"
+)
+
+// Kind is the type of the values of a column
+type Kind int
+
+const (
+	String Kind = iota
+	Int
+	Float
+)
+
+// Column is a named column of a table
+type Column struct {
+	Name string
+	Kind Kind
+}
+
+// Strings declares columns of strings
+func Strings(names ...string) []Column {
+	return columns(String, names)
+}
+
+// Ints declares columns of integers
+func Ints(names ...string) []Column {
+	return columns(Int, names)
+}
+
+// Floats declares columns of floating-point numbers
+func Floats(names ...string) []Column {
+	return columns(Float, names)
+}
+
+func columns(kind Kind, names []string) []Column {
+	cols := make([]Column, len(names))
+	for i, name := range names {
+		cols[i] = Column{Name: name, Kind: kind}
+	}
+	return cols
+}
+
+// Writer writes the rows of a table, given as the formatted values of the columns.
+// The errors are sticky: once a row fails, the later rows are ignored and Close reports the error.
+type Writer interface {
+	Write(row []string) error
+	Close() error // flush the rows, and complete the table, the underlying writer is not closed
+}
+
+// Ext returns the file extension of a given format
+func Ext(format string) string {
+	return "." + format
+}
+
+// NewWriter creates a writer of a table of given columns over w, in a given format
+func NewWriter(w io.Writer, format string, columns []Column) (Writer, error) {
+	switch format {
+	case FormatCSV, "":
+		return newCSVWriter(w, columns), nil
+	case FormatJSONL:
+		return newJSONLWriter(w, columns), nil
+	case FormatParquet:
+		return newParquetWriter(w, columns), nil
+	}
+	return nil, fmt.Errorf("output: unknown format %q (expected %s, %s or %s)", format, FormatCSV, FormatJSONL, FormatParquet)
+}
+
+// check that a row matches the columns of a table
+func checkRow(columns []Column, row []string) error {
+	if len(row) != len(columns) {
+		return fmt.Errorf("output: a row of %d values in a table of %d columns", len(row), len(columns))
+	}
+	return nil
+}
@@ -0,0 +1,215 @@
+/***************************************************
+* Author: Diana Cohen (sch.diana@gmail.com)
+* **************************************************
+* serialize a batched report, the (id, counter) pairs of the flows of a batch, in several encodings,
+* validating the theta model (b pairs of id_len + cnt_len bits instead of B ids of id_len bits)
+* against real encodings:
+* fixed  - an id of id_len bits and a counter of cnt_bits, each rounded up to whole bytes
+* varint - a fixed width id, and a varint counter (small counters take a single byte)
+* delta  - the ids sorted, each given as the delta from the previous id, bit-packed by the width of the
+*          largest delta (as the frame of reference encodings), followed by the varint counters
+* zstd   - the fixed width pairs, compressed by zstd
+* the ids are the flow-ids hashed to id_len bits (up to 128), i.e., uniformly spread over the id space,
+* so the gain of the delta encoding is about log2(b) bits per id, a lower bound for real (clustered) ids
+ */
+
+package report
+
+import (
+	"cmp"
+	"encoding/binary"
+	"errors"
+	"math/bits"
+	"slices"
+
+	"github.com/DianaCohenCS/measure-traces/sketch"
+	"github.com/klauspost/compress/zstd"
+)
+
+// the longest id, in bits
+const MaxIDLen = 128
+
+// ID is a flow-id of up to 128 bits
+type ID struct {
+	Hi, Lo uint64
+}
+
+// Pair is the (id, counter) pair of a flow within a batched report
+type Pair struct {
+	ID    ID
+	Count uint64
+}
+
+// Sizes are the bytes of a batch, per encoding
+type Sizes struct {
+	Raw    int // B ids of id_len bits
+	Model  int // b pairs of id_len + cnt_bits bits, as the theta model
+	Fixed  int
+	Varint int
+	Delta  int
+	Zstd   int
+}
+
+// Encoder serializes the batched reports of ids of a given length
+type Encoder struct {
+	id_len int
+	zstd   *zstd.Encoder
+}
+
+// NewEncoder creates an encoder of ids of id_len bits, within [1, 128]
+func NewEncoder(id_len int) (*Encoder, error) {
+	if id_len <= 0 || id_len > MaxIDLen {
+		return nil, errors.New("report: the id length must be within [1, 128] bits")
+	}
+	z, err := zstd.NewWriter(nil, zstd.WithEncoderConcurrency(1))
+	if err != nil {
+		return nil, err
+	}
+	return &Encoder{id_len: id_len, zstd: z}, nil
+}
+
+// HashID maps a flow-id to an id of id_len bits
+func (e *Encoder) HashID(flow_id string) ID {
+	id := ID{Lo: sketch.Hash64(flow_id, 0)}
+	if e.id_len > 64 {
+		id.Hi = sketch.Hash64(flow_id, 1) & mask(e.id_len-64)
+	} else {
+		id.Lo &= mask(e.id_len)
+	}
+	return id
+}
+
+func mask(bits int) uint64 {
+	if bits >= 64 {
+		return ^uint64(0)
+	}
+	return 1<<bits - 1
+}
+
+// Measure the sizes of a batch of B items, given its pairs and the width of a counter (in bits)
+func (e *Encoder) Measure(pairs []Pair, B, cnt_bits int) Sizes {
+	fixed := e.Fixed(pairs, cnt_bits)
+	return Sizes{
+		Raw:    (B*e.id_len + 7) / 8,
+		Model:  (len(pairs)*(e.id_len+cnt_bits) + 7) / 8,
+		Fixed:  len(fixed),
+		Varint: len(e.Varint(pairs)),
+		Delta:  len(e.Delta(pairs)),
+		Zstd:   len(e.zstd.EncodeAll(fixed, nil)),
+	}
+}
+
+// Fixed encodes the pairs by a fixed width: the id, then the counter, both big-endian
+func (e *Encoder) Fixed(pairs []Pair, cnt_bits int) []byte {
+	cnt_bytes := (cnt_bits + 7) / 8
+	buf := make([]byte, 0, len(pairs)*(e.idBytes()+cnt_bytes))
+	for _, p := range pairs {
+		buf = e.appendID(buf, p.ID)
+		for i := cnt_bytes - 1; i >= 0; i-- {
+			buf = append(buf, byte(p.Count>>(8*i)))
+		}
+	}
+	return buf
+}
+
+// Varint encodes the pairs by a fixed width id, and a varint counter
+func (e *Encoder) Varint(pairs []Pair) []byte {
+	var buf []byte
+	for _, p := range pairs {
+		buf = e.appendID(buf, p.ID)
+		buf = binary.AppendUvarint(buf, p.Count)
+	}
+	return buf
+}
+
+// Delta encodes the pairs in order of id: the number of pairs (varint) and the width of a delta (a byte),
+// the deltas from the previous id (the first id from 0) bit-packed by that width, then the varint counters
+func (e *Encoder) Delta(pairs []Pair) []byte {
+	sorted := slices.Clone(pairs)
+	slices.SortFunc(sorted, func(a, b Pair) int {
+		return cmp.Or(cmp.Compare(a.ID.Hi, b.ID.Hi), cmp.Compare(a.ID.Lo, b.ID.Lo))
+	})
+	deltas := make([]ID, len(sorted))
+	width := 0
+	prev := ID{}
+	for i, p := range sorted {
+		deltas[i] = sub128(p.ID, prev)
+		width = max(width, len128(deltas[i]))
+		prev = p.ID
+	}
+	buf := binary.AppendUvarint(nil, uint64(len(sorted)))
+	buf = append(buf, byte(width))
+	w := bitWriter{buf: buf}
+	for _, delta := range deltas {
+		if width > 64 {
+			w.write(delta.Hi, width-64)
+		}
+		w.write(delta.Lo, min(width, 64))
+	}
+	buf = w.flush()
+	for _, p := range sorted {
+		buf = binary.AppendUvarint(buf, p.Count)
+	}
+	return buf
+}
+
+func (e *Encoder) idBytes() int {
+	return (e.id_len + 7) / 8
+}
+
+// append the id, big-endian, as id_len bits rounded up to whole bytes
+func (e *Encoder) appendID(buf []byte, id ID) []byte {
+	for i := e.idBytes() - 1; i >= 0; i-- {
+		if i >= 8 {
+			buf = append(buf, byte(id.Hi>>(8*(i-8))))
+		} else {
+			buf = append(buf, byte(id.Lo>>(8*i)))
+		}
+	}
+	return buf
+}
+
+// a - b, given a >= b
+func sub128(a, b ID) ID {
+	lo := a.Lo - b.Lo
+	hi := a.Hi - b.Hi
+	if a.Lo < b.Lo { // borrow
+		hi--
+	}
+	return ID{Hi: hi, Lo: lo}
+}
+
+// the number of bits of a 128-bit value
+func len128(v ID) int {
+	if v.Hi != 0 {
+		return 64 + bits.Len64(v.Hi)
+	}
+	return bits.Len64(v.Lo)
+}
+
+// bitWriter packs values of any width (up to 64 bits), most significant bit first
+type bitWriter struct {
+	buf  []byte
+	acc  byte // the pending bits, of the last (partial) byte
+	used int  // the number of pending bits
+}
+
+func (w *bitWriter) write(v uint64, width int) {
+	for i := width - 1; i >= 0; i-- {
+		w.acc = w.acc<<1 | byte(v>>i&1)
+		w.used++
+		if w.used == 8 {
+			w.buf = append(w.buf, w.acc)
+			w.acc, w.used = 0, 0
+		}
+	}
+}
+
+// flush the pending bits, padded by zeros to a whole byte
+func (w *bitWriter) flush() []byte {
+	if w.used > 0 {
+		w.buf = append(w.buf, w.acc<<(8-w.used))
+		w.acc, w.used = 0, 0
+	}
+	return w.buf
+}
@@ -0,0 +1,257 @@
+/***************************************************
+* Author: Diana Cohen (sch.diana@gmail.com)
+* **************************************************
+* test the encodings of a batched report: the pairs encoded by fixed, varint and delta are decoded back
+* (the delta encoding in order of id, given the ids unsorted), and each encoding takes its exact size,
+* along with the bit-packing (bitWriter) and the 128-bit subtraction of the deltas
+ */
+
+package report
+
+import (
+	"bytes"
+	"cmp"
+	"encoding/binary"
+	"math"
+	"math/rand"
+	"reflect"
+	"slices"
+	"testing"
+)
+
+// bitReader reads the values packed by a bitWriter, most significant bit first
+type bitReader struct {
+	buf []byte
+	pos int // in bits
+}
+
+func (r *bitReader) read(width int) uint64 {
+	var v uint64
+	for i := 0; i < width; i++ {
+		v = v<<1 | uint64(r.buf[r.pos/8]>>(7-r.pos%8)&1)
+		r.pos++
+	}
+	return v
+}
+
+// read an id of id_len bits (rounded up to whole bytes), big-endian
+func readID(buf []byte, id_len int) (ID, []byte) {
+	n := (id_len + 7) / 8
+	var id ID
+	for _, b := range buf[:n] {
+		id.Hi = id.Hi<<8 | id.Lo>>56
+		id.Lo = id.Lo<<8 | uint64(b)
+	}
+	return id, buf[n:]
+}
+
+func decodeFixed(buf []byte, id_len, cnt_bits int) []Pair {
+	var pairs []Pair
+	for len(buf) > 0 {
+		var p Pair
+		p.ID, buf = readID(buf, id_len)
+		for _, b := range buf[:(cnt_bits+7)/8] {
+			p.Count = p.Count<<8 | uint64(b)
+		}
+		buf = buf[(cnt_bits+7)/8:]
+		pairs = append(pairs, p)
+	}
+	return pairs
+}
+
+func decodeVarint(buf []byte, id_len int) []Pair {
+	var pairs []Pair
+	for len(buf) > 0 {
+		var p Pair
+		var n int
+		p.ID, buf = readID(buf, id_len)
+		p.Count, n = binary.Uvarint(buf)
+		buf = buf[n:]
+		pairs = append(pairs, p)
+	}
+	return pairs
+}
+
+func decodeDelta(t *testing.T, buf []byte) []Pair {
+	t.Helper()
+	count, n := binary.Uvarint(buf)
+	width := int(buf[n])
+	buf = buf[n+1:]
+	pairs := make([]Pair, count)
+	r := bitReader{buf: buf}
+	prev := ID{}
+	for i := range pairs {
+		var delta ID
+		if width > 64 {
+			delta.Hi = r.read(width - 64)
+		}
+		delta.Lo = r.read(min(width, 64))
+		lo, carry := delta.Lo+prev.Lo, uint64(0)
+		if lo < prev.Lo {
+			carry = 1
+		}
+		prev = ID{Hi: prev.Hi + delta.Hi + carry, Lo: lo}
+		pairs[i].ID = prev
+	}
+	buf = buf[(r.pos+7)/8:]
+	for i := range pairs {
+		pairs[i].Count, n = binary.Uvarint(buf)
+		buf = buf[n:]
+	}
+	if len(buf) != 0 {
+		t.Errorf("%d bytes beyond the counters", len(buf))
+	}
+	return pairs
+}
+
+// the pairs of n random ids of id_len bits, in random order, of counters of every varint length
+func randomPairs(n, id_len int) []Pair {
+	r := rand.New(rand.NewSource(7))
+	pairs := make([]Pair, n)
+	for i := range pairs {
+		pairs[i].ID = ID{Lo: r.Uint64()}
+		if id_len > 64 {
+			pairs[i].ID.Hi = r.Uint64() & mask(id_len-64)
+		} else {
+			pairs[i].ID.Lo &= mask(id_len)
+		}
+		pairs[i].Count = r.Uint64() >> (r.Intn(64))
+	}
+	return pairs
+}
+
+func sortByID(pairs []Pair) []Pair {
+	sorted := slices.Clone(pairs)
+	slices.SortFunc(sorted, func(a, b Pair) int {
+		return cmp.Or(cmp.Compare(a.ID.Hi, b.ID.Hi), cmp.Compare(a.ID.Lo, b.ID.Lo))
+	})
+	return sorted
+}
+
+func varintBytes(pairs []Pair) int {
+	n := 0
+	for _, p := range pairs {
+		n += len(binary.AppendUvarint(nil, p.Count))
+	}
+	return n
+}
+
+func TestEncodeRoundTrip(t *testing.T) {
+	for _, id_len := range []int{1, 12, 32, 64, 65, 100, 128} {
+		e, err := NewEncoder(id_len)
+		if err != nil {
+			t.Fatal(err)
+		}
+		pairs := randomPairs(300, id_len)
+		id_bytes := (id_len + 7) / 8
+
+		fixed := e.Fixed(pairs, 64)
+		if len(fixed) != len(pairs)*(id_bytes+8) {
+			t.Errorf("id_len %d: fixed of %d bytes, expected %d", id_len, len(fixed), len(pairs)*(id_bytes+8))
+		}
+		if decoded := decodeFixed(fixed, id_len, 64); !reflect.DeepEqual(decoded, pairs) {
+			t.Errorf("id_len %d: fixed is not decoded back", id_len)
+		}
+
+		varint := e.Varint(pairs)
+		if want := len(pairs)*id_bytes + varintBytes(pairs); len(varint) != want {
+			t.Errorf("id_len %d: varint of %d bytes, expected %d", id_len, len(varint), want)
+		}
+		if decoded := decodeVarint(varint, id_len); !reflect.DeepEqual(decoded, pairs) {
+			t.Errorf("id_len %d: varint is not decoded back", id_len)
+		}
+
+		// the ids are unsorted, decoded in order of id
+		delta := e.Delta(pairs)
+		width := int(delta[len(binary.AppendUvarint(nil, uint64(len(pairs))))])
+		if want := 3 + (len(pairs)*width+7)/8 + varintBytes(pairs); len(delta) != want { // 300 (varint) and the width
+			t.Errorf("id_len %d: delta of %d bytes (width %d), expected %d", id_len, len(delta), width, want)
+		}
+		if width > id_len {
+			t.Errorf("id_len %d: a delta of %d bits", id_len, width)
+		}
+		if decoded := decodeDelta(t, delta); !reflect.DeepEqual(decoded, sortByID(pairs)) {
+			t.Errorf("id_len %d: delta is not decoded back in order of id", id_len)
+		}
+	}
+}
+
+func TestEncodeExact(t *testing.T) {
+	e, _ := NewEncoder(8)
+	pairs := []Pair{{ID{Lo: 5}, 300}, {ID{Lo: 1}, 1}, {ID{Lo: 3}, 2}}
+	tests := []struct {
+		name string
+		buf  []byte
+		want []byte
+	}{
+		{"fixed of 12 bits counters", e.Fixed(pairs, 12), []byte{5, 0x01, 0x2c, 1, 0, 1, 3, 0, 2}},
+		{"fixed of 1 bit counters", e.Fixed(pairs[1:], 1), []byte{1, 1, 3, 2}}, // truncated to a byte
+		{"varint", e.Varint(pairs), []byte{5, 0xac, 0x02, 1, 1, 3, 2}},
+		// ids 1, 3, 5: deltas 1, 2, 2 of 2 bits each, 01 10 10 (00 padding), the counters in order of id
+		{"delta", e.Delta(pairs), []byte{3, 2, 0x68, 1, 2, 0xac, 0x02}},
+		{"delta of no pairs", e.Delta(nil), []byte{0, 0}},
+		{"delta of a single zero id", e.Delta([]Pair{{ID{}, 7}}), []byte{1, 0, 7}},
+	}
+	for _, test := range tests {
+		if !bytes.Equal(test.buf, test.want) {
+			t.Errorf("%s: % x, expected % x", test.name, test.buf, test.want)
+		}
+	}
+
+	wide, _ := NewEncoder(128)
+	pairs = []Pair{{ID{Hi: 1, Lo: 0}, 1}, {ID{Hi: 0, Lo: math.MaxUint64}, 1}} // deltas of 64 bits, then 1 bit
+	if delta := wide.Delta(pairs); delta[1] != 64 || len(delta) != 2+(2*64)/8+2 {
+		t.Errorf("128 bits delta: width %d, %d bytes", delta[1], len(delta))
+	}
+	sizes := wide.Measure(pairs, 10, 32)
+	if sizes.Raw != 160 || sizes.Model != 40 || sizes.Fixed != 40 || sizes.Varint != 34 || sizes.Delta != 20 {
+		t.Errorf("sizes %+v", sizes)
+	}
+}
+
+func TestSub128(t *testing.T) {
+	tests := []struct {
+		a, b, diff ID
+	}{
+		{ID{0, 5}, ID{0, 3}, ID{0, 2}},
+		{ID{1, 0}, ID{0, 1}, ID{0, math.MaxUint64}}, // a borrow
+		{ID{5, 2}, ID{2, 7}, ID{2, math.MaxUint64 - 4}},
+		{ID{math.MaxUint64, math.MaxUint64}, ID{}, ID{math.MaxUint64, math.MaxUint64}},
+		{ID{3, 3}, ID{3, 3}, ID{}},
+	}
+	for _, test := range tests {
+		if diff := sub128(test.a, test.b); diff != test.diff {
+			t.Errorf("%v - %v = %v, expected %v", test.a, test.b, diff, test.diff)
+		}
+	}
+}
+
+func TestBitWriter(t *testing.T) {
+	values := []struct {
+		v     uint64
+		width int
+	}{{5, 3}, {0, 0}, {0x1f, 5}, {math.MaxUint64, 64}, {1, 1}, {0, 7}, {0xabc, 12}}
+	w := bitWriter{buf: []byte{0xee}} // appended after the existing bytes
+	bits := 0
+	for _, value := range values {
+		w.write(value.v, value.width)
+		bits += value.width
+	}
+	buf := w.flush()
+	if len(buf) != 1+(bits+7)/8 || buf[0] != 0xee {
+		t.Fatalf("%d bits into % x", bits, buf)
+	}
+	// 101 11111 (8 bits), 64 ones, 1, 0000000, 1010 1011 1100
+	if want := []byte{0xbf, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x80, 0xab, 0xc0}; !bytes.Equal(buf[1:], want) {
+		t.Errorf("% x, expected % x", buf[1:], want)
+	}
+	r := bitReader{buf: buf[1:]}
+	for _, value := range values {
+		if v := r.read(value.width); v != value.v {
+			t.Errorf("read %#x of %d bits, expected %#x", v, value.width, value.v)
+		}
+	}
+	if w.flush(); len(w.buf) != len(buf) {
+		t.Error("a second flush wrote the padding again")
+	}
+}
@@ -0,0 +1,394 @@
+/***************************************************
+* Author: Diana Cohen (sch.diana@gmail.com)
+* **************************************************
+* export the batched reports as an IPFIX-like stream (RFC 7011), over UDP, TCP or into a file:
+* a message is a header (version 10, length, export time, sequence number, observation domain)
+* followed by sets, a template set (id 2) declares the records, a data set (id = template id) holds them
+* two templates, the elements are enterprise-specific (PEN 32473, reserved for documentation),
+* except for packetDeltaCount (IANA element 2):
+* 256 flow record  - batch (4 bytes), packetDeltaCount (8 bytes), flowKey (variable length)
+* 257 batch record - batch (4 bytes), packetDeltaCount (8 bytes, B), flowCount (8 bytes, b)
+* the flow records of a batch are followed by its batch record, the sequence number of a message is the
+* number of data records exported before it (within the domain), so the collector detects the losses
+* the templates are sent in the first message, and then every templateRefresh messages (for UDP)
+* the decoder learns the templates per domain, a template of no fields withdraws it (or withdraws all
+* the templates of the domain, given the id of the template set), and the data sets of a template of
+* empty records (fields of no length) are rejected
+ */
+
+package report
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+// IPFIX constants
+const (
+	ipfixVersion    = 10
+	headerLen       = 16
+	setHeaderLen    = 4
+	templateSetID   = 2
+	FlowTemplateID  = 256
+	BatchTemplateID = 257
+	enterpriseBit   = 0x8000
+	pen             = 32473 // the private enterprise number for documentation (RFC 5612)
+	varLength       = 65535
+	templateRefresh = 64 // messages between the templates
+)
+
+// the information elements
+const (
+	iePacketDeltaCount = 2 // IANA
+	ieBatch            = 1 // enterprise-specific
+	ieFlowKey          = 2
+	ieFlowCount        = 3
+)
+
+// MaxMessage is the largest message, the length of a message is 16 bits
+const MaxMessage = 65535
+
+type field struct {
+	id     uint16
+	length uint16
+	pen    uint32 // 0 for IANA elements
+}
+
+var templates = map[uint16][]field{
+	FlowTemplateID:  {{ieBatch, 4, pen}, {iePacketDeltaCount, 8, 0}, {ieFlowKey, varLength, pen}},
+	BatchTemplateID: {{ieBatch, 4, pen}, {iePacketDeltaCount, 8, 0}, {ieFlowCount, 8, pen}},
+}
+
+// Flow is a flow record: the count of a flow within a batch
+type Flow struct {
+	Batch uint32
+	Count uint64
+	Key   string
+}
+
+// BatchEnd is a batch record, following the flow records of a batch
+type BatchEnd struct {
+	Batch uint32
+	Items uint64 // B
+	Flows uint64 // b
+}
+
+// Exporter writes the batched reports of an observation domain as messages, each message by a single Write
+// (a datagram over UDP), several exporters (domains) may share a writer
+type Exporter struct {
+	w           io.Writer
+	domain      uint32
+	max_message int
+	seq         uint32 // the data records exported so far
+	messages    int
+	msg         []byte // the current message
+	set         int    // the offset of the current data set, 0 if none
+	set_id      uint16
+	records     uint32 // the data records of the current message
+}
+
+// NewExporter creates an exporter of a given observation domain, of messages up to max_message bytes
+func NewExporter(w io.Writer, domain uint32, max_message int) (*Exporter, error) {
+	if max_message < 512 || max_message > MaxMessage {
+		return nil, fmt.Errorf("IPFIX: the message size must be within [512, %d]", MaxMessage)
+	}
+	return &Exporter{w: w, domain: domain, max_message: max_message}, nil
+}
+
+// Batch exports the flow records of a batch of B items, followed by its batch record
+func (e *Exporter) Batch(batch uint32, B uint64, flows []Flow) error {
+	for _, flow := range flows {
+		if len(flow.Key) >= varLength {
+			return errors.New("IPFIX: the flow key is too long")
+		}
+		rec := binary.BigEndian.AppendUint32(nil, batch)
+		rec = binary.BigEndian.AppendUint64(rec, flow.Count)
+		rec = appendVarLength(rec, flow.Key)
+		if err := e.record(FlowTemplateID, rec); err != nil {
+			return err
+		}
+	}
+	rec := binary.BigEndian.AppendUint32(nil, batch)
+	rec = binary.BigEndian.AppendUint64(rec, B)
+	rec = binary.BigEndian.AppendUint64(rec, uint64(len(flows)))
+	return e.record(BatchTemplateID, rec)
+}
+
+// add a data record to the current message, sending the message once it is full
+func (e *Exporter) record(template uint16, rec []byte) error {
+	if e.msg != nil && len(e.msg)+setHeaderLen+len(rec) > e.max_message {
+		if err := e.Flush(); err != nil {
+			return err
+		}
+	}
+	if e.msg == nil {
+		e.begin()
+		if len(e.msg)+setHeaderLen+len(rec) > e.max_message {
+			return errors.New("IPFIX: the record does not fit in a message")
+		}
+	}
+	if e.set == 0 || e.set_id != template {
+		e.endSet()
+		e.set, e.set_id = len(e.msg), template
+		e.msg = binary.BigEndian.AppendUint16(e.msg, template)
+		e.msg = append(e.msg, 0, 0) // the length, set by endSet
+	}
+	e.msg = append(e.msg, rec...)
+	e.records++
+	return nil
+}
+
+// begin a message, along with the templates in case of the first message, or a refresh
+func (e *Exporter) begin() {
+	e.msg = make([]byte, headerLen, e.max_message)
+	if e.messages%templateRefresh == 0 {
+		start := len(e.msg)
+		e.msg = binary.BigEndian.AppendUint16(e.msg, templateSetID)
+		e.msg = append(e.msg, 0, 0)
+		for _, id := range []uint16{FlowTemplateID, BatchTemplateID} {
+			e.msg = binary.BigEndian.AppendUint16(e.msg, id)
+			e.msg = binary.BigEndian.AppendUint16(e.msg, uint16(len(templates[id])))
+			for _, f := range templates[id] {
+				id := f.id
+				if f.pen != 0 {
+					id |= enterpriseBit
+				}
+				e.msg = binary.BigEndian.AppendUint16(e.msg, id)
+				e.msg = binary.BigEndian.AppendUint16(e.msg, f.length)
+				if f.pen != 0 {
+					e.msg = binary.BigEndian.AppendUint32(e.msg, f.pen)
+				}
+			}
+		}
+		binary.BigEndian.PutUint16(e.msg[start+2:], uint16(len(e.msg)-start))
+	}
+}
+
+func (e *Exporter) endSet() {
+	if e.set != 0 {
+		binary.BigEndian.PutUint16(e.msg[e.set+2:], uint16(len(e.msg)-e.set))
+		e.set = 0
+	}
+}
+
+// Flush sends the current message, if any
+func (e *Exporter) Flush() error {
+	if e.msg == nil {
+		return nil
+	}
+	e.endSet()
+	binary.BigEndian.PutUint16(e.msg[0:], ipfixVersion)
+	binary.BigEndian.PutUint16(e.msg[2:], uint16(len(e.msg)))
+	binary.BigEndian.PutUint32(e.msg[4:], uint32(time.Now().Unix()))
+	binary.BigEndian.PutUint32(e.msg[8:], e.seq)
+	binary.BigEndian.PutUint32(e.msg[12:], e.domain)
+	_, err := e.w.Write(e.msg)
+	e.seq += e.records
+	e.records = 0
+	e.messages++
+	e.msg = nil
+	return err
+}
+
+// a variable length value: a single byte length, or 255 followed by a 16 bits length
+func appendVarLength(buf []byte, value string) []byte {
+	if len(value) < 255 {
+		buf = append(buf, byte(len(value)))
+	} else {
+		buf = append(buf, 255)
+		buf = binary.BigEndian.AppendUint16(buf, uint16(len(value)))
+	}
+	return append(buf, value...)
+}
+
+// Message is a decoded message
+type Message struct {
+	Domain     uint32
+	Seq        uint32 // the data records exported before the message
+	ExportTime time.Time
+	Flows      []Flow
+	Batches    []BatchEnd
+	Records    uint32 // the data records of the message
+	Unknown    int    // the data sets of unknown templates, skipped
+}
+
+// Decoder decodes the messages, learning the templates per domain
+type Decoder struct {
+	templates map[[2]uint32][]field // (domain, template id) -> fields
+}
+
+// NewDecoder creates a decoder, learning the templates from the messages
+func NewDecoder() *Decoder {
+	return &Decoder{templates: make(map[[2]uint32][]field)}
+}
+
+// ReadMessage reads a message from a stream (TCP or a file), io.EOF once the stream is over
+func (d *Decoder) ReadMessage(r io.Reader) (*Message, error) {
+	header := make([]byte, headerLen)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, err
+	}
+	length := int(binary.BigEndian.Uint16(header[2:]))
+	if length < headerLen {
+		return nil, fmt.Errorf("IPFIX: bad message length %d", length)
+	}
+	data := make([]byte, length)
+	copy(data, header)
+	if _, err := io.ReadFull(r, data[headerLen:]); err != nil {
+		return nil, fmt.Errorf("IPFIX: truncated message: %w", err)
+	}
+	return d.Decode(data)
+}
+
+// Decode a message, given as a whole (a datagram)
+func (d *Decoder) Decode(data []byte) (*Message, error) {
+	if len(data) < headerLen {
+		return nil, errors.New("IPFIX: short message")
+	}
+	if v := binary.BigEndian.Uint16(data); v != ipfixVersion {
+		return nil, fmt.Errorf("IPFIX: unsupported version %d", v)
+	}
+	if int(binary.BigEndian.Uint16(data[2:])) != len(data) {
+		return nil, errors.New("IPFIX: the message length does not match")
+	}
+	m := &Message{
+		ExportTime: time.Unix(int64(binary.BigEndian.Uint32(data[4:])), 0),
+		Seq:        binary.BigEndian.Uint32(data[8:]),
+		Domain:     binary.BigEndian.Uint32(data[12:]),
+	}
+	for rest := data[headerLen:]; len(rest) > 0; {
+		if len(rest) < setHeaderLen {
+			return nil, errors.New("IPFIX: short set header")
+		}
+		id, length := binary.BigEndian.Uint16(rest), int(binary.BigEndian.Uint16(rest[2:]))
+		if length < setHeaderLen || length > len(rest) {
+			return nil, fmt.Errorf("IPFIX: bad set length %d", length)
+		}
+		body := rest[setHeaderLen:length]
+		rest = rest[length:]
+		var err error
+		switch {
+		case id == templateSetID:
+			err = d.decodeTemplates(m.Domain, body)
+		case id >= 256:
+			err = d.decodeData(m, id, body)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return m, nil
+}
+
+func (d *Decoder) decodeTemplates(domain uint32, body []byte) error {
+	for len(body) >= 4 {
+		id, count := binary.BigEndian.Uint16(body), int(binary.BigEndian.Uint16(body[2:]))
+		body = body[4:]
+		if count == 0 { // a withdrawal, of all the templates given the id of the template set
+			for key := range d.templates {
+				if key[0] == domain && (key[1] == uint32(id) || id == templateSetID) {
+					delete(d.templates, key)
+				}
+			}
+			continue
+		}
+		fields := make([]field, count)
+		for i := range fields {
+			if len(body) < 4 {
+				return errors.New("IPFIX: short template")
+			}
+			f := field{id: binary.BigEndian.Uint16(body), length: binary.BigEndian.Uint16(body[2:])}
+			body = body[4:]
+			if f.id&enterpriseBit != 0 {
+				if len(body) < 4 {
+					return errors.New("IPFIX: short template")
+				}
+				f.id &^= enterpriseBit
+				f.pen = binary.BigEndian.Uint32(body)
+				body = body[4:]
+			}
+			fields[i] = f
+		}
+		d.templates[[2]uint32{domain, uint32(id)}] = fields
+	}
+	return nil
+}
+
+// decode the records of a data set by its template, the fields are matched by their elements
+func (d *Decoder) decodeData(m *Message, id uint16, body []byte) error {
+	fields, found := d.templates[[2]uint32{m.Domain, uint32(id)}]
+	if !found {
+		m.Unknown++
+		return nil
+	}
+	min_len := 0 // the shortest record, any shorter remainder is padding
+	for _, f := range fields {
+		if f.length == varLength {
+			min_len++
+		} else {
+			min_len += int(f.length)
+		}
+	}
+	if min_len == 0 {
+		return fmt.Errorf("IPFIX: the records of template %d are empty", id)
+	}
+	for len(body) >= min_len {
+		var flow Flow
+		var end BatchEnd
+		flow_count, has_key := false, false
+		for _, f := range fields {
+			length := int(f.length)
+			if f.length == varLength {
+				if len(body) < 1 {
+					return errors.New("IPFIX: short record")
+				}
+				length, body = int(body[0]), body[1:]
+				if length == 255 {
+					if len(body) < 2 {
+						return errors.New("IPFIX: short record")
+					}
+					length, body = int(binary.BigEndian.Uint16(body)), body[2:]
+				}
+			}
+			if len(body) < length {
+				return errors.New("IPFIX: short record")
+			}
+			value := body[:length]
+			body = body[length:]
+			switch {
+			case f.pen == pen && f.id == ieBatch:
+				flow.Batch = uint32(uintValue(value))
+				end.Batch = flow.Batch
+			case f.pen == 0 && f.id == iePacketDeltaCount:
+				flow.Count = uintValue(value)
+				end.Items = flow.Count
+			case f.pen == pen && f.id == ieFlowKey:
+				flow.Key = string(value)
+				has_key = true
+			case f.pen == pen && f.id == ieFlowCount:
+				end.Flows = uintValue(value)
+				flow_count = true
+			}
+		}
+		switch {
+		case has_key:
+			m.Flows = append(m.Flows, flow)
+		case flow_count:
+			m.Batches = append(m.Batches, end)
+		}
+		m.Records++
+	}
+	return nil
+}
+
+// an unsigned integer of up to 8 bytes, big-endian (reduced-size encoding)
+func uintValue(value []byte) uint64 {
+	v := uint64(0)
+	for _, c := range value {
+		v = v<<8 | uint64(c)
+	}
+	return v
+}
@@ -0,0 +1,233 @@
+/***************************************************
+* Author: Diana Cohen (sch.diana@gmail.com)
+* **************************************************
+* test the IPFIX-like stream: the batches exported into a stream are decoded back (over messages
+* of the templates, their refresh and the sequence numbers), and malformed messages are rejected rather
+* than decoded forever: truncated headers, sets and records, and templates of empty records; a template
+* of no fields withdraws it
+ */
+
+package report
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// the batches of a test: flow keys of every length class (empty, short, long), and empty batches
+func testBatches() [][]Flow {
+	batches := make([][]Flow, 200)
+	for b := range batches {
+		for i := 0; i < b%7; i++ {
+			key := fmt.Sprintf("flow%d.%d", b, i)
+			switch i {
+			case 1:
+				key = ""
+			case 2:
+				key = strings.Repeat("k", 300) // a key beyond 254 bytes takes a 16 bits length
+			}
+			batches[b] = append(batches[b], Flow{Batch: uint32(b), Count: uint64(b*10 + i), Key: key})
+		}
+	}
+	return batches
+}
+
+func TestIPFIXRoundTrip(t *testing.T) {
+	batches := testBatches()
+	var stream bytes.Buffer
+	exporters := make([]*Exporter, 2) // two domains on a stream
+	for k := range exporters {
+		exporters[k], _ = NewExporter(&stream, uint32(100*(k+1)), 512)
+	}
+	for b, flows := range batches {
+		for _, e := range exporters {
+			if err := e.Batch(uint32(b), uint64(1000+b), flows); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	for _, e := range exporters {
+		e.Flush()
+	}
+
+	d := NewDecoder()
+	decoded := map[uint32][][]Flow{}
+	seqs := map[uint32]uint32{}
+	messages := 0
+	for {
+		m, err := d.ReadMessage(&stream)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		messages++
+		if m.Seq != seqs[m.Domain] {
+			t.Errorf("domain %d: sequence number %d, expected %d", m.Domain, m.Seq, seqs[m.Domain])
+		}
+		seqs[m.Domain] += m.Records
+		if m.Unknown != 0 || m.Records != uint32(len(m.Flows)+len(m.Batches)) {
+			t.Errorf("domain %d: %d records of %d flows and %d batches, %d unknown",
+				m.Domain, m.Records, len(m.Flows), len(m.Batches), m.Unknown)
+		}
+		// every batch record follows the flow records of its batch
+		flows := m.Flows
+		for _, end := range m.Batches {
+			var batch []Flow
+			for len(flows) > 0 && flows[0].Batch == end.Batch {
+				batch, flows = append(batch, flows[0]), flows[1:]
+			}
+			decoded[m.Domain] = append(decoded[m.Domain], batch)
+			if end.Items != uint64(1000+end.Batch) || end.Flows != uint64(len(batches[end.Batch])) {
+				t.Errorf("domain %d: batch record %+v", m.Domain, end)
+			}
+		}
+		if len(flows) > 0 { // the flows of a batch spread over messages
+			decoded[m.Domain] = append(decoded[m.Domain], flows)
+		}
+	}
+	if messages <= templateRefresh {
+		t.Errorf("%d messages, expected a refresh of the templates", messages)
+	}
+	for _, e := range exporters {
+		if !reflect.DeepEqual(merged(decoded[e.domain]), merged(batches)) {
+			t.Errorf("domain %d: the decoded flows differ", e.domain)
+		}
+	}
+}
+
+// the flows of the batches, in order
+func merged(batches [][]Flow) []Flow {
+	var flows []Flow
+	for _, batch := range batches {
+		flows = append(flows, batch...)
+	}
+	return flows
+}
+
+// a message of a domain, holding the given sets
+func message(domain uint32, sets ...[]byte) []byte {
+	msg := binary.BigEndian.AppendUint16(nil, ipfixVersion)
+	msg = binary.BigEndian.AppendUint16(msg, uint16(headerLen+len(bytes.Join(sets, nil))))
+	msg = binary.BigEndian.AppendUint32(msg, 0)
+	msg = binary.BigEndian.AppendUint32(msg, 0)
+	msg = binary.BigEndian.AppendUint32(msg, domain)
+	return append(msg, bytes.Join(sets, nil)...)
+}
+
+// a set of a given id, holding the given body
+func set(id uint16, body ...byte) []byte {
+	s := binary.BigEndian.AppendUint16(nil, id)
+	s = binary.BigEndian.AppendUint16(s, uint16(setHeaderLen+len(body)))
+	return append(s, body...)
+}
+
+// a template record of IANA fields of given (id, length) pairs
+func template(id uint16, fields ...uint16) []byte {
+	rec := binary.BigEndian.AppendUint16(nil, id)
+	rec = binary.BigEndian.AppendUint16(rec, uint16(len(fields)/2))
+	for _, f := range fields {
+		rec = binary.BigEndian.AppendUint16(rec, f)
+	}
+	return rec
+}
+
+func TestIPFIXMalformed(t *testing.T) {
+	// a valid message, holding the templates along with a flow record and a batch record
+	var valid bytes.Buffer
+	e, _ := NewExporter(&valid, 1, 512)
+	e.Batch(3, 10, []Flow{{3, 10, "flow1"}})
+	e.Flush()
+	templates := valid.Bytes()[headerLen : headerLen+int(binary.BigEndian.Uint16(valid.Bytes()[headerLen+2:]))]
+	flow_set := valid.Bytes()[headerLen+len(templates):]
+
+	tests := []struct {
+		name string
+		msgs [][]byte // decoded in turn, the last one fails
+		err  string
+	}{
+		{"a short message", [][]byte{valid.Bytes()[:headerLen-1]}, "short message"},
+		{"version 9", [][]byte{append([]byte{0, 9}, valid.Bytes()[2:]...)}, "unsupported version"},
+		{"a longer message", [][]byte{append(bytes.Clone(valid.Bytes()), 0)}, "length does not match"},
+		{"a short set header", [][]byte{message(1, []byte{0, 2, 0})}, "short set header"},
+		{"a set of no header", [][]byte{message(1, []byte{1, 0, 0, 3})}, "bad set length"},
+		{"a set beyond the message", [][]byte{message(1, []byte{1, 0, 0, 9, 0})}, "bad set length"},
+		{"a short template", [][]byte{message(1, set(templateSetID, template(256, 1, 4)[:6]...))}, "short template"},
+		{"a short enterprise field", [][]byte{message(1, set(templateSetID, template(256, enterpriseBit|1, 4)...))}, "short template"},
+		{"a record followed by padding", [][]byte{message(1, set(templateSetID, template(256, 1, 4, 2, 8)...), set(256, make([]byte, 13)...))}, ""},
+		{"a short variable length", [][]byte{message(1, templates, set(256, append(make([]byte, 12), 5, 'f')...))}, "short record"},
+		{"a short 16 bits length", [][]byte{message(1, templates, set(256, append(make([]byte, 12), 255, 1)...))}, "short record"},
+		{"a template of no fields", [][]byte{message(1, set(templateSetID, template(300)...), set(300, 0, 0, 0, 0))}, ""},
+		{"a template of empty fields", [][]byte{message(1, set(templateSetID, template(300, 1, 0, 2, 0)...), set(300, 0, 0, 0, 0))}, "empty"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := decodeAll(t, NewDecoder(), test.msgs...)
+			if test.err == "" && err != nil || test.err != "" && (err == nil || !strings.Contains(err.Error(), test.err)) {
+				t.Errorf("decoded to %v, expected %q", err, test.err)
+			}
+		})
+	}
+
+	// the data sets of a withdrawn template are unknown, the other templates remain
+	d := NewDecoder()
+	if _, err := decodeAll(t, d, valid.Bytes()); err != nil {
+		t.Fatal(err)
+	}
+	withdrawals := []struct {
+		name     string
+		template uint16
+		unknown  int
+	}{
+		{"the flow template", FlowTemplateID, 1},
+		{"all the templates", templateSetID, 2},
+	}
+	for _, test := range withdrawals {
+		m, err := decodeAll(t, d, message(1, set(templateSetID, template(test.template)...), flow_set))
+		if err != nil {
+			t.Fatalf("withdrawing %s: %v", test.name, err)
+		}
+		if m.Unknown != test.unknown {
+			t.Errorf("withdrawing %s: %d unknown sets, expected %d", test.name, m.Unknown, test.unknown)
+		}
+		// the templates of another domain are not withdrawn
+		if m, err := decodeAll(t, d, message(2, templates, flow_set)); err != nil || m.Unknown != 0 {
+			t.Errorf("withdrawing %s: domain 2 decoded to %v, expected no unknown sets", test.name, err)
+		}
+	}
+}
+
+// decode the messages in turn, returning the last one, failing the test if a message is decoded forever
+func decodeAll(t *testing.T, d *Decoder, msgs ...[]byte) (*Message, error) {
+	t.Helper()
+	type result struct {
+		m   *Message
+		err error
+	}
+	done := make(chan result, 1)
+	go func() {
+		var m *Message
+		var err error
+		for _, msg := range msgs {
+			if m, err = d.Decode(msg); err != nil {
+				break
+			}
+		}
+		done <- result{m, err}
+	}()
+	select {
+	case r := <-done:
+		return r.m, r.err
+	case <-time.After(5 * time.Second):
+		t.Fatal("the decoder does not terminate")
+		return nil, errors.New("timeout")
+	}
+}
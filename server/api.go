@@ -0,0 +1,317 @@
+/***************************************************
+* Author: Diana Cohen (sch.diana@gmail.com)
+* **************************************************
+* the HTTP API of named sketches, so other services use the CMS without linking the go code:
+* POST   /sketches                      - create a sketch: {"name", "epsilon", "delta", "seed", "top"},
+*                                         the omitted params are the defaults of the API (a seed of 0 is random)
+* GET    /sketches                      - the stats of all the sketches, by name
+* DELETE /sketches/{name}               - delete a sketch
+* POST   /sketches/{name}/update        - update a key: {"key", "count"}, the count is 1 if omitted (0 is rejected)
+* POST   /sketches/{name}/updates       - update several keys at once: [{"key", "count"}, ...]
+* GET    /sketches/{name}/estimate?key= - the point estimate of a key, along with the bound of its error
+* GET    /sketches/{name}/topk?k=       - the heavy hitters
+* POST   /sketches/{name}/merge         - merge a snapshot (the body) of the same dimensions and seed, either binary
+*                                         or protobuf (Content-Type: application/x-protobuf, a CMSSnapshot)
+* GET    /sketches/{name}/snapshot      - the snapshot of the sketch, binary or protobuf (see NewHandler)
+* GET    /sketches/{name}/stats         - the stats of the sketch
+* the queries are those of a single sketch, see NewHandler
+* a sketch is created within MaxCounters, and a merged snapshot is read up to the largest snapshot of its sketch
+* the counters of all the sketches are bounded by the memory of the API, a sketch beyond it is not created (507)
+ */
+
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"sync"
+
+	"github.com/DianaCohenCS/measure-traces/sketch"
+)
+
+// the largest JSON body that is read, a snapshot body is bounded by its sketch (see Sketch.maxSnapshot)
+const maxJSONBody = 16 << 20
+
+// MaxCounters bounds the sketches that are created, whose counters (of the default width) fit within
+// the largest sketch that is decoded, see sketch.MaxBytes
+const MaxCounters = sketch.MaxBytes / (sketch.DefaultCounterBits / 8)
+
+// Defaults are the params of a sketch that are omitted at its creation
+type Defaults struct {
+	Epsilon float64
+	Delta   float64
+	Top     int // the heavy hitters, 0 is off
+}
+
+// API serves the named sketches
+type API struct {
+	mu        sync.RWMutex
+	sketches  map[string]*Sketch
+	bytes     int64 // the bytes of the counters of the sketches
+	max_bytes int64 // the memory of the API, 0 is unbounded
+	defaults  Defaults
+	mux       *http.ServeMux
+}
+
+// errNoSpace marks the sketches that are beyond the memory of the API
+var errNoSpace = errors.New("no space")
+
+// createRequest creates a sketch
+type createRequest struct {
+	Name    string   `json:"name"`
+	Epsilon *float64 `json:"epsilon"`
+	Delta   *float64 `json:"delta"`
+	Seed    uint64   `json:"seed"`
+	Top     *int     `json:"top"`
+}
+
+// updateRequest updates a key, telling an omitted count (nil) from a given one
+type updateRequest struct {
+	Key   string `json:"key"`
+	Count *int   `json:"count"`
+}
+
+// NewAPI creates an API with no sketches, given the defaults of the params of a sketch,
+// and the memory of the API, the total bytes of the counters of its sketches (0 is unbounded)
+func NewAPI(defaults Defaults, max_bytes int64) *API {
+	api := &API{sketches: make(map[string]*Sketch), max_bytes: max_bytes, defaults: defaults, mux: http.NewServeMux()}
+	api.mux.HandleFunc("POST /sketches", api.create)
+	api.mux.HandleFunc("GET /sketches", api.list)
+	api.mux.HandleFunc("DELETE /sketches/{name}", api.delete)
+	api.mux.HandleFunc("POST /sketches/{name}/update", api.with(serveUpdate))
+	api.mux.HandleFunc("POST /sketches/{name}/updates", api.with(serveUpdates))
+	api.mux.HandleFunc("GET /sketches/{name}/estimate", api.with(serveEstimate))
+	api.mux.HandleFunc("GET /sketches/{name}/topk", api.with(serveTop))
+	api.mux.HandleFunc("POST /sketches/{name}/merge", api.with(serveMerge))
+	api.mux.HandleFunc("GET /sketches/{name}/snapshot", api.with(func(s *Sketch, w http.ResponseWriter, r *http.Request) {
+		serveSnapshot(s, w, r)
+	}))
+	api.mux.HandleFunc("GET /sketches/{name}/stats", api.with(func(s *Sketch, w http.ResponseWriter, r *http.Request) {
+		WriteJSON(w, http.StatusOK, s.Stats())
+	}))
+	return api
+}
+
+func (api *API) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	api.mux.ServeHTTP(w, r)
+}
+
+// Add a sketch of a given name, failing if the name is taken or the sketch is beyond the memory of the API
+func (api *API) Add(name string, s *Sketch) error {
+	api.mu.Lock()
+	defer api.mu.Unlock()
+	if err := api.fits(name, s.bytes()); err != nil {
+		return err
+	}
+	api.sketches[name] = s
+	api.bytes += s.bytes()
+	return nil
+}
+
+// whether a sketch of a given name and bytes of counters is added, checked before its counters are allocated
+func (api *API) canAdd(name string, bytes int64) error {
+	api.mu.RLock()
+	defer api.mu.RUnlock()
+	return api.fits(name, bytes)
+}
+
+// whether the name of a sketch is free and its counters fit within the memory left, given the lock
+func (api *API) fits(name string, bytes int64) error {
+	if _, found := api.sketches[name]; found {
+		return fmt.Errorf("sketch %q already exists", name)
+	}
+	if api.max_bytes > 0 && api.bytes+bytes > api.max_bytes {
+		return fmt.Errorf("%w: a sketch of %d bytes exceeds the %d bytes left of %d bytes",
+			errNoSpace, bytes, api.max_bytes-api.bytes, api.max_bytes)
+	}
+	return nil
+}
+
+// Get the sketch of a given name, nil if none
+func (api *API) Get(name string) *Sketch {
+	api.mu.RLock()
+	defer api.mu.RUnlock()
+	return api.sketches[name]
+}
+
+// with looks up the sketch of the path before handling a request
+func (api *API) with(handle func(s *Sketch, w http.ResponseWriter, r *http.Request)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		s := api.Get(r.PathValue("name"))
+		if s == nil {
+			writeError(w, http.StatusNotFound, fmt.Errorf("no sketch %q", r.PathValue("name")))
+			return
+		}
+		handle(s, w, r)
+	}
+}
+
+func (api *API) create(w http.ResponseWriter, r *http.Request) {
+	var req createRequest
+	if err := readJSON(w, r, &req); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if req.Name == "" {
+		writeError(w, http.StatusBadRequest, errors.New("the name is missing"))
+		return
+	}
+	epsilon, delta, top := api.defaults.Epsilon, api.defaults.Delta, api.defaults.Top
+	if req.Epsilon != nil {
+		epsilon = *req.Epsilon
+	}
+	if req.Delta != nil {
+		delta = *req.Delta
+	}
+	if req.Top != nil {
+		top = *req.Top
+	}
+	if top < 0 {
+		writeError(w, http.StatusBadRequest, errors.New("top must not be negative"))
+		return
+	}
+	d, width, err := sketch.Dimensions(epsilon, delta)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if d*width > MaxCounters {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("a sketch of %d x %d counters exceeds %d counters", d, width, MaxCounters))
+		return
+	}
+	if err := api.canAdd(req.Name, int64(d)*int64(width)*sketch.DefaultCounterBits/8); err != nil {
+		writeAddError(w, err)
+		return
+	}
+	cms, err := sketch.New(d, width)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if req.Seed != 0 {
+		cms.SetSeed(req.Seed)
+	}
+	s, err := NewSketch(cms, top)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if err := api.Add(req.Name, s); err != nil {
+		writeAddError(w, err)
+		return
+	}
+	WriteJSON(w, http.StatusCreated, s.Stats())
+}
+
+// write the error of adding a sketch: beyond the memory (507), or the name is taken (409)
+func writeAddError(w http.ResponseWriter, err error) {
+	if errors.Is(err, errNoSpace) {
+		writeError(w, http.StatusInsufficientStorage, err)
+	} else {
+		writeError(w, http.StatusConflict, err)
+	}
+}
+
+func (api *API) list(w http.ResponseWriter, r *http.Request) {
+	api.mu.RLock()
+	names := make([]string, 0, len(api.sketches))
+	for name := range api.sketches {
+		names = append(names, name)
+	}
+	api.mu.RUnlock()
+	sort.Strings(names)
+	stats := make(map[string]Stats, len(names))
+	for _, name := range names {
+		if s := api.Get(name); s != nil { // unless deleted meanwhile
+			stats[name] = s.Stats()
+		}
+	}
+	WriteJSON(w, http.StatusOK, stats)
+}
+
+func (api *API) delete(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	api.mu.Lock()
+	s, found := api.sketches[name]
+	if found {
+		delete(api.sketches, name)
+		api.bytes -= s.bytes()
+	}
+	api.mu.Unlock()
+	if !found {
+		writeError(w, http.StatusNotFound, fmt.Errorf("no sketch %q", name))
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func serveUpdate(s *Sketch, w http.ResponseWriter, r *http.Request) {
+	var u updateRequest
+	if err := readJSON(w, r, &u); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	applyUpdates(s, w, []updateRequest{u})
+}
+
+func serveUpdates(s *Sketch, w http.ResponseWriter, r *http.Request) {
+	var requests []updateRequest
+	if err := readJSON(w, r, &requests); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	applyUpdates(s, w, requests)
+}
+
+// apply the updates once they are all valid, responding with the number of updated keys
+func applyUpdates(s *Sketch, w http.ResponseWriter, requests []updateRequest) {
+	updates := make([]Update, len(requests))
+	for i, u := range requests {
+		updates[i] = Update{Key: u.Key, Count: 1}
+		if u.Count != nil {
+			updates[i].Count = *u.Count
+		}
+		if updates[i].Count <= 0 {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("the count of %q must be positive", u.Key))
+			return
+		}
+	}
+	s.UpdateBatch(updates)
+	WriteJSON(w, http.StatusOK, map[string]int{"updated": len(updates)})
+}
+
+func serveMerge(s *Sketch, w http.ResponseWriter, r *http.Request) {
+	data, err := io.ReadAll(http.MaxBytesReader(w, r.Body, s.maxSnapshot()))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	other := &sketch.CMS{}
+	unmarshal := other.UnmarshalBinary
+	if r.Header.Get("Content-Type") == protobufType {
+		unmarshal = other.UnmarshalProto
+	}
+	if err := unmarshal(data); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if err := s.Merge(other); err != nil {
+		writeError(w, http.StatusConflict, err)
+		return
+	}
+	WriteJSON(w, http.StatusOK, s.Stats())
+}
+
+// read a JSON body into v, rejecting unknown fields
+func readJSON(w http.ResponseWriter, r *http.Request, v any) error {
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxJSONBody))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil {
+		return fmt.Errorf("bad request body: %w", err)
+	}
+	return nil
+}
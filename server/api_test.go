@@ -0,0 +1,263 @@
+/***************************************************
+* Author: Diana Cohen (sch.diana@gmail.com)
+* **************************************************
+* test that the requests are bounded: a sketch is created only within MaxCounters (a 400 otherwise, before
+* any counter is allocated) and within the memory of the API (a 507 otherwise), and a merged snapshot is read
+* only up to the largest snapshot of its sketch
+* test the life of a sketch: updates (an omitted count is 1, a count of 0 is rejected) and their estimates,
+* the heavy hitters, a snapshot downloaded and merged into another sketch, the deletion, and the unknown sketches
+ */
+
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/DianaCohenCS/measure-traces/sketch"
+)
+
+func request(api *API, method, path, content_type string, body []byte) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, path, bytes.NewReader(body))
+	if content_type != "" {
+		r.Header.Set("Content-Type", content_type)
+	}
+	w := httptest.NewRecorder()
+	api.ServeHTTP(w, r)
+	return w
+}
+
+func TestCreate(t *testing.T) {
+	api := NewAPI(Defaults{Epsilon: 0.01, Delta: 0.01}, 0)
+	tests := []struct {
+		body   string
+		status int
+	}{
+		{`{"name": "defaults"}`, http.StatusCreated},
+		{`{"name": "wider", "epsilon": 1e-5}`, http.StatusCreated},
+		{`{"name": "defaults"}`, http.StatusConflict},
+		{`{"name": "wide", "epsilon": 1e-9}`, http.StatusBadRequest},
+		{`{"name": "deep", "epsilon": 1e-6, "delta": 1e-300}`, http.StatusBadRequest},
+		{`{"name": "beyond a width of 32 bits", "epsilon": 1e-300}`, http.StatusBadRequest},
+		{`{"name": "no epsilon", "epsilon": 0}`, http.StatusBadRequest},
+		{`{"epsilon": 0.1}`, http.StatusBadRequest},
+		{`{"name": "unknown", "bits": 8}`, http.StatusBadRequest},
+		{`{"name": "a body beyond the largest JSON", "pad": "` + strings.Repeat("x", maxJSONBody) + `"}`, http.StatusBadRequest},
+	}
+	for _, test := range tests {
+		w := request(api, "POST", "/sketches", "", []byte(test.body))
+		if w.Code != test.status {
+			t.Errorf("%.60s: status %d (%s), expected %d", test.body, w.Code, strings.TrimSpace(w.Body.String()), test.status)
+		}
+	}
+}
+
+func TestMergeBody(t *testing.T) {
+	api := NewAPI(Defaults{Epsilon: 0.01, Delta: 0.01}, 0)
+	if w := request(api, "POST", "/sketches", "", []byte(`{"name": "s", "seed": 7}`)); w.Code != http.StatusCreated {
+		t.Fatalf("creating: status %d", w.Code)
+	}
+	d, width, _ := sketch.Dimensions(0.01, 0.01)
+	other, _ := sketch.New(d, width)
+	other.SetSeed(7)
+	other.Update("flow1", 1)
+	// the counters of a proto are at their longest varints
+	full, _ := sketch.New(d, width)
+	full.SetSeed(7)
+	for k := 0; k < 10000; k++ {
+		full.Update(string(rune(k)), 1<<31)
+	}
+	binary, _ := other.MarshalBinary()
+	proto, _ := full.MarshalProto()
+	tests := []struct {
+		name         string
+		content_type string
+		body         []byte
+		status       int
+	}{
+		{"binary", "", binary, http.StatusOK},
+		{"protobuf", protobufType, proto, http.StatusOK},
+		{"protobuf of an unknown field", protobufType, append(bytes.Clone(proto), 0x52, 3, 1, 2, 3), http.StatusOK},
+		{"beyond the largest snapshot", "", make([]byte, api.Get("s").maxSnapshot()+1), http.StatusBadRequest},
+	}
+	for _, test := range tests {
+		w := request(api, "POST", "/sketches/s/merge", test.content_type, test.body)
+		if w.Code != test.status {
+			t.Errorf("%s: status %d (%s), expected %d", test.name, w.Code, strings.TrimSpace(w.Body.String()), test.status)
+		}
+	}
+}
+
+// decode the JSON response of a request into v
+func decodeJSON(t *testing.T, w *httptest.ResponseRecorder, v any) {
+	t.Helper()
+	if err := json.Unmarshal(w.Body.Bytes(), v); err != nil {
+		t.Fatalf("decoding %q: %v", w.Body.String(), err)
+	}
+}
+
+// the estimate of a key, by the API
+func estimateOf(t *testing.T, api *API, name, key string) int {
+	t.Helper()
+	w := request(api, "GET", "/sketches/"+name+"/estimate?key="+key, "", nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("the estimate of %s in %s: status %d", key, name, w.Code)
+	}
+	var resp estimateResponse
+	decodeJSON(t, w, &resp)
+	return resp.Estimate
+}
+
+func TestUpdateEstimate(t *testing.T) {
+	api := NewAPI(Defaults{Epsilon: 0.01, Delta: 0.01, Top: 2}, 0)
+	if w := request(api, "POST", "/sketches", "", []byte(`{"name": "s", "seed": 7}`)); w.Code != http.StatusCreated {
+		t.Fatalf("creating: status %d", w.Code)
+	}
+	tests := []struct {
+		path    string
+		body    string
+		status  int
+		updated int
+	}{
+		{"update", `{"key": "a"}`, http.StatusOK, 1}, // a count of 1
+		{"update", `{"key": "a", "count": 5}`, http.StatusOK, 1},
+		{"update", `{"key": "a", "count": 0}`, http.StatusBadRequest, 0},
+		{"update", `{"key": "a", "count": -2}`, http.StatusBadRequest, 0},
+		{"update", `{"key": "a", "weight": 2}`, http.StatusBadRequest, 0},
+		{"updates", `[{"key": "b", "count": 3}, {"key": "c"}, {"key": "b"}]`, http.StatusOK, 3},
+		{"updates", `[{"key": "d", "count": 7}, {"key": "a", "count": 0}]`, http.StatusBadRequest, 0}, // none applied
+		{"updates", `[]`, http.StatusOK, 0},
+	}
+	for _, test := range tests {
+		w := request(api, "POST", "/sketches/s/"+test.path, "", []byte(test.body))
+		if w.Code != test.status {
+			t.Errorf("%s %s: status %d (%s), expected %d", test.path, test.body, w.Code, strings.TrimSpace(w.Body.String()), test.status)
+			continue
+		}
+		if w.Code == http.StatusOK {
+			var resp map[string]int
+			decodeJSON(t, w, &resp)
+			if resp["updated"] != test.updated {
+				t.Errorf("%s %s: updated %d, expected %d", test.path, test.body, resp["updated"], test.updated)
+			}
+		}
+	}
+	// the keys are far fewer than the width, so the estimates are exact
+	for key, count := range map[string]int{"a": 6, "b": 4, "c": 1, "d": 0} {
+		if estimate := estimateOf(t, api, "s", key); estimate != count {
+			t.Errorf("the estimate of %s is %d, expected %d", key, estimate, count)
+		}
+	}
+	if w := request(api, "GET", "/sketches/s/estimate", "", nil); w.Code != http.StatusBadRequest {
+		t.Errorf("an estimate of no key: status %d", w.Code)
+	}
+
+	w := request(api, "GET", "/sketches/s/topk", "", nil)
+	var top topResponse
+	decodeJSON(t, w, &top)
+	want := []heavyHitter{{"a", 6}, {"b", 4}}
+	if w.Code != http.StatusOK || len(top.HeavyHitters) != 2 || top.HeavyHitters[0] != want[0] || top.HeavyHitters[1] != want[1] {
+		t.Errorf("top-k: status %d, %+v, expected %+v", w.Code, top.HeavyHitters, want)
+	}
+	decodeJSON(t, request(api, "GET", "/sketches/s/topk?k=1", "", nil), &top)
+	if len(top.HeavyHitters) != 1 || top.HeavyHitters[0] != want[0] {
+		t.Errorf("top-1: %+v", top.HeavyHitters)
+	}
+	if w := request(api, "GET", "/sketches/s/topk?k=0", "", nil); w.Code != http.StatusBadRequest {
+		t.Errorf("top-0: status %d", w.Code)
+	}
+}
+
+func TestSnapshotDelete(t *testing.T) {
+	api := NewAPI(Defaults{Epsilon: 0.01, Delta: 0.01}, 0)
+	for _, body := range []string{`{"name": "s", "seed": 7}`, `{"name": "copy", "seed": 7}`, `{"name": "proto", "seed": 7}`} {
+		if w := request(api, "POST", "/sketches", "", []byte(body)); w.Code != http.StatusCreated {
+			t.Fatalf("creating %s: status %d", body, w.Code)
+		}
+	}
+	request(api, "POST", "/sketches/s/updates", "", []byte(`[{"key": "a", "count": 6}, {"key": "b", "count": 4}]`))
+
+	// download the snapshot (binary and protobuf), and merge it into an empty sketch
+	for name, query := range map[string]string{"copy": "", "proto": "?format=proto"} {
+		w := request(api, "GET", "/sketches/s/snapshot"+query, "", nil)
+		if w.Code != http.StatusOK {
+			t.Fatalf("snapshot%s: status %d", query, w.Code)
+		}
+		content_type := w.Header().Get("Content-Type")
+		cms := &sketch.CMS{}
+		unmarshal := cms.UnmarshalBinary
+		if content_type == protobufType {
+			unmarshal = cms.UnmarshalProto
+		}
+		if err := unmarshal(w.Body.Bytes()); err != nil || cms.Seed() != 7 || cms.Estimate("a") != 6 || cms.Total() != 10 {
+			t.Errorf("snapshot%s: error %v, seed %d, total %d", query, err, cms.Seed(), cms.Total())
+		}
+		merged := request(api, "POST", "/sketches/"+name+"/merge", content_type, w.Body.Bytes())
+		if merged.Code != http.StatusOK {
+			t.Fatalf("merging into %s: status %d (%s)", name, merged.Code, merged.Body.String())
+		}
+		for _, key := range []string{"a", "b", "c"} {
+			if estimateOf(t, api, name, key) != estimateOf(t, api, "s", key) {
+				t.Errorf("%s: the estimate of %s differs from the snapshotted sketch", name, key)
+			}
+		}
+	}
+
+	if w := request(api, "DELETE", "/sketches/s", "", nil); w.Code != http.StatusNoContent {
+		t.Errorf("delete: status %d", w.Code)
+	}
+	var list map[string]Stats
+	decodeJSON(t, request(api, "GET", "/sketches", "", nil), &list)
+	if len(list) != 2 || list["copy"].Total != 10 {
+		t.Errorf("the sketches after delete: %v", list)
+	}
+
+	// an unknown (or deleted) sketch
+	for _, r := range []struct{ method, path, body string }{
+		{"DELETE", "/sketches/s", ""},
+		{"POST", "/sketches/s/update", `{"key": "a"}`},
+		{"POST", "/sketches/s/updates", `[{"key": "a"}]`},
+		{"GET", "/sketches/s/estimate?key=a", ""},
+		{"GET", "/sketches/s/topk", ""},
+		{"POST", "/sketches/s/merge", ""},
+		{"GET", "/sketches/s/snapshot", ""},
+		{"GET", "/sketches/none/stats", ""},
+	} {
+		if w := request(api, r.method, r.path, "", []byte(r.body)); w.Code != http.StatusNotFound {
+			t.Errorf("%s %s: status %d, expected 404", r.method, r.path, w.Code)
+		}
+	}
+}
+
+func TestMemory(t *testing.T) {
+	d, width, _ := sketch.Dimensions(0.01, 0.01)
+	bytes := int64(d*width) * sketch.DefaultCounterBits / 8
+	api := NewAPI(Defaults{Epsilon: 0.01, Delta: 0.01}, 2*bytes+bytes/2) // room for 2 sketches
+	tests := []struct {
+		method, path, body string
+		status             int
+	}{
+		{"POST", "/sketches", `{"name": "a"}`, http.StatusCreated},
+		{"POST", "/sketches", `{"name": "b"}`, http.StatusCreated},
+		{"POST", "/sketches", `{"name": "c"}`, http.StatusInsufficientStorage},
+		{"POST", "/sketches", `{"name": "small", "epsilon": 0.1}`, http.StatusCreated}, // fits in the rest
+		{"POST", "/sketches", `{"name": "a"}`, http.StatusConflict},
+		{"DELETE", "/sketches/b", "", http.StatusNoContent},
+		{"POST", "/sketches", `{"name": "c"}`, http.StatusCreated}, // in the memory of the deleted one
+		{"POST", "/sketches", `{"name": "d"}`, http.StatusInsufficientStorage},
+	}
+	for _, test := range tests {
+		if w := request(api, test.method, test.path, "", []byte(test.body)); w.Code != test.status {
+			t.Errorf("%s %s %s: status %d (%s), expected %d", test.method, test.path, test.body, w.Code, strings.TrimSpace(w.Body.String()), test.status)
+		}
+	}
+	cms, _ := sketch.New(d, width)
+	sk, _ := NewSketch(cms, 0)
+	if err := api.Add("e", sk); err == nil {
+		t.Error("added a sketch beyond the memory")
+	}
+}
@@ -0,0 +1,114 @@
+/***************************************************
+* Author: Diana Cohen (sch.diana@gmail.com)
+* **************************************************
+* the HTTP queries of a single sketch, JSON responses (errors as {"error": "..."}):
+* GET /estimate?key=[key]  - the point estimate of a key, along with the bound of its error
+* GET /topk?k=[n]          - the heavy hitters, by descending estimate (all the tracked ones by default)
+* GET /stats               - the dimensions, seed, total count, memory and activity of the sketch
+* GET /snapshot            - the binary snapshot of the sketch (application/octet-stream), or its protobuf
+*                            encoding (application/x-protobuf, CMSSnapshot of proto/sketch.proto) given
+*                            ?format=proto or "Accept: application/x-protobuf"
+ */
+
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+)
+
+// NewHandler serves the queries of a single sketch
+func NewHandler(s *Sketch) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /estimate", func(w http.ResponseWriter, r *http.Request) { serveEstimate(s, w, r) })
+	mux.HandleFunc("GET /topk", func(w http.ResponseWriter, r *http.Request) { serveTop(s, w, r) })
+	mux.HandleFunc("GET /stats", func(w http.ResponseWriter, r *http.Request) { WriteJSON(w, http.StatusOK, s.Stats()) })
+	mux.HandleFunc("GET /snapshot", func(w http.ResponseWriter, r *http.Request) { serveSnapshot(s, w, r) })
+	return mux
+}
+
+// estimateResponse is the point estimate of a key
+type estimateResponse struct {
+	Key        string  `json:"key"`
+	Estimate   int     `json:"estimate"`
+	ErrorBound float64 `json:"error_bound"`
+}
+
+// heavyHitter is a key of the top-k, along with its estimate
+type heavyHitter struct {
+	Key      string `json:"key"`
+	Estimate int    `json:"estimate"`
+}
+
+// topResponse lists the heavy hitters
+type topResponse struct {
+	HeavyHitters []heavyHitter `json:"heavy_hitters"`
+	ErrorBound   float64       `json:"error_bound"`
+}
+
+func serveEstimate(s *Sketch, w http.ResponseWriter, r *http.Request) {
+	if !r.URL.Query().Has("key") {
+		writeError(w, http.StatusBadRequest, errors.New("the key is missing"))
+		return
+	}
+	key := r.URL.Query().Get("key")
+	estimate, bound := s.Estimate(key)
+	WriteJSON(w, http.StatusOK, estimateResponse{key, estimate, bound})
+}
+
+func serveTop(s *Sketch, w http.ResponseWriter, r *http.Request) {
+	n := 0
+	if value := r.URL.Query().Get("k"); value != "" {
+		var err error
+		if n, err = strconv.Atoi(value); err != nil || n <= 0 {
+			writeError(w, http.StatusBadRequest, errors.New("k must be a positive integer"))
+			return
+		}
+	}
+	top, bound := s.Top(n)
+	if top == nil {
+		writeError(w, http.StatusNotFound, errors.New("the heavy hitters are not tracked"))
+		return
+	}
+	resp := topResponse{HeavyHitters: make([]heavyHitter, len(top)), ErrorBound: bound}
+	for i, hh := range top {
+		resp.HeavyHitters[i] = heavyHitter{hh.Key, hh.Count}
+	}
+	WriteJSON(w, http.StatusOK, resp)
+}
+
+// the content type of the protobuf encoding
+const protobufType = "application/x-protobuf"
+
+func serveSnapshot(s *Sketch, w http.ResponseWriter, r *http.Request) {
+	content_type := "application/octet-stream"
+	snapshot := s.Snapshot
+	if r.URL.Query().Get("format") == "proto" || r.Header.Get("Accept") == protobufType {
+		content_type = protobufType
+		snapshot = s.SnapshotProto
+	}
+	data, err := snapshot()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	w.Header().Set("Content-Type", content_type)
+	w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+	w.Write(data)
+}
+
+// WriteJSON writes a JSON response of a given status
+func WriteJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(v)
+}
+
+// writeError writes an error as a JSON response of a given status
+func writeError(w http.ResponseWriter, status int, err error) {
+	WriteJSON(w, status, map[string]string{"error": err.Error()})
+}
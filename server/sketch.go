@@ -0,0 +1,186 @@
+/***************************************************
+* Author: Diana Cohen (sch.diana@gmail.com)
+* **************************************************
+* serve the sketches to other processes, over HTTP with JSON responses:
+* a Sketch is a CMS along with its heavy hitters (top-k), safe for concurrent use,
+* updated by keys (single or batched) or by merging other sketches of the same dimensions and seed
+* (e.g. the deltas of batches), queried by point estimates and heavy hitters
+* the error of an estimate is one-sided: it overestimates by at most epsilon*N with probability 1-delta,
+* where N is the total count of the sketch, the responses carry this bound
+ */
+
+package server
+
+import (
+	"math"
+	"sync"
+	"time"
+
+	"github.com/DianaCohenCS/measure-traces/sketch"
+)
+
+// Sketch is a CMS along with its heavy hitters, safe for concurrent use
+type Sketch struct {
+	mu      sync.RWMutex
+	cms     *sketch.CMS
+	top     *sketch.TopK // nil unless the heavy hitters are tracked
+	updates uint64       // the keys updated
+	merges  uint64       // the sketches merged
+	created time.Time
+	updated time.Time
+}
+
+// Update is the count of a key
+type Update struct {
+	Key   string `json:"key"`
+	Count int    `json:"count"`
+}
+
+// NewSketch serves a given CMS, tracking its top k keys (0 is off)
+func NewSketch(cms *sketch.CMS, k int) (*Sketch, error) {
+	s := &Sketch{cms: cms, created: time.Now()}
+	if k > 0 {
+		top, err := sketch.NewTopK(k)
+		if err != nil {
+			return nil, err
+		}
+		s.top = top
+	}
+	return s, nil
+}
+
+// Update the count of a key
+func (s *Sketch) Update(key string, cnt int) {
+	s.UpdateBatch([]Update{{key, cnt}})
+}
+
+// UpdateBatch updates the counts of several keys at once
+func (s *Sketch) UpdateBatch(updates []Update) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, u := range updates {
+		s.cms.Update(u.Key, u.Count)
+		if s.top != nil {
+			s.top.Offer(u.Key, s.cms.Estimate(u.Key))
+		}
+	}
+	s.updates += uint64(len(updates))
+	s.updated = time.Now()
+}
+
+// Merge another sketch, of the same dimensions, counter width and seed
+func (s *Sketch) Merge(other *sketch.CMS) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.cms.Merge(other); err != nil {
+		return err
+	}
+	if s.top != nil {
+		s.top.Refresh(s.cms.Estimate)
+	}
+	s.merges++
+	s.updated = time.Now()
+	return nil
+}
+
+// Estimate the count of a key, along with the bound of its error (epsilon*N)
+func (s *Sketch) Estimate(key string) (estimate int, bound float64) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.cms.Estimate(key), s.bound()
+}
+
+// Top returns the n heavy hitters (all of the tracked ones if n <= 0), along with the bound of their error,
+// nil if the heavy hitters are not tracked
+func (s *Sketch) Top(n int) ([]sketch.HeavyHitter, float64) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.top == nil {
+		return nil, s.bound()
+	}
+	return s.top.List(n), s.bound()
+}
+
+// Snapshot encodes the sketch, see sketch.CMS.MarshalBinary
+func (s *Sketch) Snapshot() ([]byte, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.cms.MarshalBinary()
+}
+
+// SnapshotProto encodes the sketch as protobuf, see sketch.CMS.MarshalProto
+func (s *Sketch) SnapshotProto() ([]byte, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.cms.MarshalProto()
+}
+
+// the largest snapshot of the sketch that is merged: a protobuf of its dimensions, whose counters take up to
+// a varint of their width each (7 bits per byte), along with its other fields; a binary snapshot is never longer
+func (s *Sketch) maxSnapshot() int64 {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return 64 + int64(s.cms.Depth())*int64(s.cms.Width())*int64((s.cms.CounterBits()+6)/7)
+}
+
+// the bytes of the counters, the dimensions of a sketch are fixed
+func (s *Sketch) bytes() int64 {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return int64(s.cms.Bytes())
+}
+
+// Stats describes a sketch
+type Stats struct {
+	Depth       int        `json:"depth"`
+	Width       int        `json:"width"`
+	CounterBits int        `json:"counter_bits"`
+	Bytes       int        `json:"bytes"`
+	Epsilon     float64    `json:"epsilon"` // e/w
+	Delta       float64    `json:"delta"`   // exp(-d)
+	Seed        uint64     `json:"seed"`
+	Total       uint64     `json:"total"` // N
+	ErrorBound  float64    `json:"error_bound"`
+	Distinct    float64    `json:"distinct"` // estimated by linear counting
+	Saturations uint64     `json:"saturations"`
+	TopK        int        `json:"top_k"`
+	Updates     uint64     `json:"updates"`
+	Merges      uint64     `json:"merges"`
+	Created     time.Time  `json:"created"`
+	Updated     *time.Time `json:"updated,omitempty"`
+}
+
+// Stats describes the sketch
+func (s *Sketch) Stats() Stats {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	stats := Stats{
+		Depth:       s.cms.Depth(),
+		Width:       s.cms.Width(),
+		CounterBits: s.cms.CounterBits(),
+		Bytes:       s.cms.Bytes(),
+		Epsilon:     math.E / float64(s.cms.Width()),
+		Delta:       math.Exp(-float64(s.cms.Depth())),
+		Seed:        s.cms.Seed(),
+		Total:       s.cms.Total(),
+		ErrorBound:  s.bound(),
+		Distinct:    s.cms.Distinct(),
+		Saturations: s.cms.Saturations(),
+		Updates:     s.updates,
+		Merges:      s.merges,
+		Created:     s.created,
+	}
+	if !s.updated.IsZero() {
+		updated := s.updated
+		stats.Updated = &updated
+	}
+	if s.top != nil {
+		stats.TopK = s.top.K()
+	}
+	return stats
+}
+
+// the bound of the error of an estimate, epsilon*N, given the read lock
+func (s *Sketch) bound() float64 {
+	return math.E / float64(s.cms.Width()) * float64(s.cms.Total())
+}
@@ -0,0 +1,355 @@
+/***************************************************
+* Author: Diana Cohen (sch.diana@gmail.com)
+* **************************************************
+* implement the Count-Min Sketch in go:
+* epsilon, delta - input parameters for CMS
+* d - number of rows in CMS - ceil(ln(1/delta)), for each row there is a hash function
+* w - number of counters per each row - ceil(e/epsilon)
+
+* the counters are a single contiguous array of d rows by w columns, of a fixed width (16, 32 or 64 bits),
+* a counter saturates at its maximal value, and does not go below zero, the saturations are counted
+* the memory is either derived from (epsilon, delta), or given as a budget in bytes, deriving w from it:
+* w = floor(budget / (d * bytes per counter)), hence epsilon = e/w
+* a key is hashed once, the d hash functions are derived by double hashing (Kirsch-Mitzenmacher):
+* g_i(x) = h1(x) + i*h2(x) mod w, where h1 is the 64-bit hash of x, and h2 is a second mixing of h1
+ */
+
+package sketch
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/rand/v2"
+)
+
+// DefaultCounterBits is the counter width of a CMS created by New
+const DefaultCounterBits = 32
+
+// Count-Min Sketch struct.
+type CMS struct {
+	d    int
+	w    int
+	bits int // counter width
+	// the counters, row i is count[i*w : (i+1)*w], only the slice of the counter width is allocated
+	count16 []uint16
+	count32 []uint32
+	count64 []uint64
+	seed    uint64
+	// the number of times a counter reached its maximal value, and was held there
+	saturations uint64
+}
+
+// counter is a fixed-width CMS counter
+type counter interface {
+	~uint16 | ~uint32 | ~uint64
+}
+
+// New is a constructor that creates a new Count-Min Sketch with d X w matrix of counters,
+// of the default width
+func New(d, w int) (cms *CMS, err error) {
+	return NewWithWidth(d, w, DefaultCounterBits)
+}
+
+// NewWithWidth creates a new Count-Min Sketch with d X w matrix of counters of a given width: 16, 32 or 64 bits
+func NewWithWidth(d, w, bits int) (cms *CMS, err error) {
+	if d <= 0 || w <= 0 {
+		return nil, errors.New("CMS: d and w must be greater than 0")
+	}
+
+	cms = &CMS{d: d, w: w, bits: bits, seed: rand.Uint64()}
+	switch bits {
+	case 16:
+		cms.count16 = make([]uint16, d*w)
+	case 32:
+		cms.count32 = make([]uint32, d*w)
+	case 64:
+		cms.count64 = make([]uint64, d*w)
+	default:
+		return nil, errors.New("CMS: counter width must be 16, 32 or 64 bits")
+	}
+	return cms, nil
+}
+
+// NewWithEstimates creates a new Count-Min Sketch with given error rate and confidence.
+// Accuracy guarantees will be made in terms of a pair of user specified parameters,
+// ε and δ, meaning that the error in answering a query is within a factor of ε with
+// probability at least (1-δ)
+func NewWithEstimates(epsilon, delta float64) (*CMS, error) {
+	d, w, err := Dimensions(epsilon, delta)
+	if err != nil {
+		return nil, err
+	}
+	// fmt.Printf("ε: %f, δ: %f -> d: %d, w: %d\n", epsilon, delta, d, w)
+
+	return New(d, w)
+}
+
+// NewWithMemory creates a new Count-Min Sketch of counters of a given width (16, 32 or 64 bits),
+// within a memory budget in bytes: d is derived from the confidence, and w from the budget
+func NewWithMemory(budget int, delta float64, bits int) (*CMS, error) {
+	if delta <= 0 || delta >= 1 {
+		return nil, errors.New("CMS: delta must be in range of (0, 1)")
+	}
+	if bits != 16 && bits != 32 && bits != 64 {
+		return nil, errors.New("CMS: counter width must be 16, 32 or 64 bits")
+	}
+	d := int(math.Ceil(math.Log(1.0 / delta)))
+	w := budget / (d * bits / 8)
+	if w <= 0 {
+		return nil, fmt.Errorf("CMS: a budget of %d bytes is less than a single column of %d counters of %d bits", budget, d, bits)
+	}
+	return NewWithWidth(d, w, bits)
+}
+
+// Update the frequency of a given key
+func (cms *CMS) Update(key string, cnt int) {
+	cms.update(Hash64(key, cms.seed), cnt)
+}
+
+// UpdateBytes updates the frequency of a key given as bytes, the same key as its string
+func (cms *CMS) UpdateBytes(key []byte, cnt int) {
+	cms.update(HashBytes(key, cms.seed), cnt)
+}
+
+// Estimate the frequency of a key. This is a point query.
+func (cms *CMS) Estimate(key string) int {
+	return cms.estimate(Hash64(key, cms.seed))
+}
+
+// EstimateBytes estimates the frequency of a key given as bytes
+func (cms *CMS) EstimateBytes(key []byte) int {
+	return cms.estimate(HashBytes(key, cms.seed))
+}
+
+func (cms *CMS) update(h uint64, cnt int) {
+	switch cms.bits {
+	case 16:
+		update(cms, cms.count16, h, cnt)
+	case 32:
+		update(cms, cms.count32, h, cnt)
+	default:
+		update(cms, cms.count64, h, cnt)
+	}
+}
+
+func (cms *CMS) estimate(h uint64) int {
+	switch cms.bits {
+	case 16:
+		return estimate(cms, cms.count16, h)
+	case 32:
+		return estimate(cms, cms.count32, h)
+	}
+	return estimate(cms, cms.count64, h)
+}
+
+func update[T counter](cms *CMS, count []T, h uint64, cnt int) {
+	h1, h2 := split(h)
+	for i := 0; i < cms.d; i++ {
+		k := i*cms.w + column(h1, h2, i, cms.w)
+		var saturated bool
+		count[k], saturated = add(count[k], cnt)
+		if saturated {
+			cms.saturations++
+		}
+	}
+}
+
+func estimate[T counter](cms *CMS, count []T, h uint64) int {
+	h1, h2 := split(h)
+	min := uint64(math.MaxUint64)
+	for i := 0; i < cms.d; i++ {
+		value := uint64(count[i*cms.w+column(h1, h2, i, cms.w)])
+		if value < min {
+			min = value
+		}
+	}
+	return int(min)
+}
+
+// add cnt to a counter, saturating at the maximal value (reported) and at zero
+func add[T counter](value T, cnt int) (T, bool) {
+	max_val := ^T(0)
+	if cnt >= 0 {
+		if uint64(cnt) > uint64(max_val-value) {
+			return max_val, true
+		}
+		return value + T(cnt), false
+	}
+	if uint64(-cnt) > uint64(value) {
+		return 0, false
+	}
+	return value - T(-cnt), false
+}
+
+// the two halves of the hash of a key: h1 is the hash itself, h2 is derived by a second mixing,
+// it is odd so that the d columns of a key are distinct whenever w is a power of two
+func split(h uint64) (h1, h2 uint64) {
+	return h, fmix64(h^prime2) | 1
+}
+
+// the column of a key within row i of w counters
+func column(h1, h2 uint64, i, w int) int {
+	return int((h1 + uint64(i)*h2) % uint64(w))
+}
+
+// Merge other CMS into a current CMS by adding the corresponding counts
+func (curr *CMS) Merge(other *CMS) error {
+	if curr.d != other.d || curr.w != other.w {
+		return errors.New("CMS: matrix dimensions must match")
+	}
+	if curr.bits != other.bits {
+		return errors.New("CMS: counter widths must match")
+	}
+	if curr.seed != other.seed {
+		return errors.New("CMS: hash functions must match, copy the seeds")
+	}
+
+	switch curr.bits {
+	case 16:
+		curr.saturations += merge(curr.count16, other.count16, 1)
+	case 32:
+		curr.saturations += merge(curr.count32, other.count32, 1)
+	default:
+		curr.saturations += merge(curr.count64, other.count64, 1)
+	}
+	curr.saturations += other.saturations
+	return nil
+}
+
+// subtract other CMS, of the same dimensions and width, from a current CMS
+func (curr *CMS) subtract(other *CMS) {
+	switch curr.bits {
+	case 16:
+		merge(curr.count16, other.count16, -1)
+	case 32:
+		merge(curr.count32, other.count32, -1)
+	default:
+		merge(curr.count64, other.count64, -1)
+	}
+}
+
+// add (sign 1) or subtract (sign -1) the other counters, returning the number of saturations
+func merge[T counter](curr, other []T, sign int) uint64 {
+	if sign < 0 {
+		for k, value := range other {
+			curr[k] -= min(curr[k], value)
+		}
+		return 0
+	}
+	saturations := uint64(0)
+	for k, value := range other {
+		if value > ^T(0)-curr[k] {
+			saturations++
+		}
+		curr[k] += min(value, ^T(0)-curr[k])
+	}
+	return saturations
+}
+
+// Copy seeds from other CMS
+func (curr *CMS) CopySeeds(other *CMS) {
+	curr.seed = other.seed
+}
+
+// Seed returns the seed of the hash functions
+func (cms *CMS) Seed() uint64 {
+	return cms.seed
+}
+
+// SetSeed sets the seed of the hash functions, before any update
+func (cms *CMS) SetSeed(seed uint64) {
+	cms.seed = seed
+}
+
+// Clear the counters, along with the saturations
+func (cms *CMS) Clear() {
+	clear(cms.count16)
+	clear(cms.count32)
+	clear(cms.count64)
+	cms.saturations = 0
+}
+
+// Dimensions calculates the matrix dimensions based on user params (epsilon, delta), without allocating the counters
+func Dimensions(epsilon, delta float64) (d int, w int, err error) {
+	if epsilon <= 0 || epsilon >= 1 {
+		return 0, 0, errors.New("CMS: epsilon must be in range of (0, 1)")
+	}
+	if delta <= 0 || delta >= 1 {
+		return 0, 0, errors.New("CMS: delta must be in range of (0, 1)")
+	}
+	// the width is a uint32 of a snapshot
+	if math.E/epsilon > math.MaxUint32 {
+		return 0, 0, fmt.Errorf("CMS: epsilon must be at least %g", math.E/math.MaxUint32)
+	}
+	// math.Log is actually a ln (natural log)
+	d = int(math.Ceil(math.Log(1.0 / delta)))
+	w = int(math.Ceil(math.E / epsilon))
+	return d, w, nil
+}
+
+// Depth returns the number of hashing functions
+func (cms *CMS) Depth() int {
+	return cms.d
+}
+
+// Width returns the size of hashing functions
+func (cms *CMS) Width() int {
+	return cms.w
+}
+
+// CounterBits returns the width of a counter
+func (cms *CMS) CounterBits() int {
+	return cms.bits
+}
+
+// Bytes returns the memory of the counters in bytes
+func (cms *CMS) Bytes() int {
+	return cms.d * cms.w * cms.bits / 8
+}
+
+// Saturations returns the number of times a counter reached its maximal value, and was held there,
+// including the saturations of the merged sketches
+func (cms *CMS) Saturations() uint64 {
+	return cms.saturations
+}
+
+// Total returns the sum of the counters of a row, i.e., the stream length (including the merged sketches),
+// exact unless a counter saturated
+func (cms *CMS) Total() uint64 {
+	total := uint64(0)
+	for j := 0; j < cms.w; j++ {
+		total += cms.at(j)
+	}
+	return total
+}
+
+// Distinct estimates the number of distinct keys by linear counting over the empty counters,
+// averaged over the rows, assuming only positive updates
+func (cms *CMS) Distinct() float64 {
+	sum := 0.0
+	for i := 0; i < cms.d; i++ {
+		zeros := 0
+		for j := 0; j < cms.w; j++ {
+			if cms.at(i*cms.w+j) == 0 {
+				zeros++
+			}
+		}
+		if zeros == 0 { // the row is saturated, linear counting is undefined
+			zeros = 1
+		}
+		sum += -float64(cms.w) * math.Log(float64(zeros)/float64(cms.w))
+	}
+	return sum / float64(cms.d)
+}
+
+// the value of the k-th counter
+func (cms *CMS) at(k int) uint64 {
+	switch cms.bits {
+	case 16:
+		return uint64(cms.count16[k])
+	case 32:
+		return uint64(cms.count32[k])
+	}
+	return cms.count64[k]
+}
@@ -0,0 +1,332 @@
+/***************************************************
+* Author: Diana Cohen (sch.diana@gmail.com)
+* **************************************************
+* test the CMS of every counter width: the bytes and strings of a key agree, an estimate is never below
+* the true count (and within epsilon*N but for delta of the keys), the counters saturate at their width,
+* and merging the sketches of parts of a stream is the same as updating a single sketch by the stream
+* benchmark the update throughput of the CMS over a synthetic stream of Zipf distributed keys:
+* CMS/bits=N   - the single-threaded CMS of 16, 32 and 64-bit counters
+* CMSBytes     - the single-threaded CMS, updated by keys given as bytes
+* CMSLegacy    - the former layout: a slice of int counters per row, and a maphash per row
+* CMSMutex     - a single CMS shared by the goroutines, guarded by a mutex
+* CMSMerge     - a local CMS per goroutine, merged into a single CMS at the end
+* Concurrent   - the concurrent CMS (atomic counters), shared by the goroutines
+* the shared variants are run by 1, 2, 4 and 8 goroutines, e.g., go test -bench . -benchmem ./sketch
+ */
+
+package sketch
+
+import (
+	"bytes"
+	"fmt"
+	"hash/maphash"
+	"math"
+	"math/rand"
+	"sync"
+	"testing"
+)
+
+// the params of the benchmarked sketches, and of the stream
+const (
+	benchEpsilon = 0.001
+	benchDelta   = 0.01
+	benchKeys    = 100000
+	benchSkew    = 1.1
+)
+
+var benchGoroutines = []int{1, 2, 4, 8}
+
+var counterBits = []int{16, 32, 64}
+
+// a stream of the Zipf keys along with their true counts
+func zipfStream(n int) ([]string, map[string]int) {
+	keys, _ := zipfKeys()
+	counts := map[string]int{}
+	for _, key := range keys[:n] {
+		counts[key]++
+	}
+	return keys[:n], counts
+}
+
+// a CMS of given dimensions and counter width, of a fixed seed
+func newTestCMS(t *testing.T, d, w, bits int) *CMS {
+	t.Helper()
+	cms, err := NewWithWidth(d, w, bits)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cms.SetSeed(7)
+	return cms
+}
+
+func TestUpdateBytes(t *testing.T) {
+	keys, _ := zipfStream(10000)
+	for _, bits := range counterBits {
+		by_string, by_bytes := newTestCMS(t, 4, 1000, bits), newTestCMS(t, 4, 1000, bits)
+		for i, key := range keys {
+			by_string.Update(key, 1+i%3)
+			by_bytes.UpdateBytes([]byte(key), 1+i%3)
+		}
+		a, _ := by_string.MarshalBinary()
+		b, _ := by_bytes.MarshalBinary()
+		if !bytes.Equal(a, b) {
+			t.Errorf("%d bits: the counters differ by strings and by bytes", bits)
+		}
+		for _, key := range keys[:100] {
+			if by_string.Estimate(key) != by_bytes.EstimateBytes([]byte(key)) {
+				t.Errorf("%d bits: the estimates of %q differ by strings and by bytes", bits, key)
+			}
+		}
+	}
+}
+
+func TestEstimateBounds(t *testing.T) {
+	const epsilon, delta = 0.01, 0.01
+	keys, counts := zipfStream(1 << 18)
+	d, w, _ := Dimensions(epsilon, delta)
+	for _, bits := range counterBits {
+		cms := newTestCMS(t, d, w, bits)
+		for _, key := range keys {
+			cms.Update(key, 1)
+		}
+		if cms.Total() != uint64(len(keys)) {
+			t.Errorf("%d bits: total %d, expected %d", bits, cms.Total(), len(keys))
+		}
+		bound := epsilon * float64(len(keys))
+		beyond := 0
+		for key, count := range counts {
+			estimate := cms.Estimate(key)
+			if estimate < count {
+				t.Fatalf("%d bits: the estimate of %q is %d, below its count %d", bits, key, estimate, count)
+			}
+			if float64(estimate-count) > bound {
+				beyond++
+			}
+		}
+		if fraction := float64(beyond) / float64(len(counts)); fraction > delta {
+			t.Errorf("%d bits: %.4f of the keys are beyond epsilon*N, expected at most %g", bits, fraction, delta)
+		}
+	}
+}
+
+func TestSaturation(t *testing.T) {
+	const d = 3
+	tests := []struct {
+		bits   int
+		counts []int
+		want   int // the estimate
+		sat    uint64
+	}{
+		{16, []int{math.MaxUint16}, math.MaxUint16, 0},
+		{16, []int{math.MaxUint16, 1}, math.MaxUint16, d},
+		{16, []int{70000}, math.MaxUint16, d},
+		{16, []int{math.MaxUint16 - 1, 1, 1, 1}, math.MaxUint16, 2 * d},
+		{32, []int{math.MaxUint32}, math.MaxUint32, 0},
+		{32, []int{math.MaxUint32, 5}, math.MaxUint32, d},
+		{32, []int{1 << 40}, math.MaxUint32, d},
+		{64, []int{math.MaxUint32, 5}, math.MaxUint32 + 5, 0},
+		{64, []int{math.MaxInt64}, math.MaxInt64, 0},
+		{16, []int{10, -20}, 0, 0}, // a counter does not go below zero
+	}
+	for _, test := range tests {
+		t.Run(fmt.Sprintf("%d bits %v", test.bits, test.counts), func(t *testing.T) {
+			cms := newTestCMS(t, d, 16, test.bits)
+			for _, cnt := range test.counts {
+				cms.Update("flow1", cnt)
+			}
+			if got := cms.Estimate("flow1"); got != test.want {
+				t.Errorf("estimate %d, expected %d", got, test.want)
+			}
+			if cms.Saturations() != test.sat {
+				t.Errorf("%d saturations, expected %d", cms.Saturations(), test.sat)
+			}
+		})
+	}
+}
+
+func TestMerge(t *testing.T) {
+	keys, _ := zipfStream(30000)
+	for _, bits := range counterBits {
+		whole := newTestCMS(t, 4, 500, bits)
+		parts := []*CMS{newTestCMS(t, 4, 500, bits), newTestCMS(t, 4, 500, bits), newTestCMS(t, 4, 500, bits)}
+		for i, key := range keys {
+			whole.Update(key, 1)
+			parts[i%len(parts)].Update(key, 1)
+		}
+		merged := newTestCMS(t, 4, 500, bits)
+		for _, part := range parts {
+			if err := merged.Merge(part); err != nil {
+				t.Fatal(err)
+			}
+		}
+		a, _ := whole.MarshalBinary()
+		b, _ := merged.MarshalBinary()
+		if !bytes.Equal(a, b) {
+			t.Errorf("%d bits: the merged parts differ from the whole stream", bits)
+		}
+	}
+
+	// the merged counters saturate at their width
+	a, b := newTestCMS(t, 2, 8, 16), newTestCMS(t, 2, 8, 16)
+	a.Update("flow1", 40000)
+	b.Update("flow1", 40000)
+	a.Merge(b)
+	if a.Estimate("flow1") != math.MaxUint16 || a.Saturations() != 2 {
+		t.Errorf("merged to %d, %d saturations, expected %d, 2", a.Estimate("flow1"), a.Saturations(), math.MaxUint16)
+	}
+
+	mismatched := []struct {
+		name  string
+		other *CMS
+	}{
+		{"depth", newTestCMS(t, 3, 8, 16)},
+		{"width", newTestCMS(t, 2, 9, 16)},
+		{"counter bits", newTestCMS(t, 2, 8, 32)},
+		{"seed", func() *CMS { cms := newTestCMS(t, 2, 8, 16); cms.SetSeed(8); return cms }()},
+	}
+	for _, test := range mismatched {
+		if a.Merge(test.other) == nil {
+			t.Errorf("merged a CMS of another %s", test.name)
+		}
+	}
+}
+
+// the stream: a million items (a power of 2) of Zipf distributed keys, as strings and as bytes
+var zipfKeys = sync.OnceValues(func() ([]string, [][]byte) {
+	rnd := rand.New(rand.NewSource(1))
+	zipf := rand.NewZipf(rnd, benchSkew, 1, benchKeys-1)
+	keys := make([]string, 1<<20)
+	bkeys := make([][]byte, len(keys))
+	for i := range keys {
+		keys[i] = fmt.Sprintf("flow%d", zipf.Uint64())
+		bkeys[i] = []byte(keys[i])
+	}
+	return keys, bkeys
+})
+
+func BenchmarkCMS(b *testing.B) {
+	keys, _ := zipfKeys()
+	d, w, _ := Dimensions(benchEpsilon, benchDelta)
+	for _, bits := range counterBits {
+		b.Run(fmt.Sprintf("bits=%d", bits), func(b *testing.B) {
+			cms, _ := NewWithWidth(d, w, bits)
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				cms.Update(keys[i&(len(keys)-1)], 1)
+			}
+		})
+	}
+}
+
+func BenchmarkCMSBytes(b *testing.B) {
+	_, bkeys := zipfKeys()
+	cms, _ := NewWithEstimates(benchEpsilon, benchDelta)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		cms.UpdateBytes(bkeys[i&(len(bkeys)-1)], 1)
+	}
+}
+
+func BenchmarkCMSLegacy(b *testing.B) {
+	keys, _ := zipfKeys()
+	d, w, _ := Dimensions(benchEpsilon, benchDelta)
+	cms := newLegacyCMS(d, w)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		cms.update(keys[i&(len(keys)-1)], 1)
+	}
+}
+
+func BenchmarkCMSMutex(b *testing.B) {
+	keys, _ := zipfKeys()
+	for _, g := range benchGoroutines {
+		b.Run(fmt.Sprintf("goroutines=%d", g), func(b *testing.B) {
+			cms, _ := NewWithEstimates(benchEpsilon, benchDelta)
+			var mu sync.Mutex
+			b.ResetTimer()
+			parallel(b.N, g, func(_, from, to int) {
+				for i := from; i < to; i++ {
+					mu.Lock()
+					cms.Update(keys[i&(len(keys)-1)], 1)
+					mu.Unlock()
+				}
+			})
+		})
+	}
+}
+
+func BenchmarkCMSMerge(b *testing.B) {
+	keys, _ := zipfKeys()
+	for _, g := range benchGoroutines {
+		b.Run(fmt.Sprintf("goroutines=%d", g), func(b *testing.B) {
+			cms, _ := NewWithEstimates(benchEpsilon, benchDelta)
+			locals := make([]*CMS, g)
+			for k := range locals {
+				locals[k], _ = New(cms.Depth(), cms.Width())
+				locals[k].CopySeeds(cms)
+			}
+			b.ResetTimer()
+			parallel(b.N, g, func(k, from, to int) {
+				for i := from; i < to; i++ {
+					locals[k].Update(keys[i&(len(keys)-1)], 1)
+				}
+			})
+			for _, local := range locals {
+				cms.Merge(local)
+			}
+		})
+	}
+}
+
+func BenchmarkConcurrent(b *testing.B) {
+	keys, _ := zipfKeys()
+	for _, g := range benchGoroutines {
+		b.Run(fmt.Sprintf("goroutines=%d", g), func(b *testing.B) {
+			cms, _ := NewConcurrentWithEstimates(benchEpsilon, benchDelta)
+			b.ResetTimer()
+			parallel(b.N, g, func(_, from, to int) {
+				for i := from; i < to; i++ {
+					cms.Update(keys[i&(len(keys)-1)], 1)
+				}
+			})
+		})
+	}
+}
+
+// parallel splits the items [0, n) among g goroutines, and waits for them all
+func parallel(n, g int, work func(k, from, to int)) {
+	var wg sync.WaitGroup
+	for k := 0; k < g; k++ {
+		wg.Add(1)
+		go func(k int) {
+			defer wg.Done()
+			work(k, n*k/g, n*(k+1)/g)
+		}(k)
+	}
+	wg.Wait()
+}
+
+// legacyCMS is the former layout of the CMS, the baseline of the benchmark:
+// a slice of int counters per row, and a maphash of the key per row
+type legacyCMS struct {
+	count [][]int
+	seeds []maphash.Seed
+}
+
+func newLegacyCMS(d, w int) *legacyCMS {
+	cms := &legacyCMS{count: make([][]int, d), seeds: make([]maphash.Seed, d)}
+	for i := range cms.count {
+		cms.count[i] = make([]int, w)
+		cms.seeds[i] = maphash.MakeSeed()
+	}
+	return cms
+}
+
+func (cms *legacyCMS) update(key string, cnt int) {
+	for i := range cms.count {
+		h := maphash.Hash{}
+		h.SetSeed(cms.seeds[i])
+		h.WriteString(key)
+		cms.count[i][h.Sum64()%uint64(len(cms.count[i]))] += cnt
+	}
+}
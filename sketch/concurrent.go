@@ -0,0 +1,90 @@
+/***************************************************
+* Author: Diana Cohen (sch.diana@gmail.com)
+* **************************************************
+* a concurrency-safe Count-Min Sketch, fed by multiple goroutines:
+* the counters are a flat array of d rows by w columns, updated atomically,
+* so the goroutines share a single sketch with no locking (and no periodic merging),
+* the hash functions are those of the CMS, hence a snapshot is a regular CMS (of 64-bit counters)
+ */
+
+package sketch
+
+import (
+	"errors"
+	"math"
+	"math/rand/v2"
+	"sync/atomic"
+)
+
+// ConcurrentCMS is a Count-Min Sketch that is safe for concurrent use
+type ConcurrentCMS struct {
+	d     int
+	w     int
+	count []int64 // row i is count[i*w : (i+1)*w]
+	seed  uint64
+}
+
+// NewConcurrent creates a new concurrent Count-Min Sketch with d X w matrix of counters
+func NewConcurrent(d, w int) (*ConcurrentCMS, error) {
+	if d <= 0 || w <= 0 {
+		return nil, errors.New("CMS: d and w must be greater than 0")
+	}
+	return &ConcurrentCMS{d: d, w: w, count: make([]int64, d*w), seed: rand.Uint64()}, nil
+}
+
+// NewConcurrentWithEstimates creates a new concurrent Count-Min Sketch with given error rate and confidence
+func NewConcurrentWithEstimates(epsilon, delta float64) (*ConcurrentCMS, error) {
+	d, w, err := Dimensions(epsilon, delta)
+	if err != nil {
+		return nil, err
+	}
+	return NewConcurrent(d, w)
+}
+
+// Update the frequency of a given key, may be called by multiple goroutines
+func (cms *ConcurrentCMS) Update(key string, cnt int) {
+	h1, h2 := split(Hash64(key, cms.seed))
+	for i := 0; i < cms.d; i++ {
+		atomic.AddInt64(&cms.count[i*cms.w+column(h1, h2, i, cms.w)], int64(cnt))
+	}
+}
+
+// Estimate the frequency of a key, concurrently with the updates:
+// the estimate reflects the updates that completed before the query, at least
+func (cms *ConcurrentCMS) Estimate(key string) int {
+	h1, h2 := split(Hash64(key, cms.seed))
+	min := int64(math.MaxInt64)
+	for i := 0; i < cms.d; i++ {
+		if value := atomic.LoadInt64(&cms.count[i*cms.w+column(h1, h2, i, cms.w)]); value < min {
+			min = value
+		}
+	}
+	return int(min)
+}
+
+// Snapshot copies the counters into a regular CMS of the same hash functions
+func (cms *ConcurrentCMS) Snapshot() *CMS {
+	snapshot, _ := NewWithWidth(cms.d, cms.w, 64)
+	snapshot.seed = cms.seed
+	for k := range cms.count {
+		snapshot.count64[k] = uint64(max(atomic.LoadInt64(&cms.count[k]), 0))
+	}
+	return snapshot
+}
+
+// Clear the counters, the updates that run concurrently may be either kept or cleared
+func (cms *ConcurrentCMS) Clear() {
+	for k := range cms.count {
+		atomic.StoreInt64(&cms.count[k], 0)
+	}
+}
+
+// Depth returns the number of hashing functions
+func (cms *ConcurrentCMS) Depth() int {
+	return cms.d
+}
+
+// Width returns the size of hashing functions
+func (cms *ConcurrentCMS) Width() int {
+	return cms.w
+}
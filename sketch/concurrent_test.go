@@ -0,0 +1,64 @@
+/***************************************************
+* Author: Diana Cohen (sch.diana@gmail.com)
+* **************************************************
+* test the concurrent CMS: a stream split among goroutines that update a shared sketch leaves
+* the same counters as a CMS (of 64-bit counters, of the same seed) updated sequentially by the stream,
+* run under the race detector as well, e.g., go test -race -run Concurrent ./sketch
+ */
+
+package sketch
+
+import (
+	"bytes"
+	"sync"
+	"testing"
+)
+
+func TestConcurrentSnapshot(t *testing.T) {
+	const d, w = 4, 512
+	keys, counts := zipfStream(20000)
+	for _, goroutines := range []int{1, 2, 8} {
+		cms, err := NewConcurrent(d, w)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cms.seed = 7
+		var wg sync.WaitGroup
+		for g := 0; g < goroutines; g++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for i := g; i < len(keys); i += goroutines {
+					cms.Update(keys[i], i%3+1)
+					if i%1000 == 0 {
+						cms.Estimate(keys[i]) // queried concurrently with the updates
+					}
+				}
+			}()
+		}
+		wg.Wait()
+
+		sequential := newTestCMS(t, d, w, 64)
+		for i, key := range keys {
+			sequential.Update(key, i%3+1)
+		}
+		a, _ := cms.Snapshot().MarshalBinary()
+		b, _ := sequential.MarshalBinary()
+		if !bytes.Equal(a, b) {
+			t.Fatalf("%d goroutines: the snapshot differs from a sequential CMS of the stream", goroutines)
+		}
+		for key, count := range counts {
+			if cms.Estimate(key) != sequential.Estimate(key) || cms.Estimate(key) < count {
+				t.Fatalf("%d goroutines: the estimate of %s is %d, sequential %d, true count %d",
+					goroutines, key, cms.Estimate(key), sequential.Estimate(key), count)
+			}
+		}
+	}
+
+	cms, _ := NewConcurrent(d, w)
+	cms.Update("flow", 5)
+	cms.Clear()
+	if cms.Estimate("flow") != 0 {
+		t.Errorf("an estimate of %d after clear", cms.Estimate("flow"))
+	}
+}
@@ -0,0 +1,81 @@
+/***************************************************
+* Author: Diana Cohen (sch.diana@gmail.com)
+* **************************************************
+* implement the entropy sketch of Clifford and Cosma, estimating the Shannon entropy of the
+* flow frequencies: H = -sum (f/N) log(f/N), over the flows of a stream of length N
+* k projections of the frequency vector, y_j = sum f_i * r_ij, where r_ij follows a maximally skewed
+* stable distribution (alpha = 1, beta = -1), drawn from the hash of flow i (so no state is kept per flow),
+* then E[exp(y_j/N)] = exp(-H), hence H is estimated by -log(mean exp(y_j/N))
+* the sketch is linear: a flow may be added once along with its frequency, and sketches of the same
+* k and seed are mergeable, e.g. batches into a trace
+ */
+
+package sketch
+
+import (
+	"errors"
+	"math"
+)
+
+// Entropy is a sketch of the Shannon entropy of a stream
+type Entropy struct {
+	y    []float64 // the projections
+	n    float64   // the stream length
+	seed uint64
+}
+
+// NewEntropy creates an entropy sketch of k projections, the relative variance of exp(y_j/N) is 3,
+// hence the standard error is about sqrt(3/k) nats, i.e., 2.5/sqrt(k) bits
+func NewEntropy(k int, seed uint64) (*Entropy, error) {
+	if k <= 0 {
+		return nil, errors.New("entropy: the number of projections must be greater than 0")
+	}
+	return &Entropy{y: make([]float64, k), seed: seed}, nil
+}
+
+// Update the frequency of a given key
+func (e *Entropy) Update(key string, cnt int) {
+	h := Hash64(key, e.seed)
+	for j := range e.y {
+		e.y[j] += float64(cnt) * skewedStable(h, j)
+	}
+	e.n += float64(cnt)
+}
+
+// Estimate the entropy in bits
+func (e *Entropy) Estimate() float64 {
+	if e.n == 0 {
+		return 0
+	}
+	sum := 0.0
+	for _, y := range e.y {
+		sum += math.Exp(y / e.n)
+	}
+	return max(0, -math.Log(sum/float64(len(e.y)))/math.Ln2)
+}
+
+// Merge other sketch into the current one, both must have the same k and seed
+func (e *Entropy) Merge(other *Entropy) error {
+	if len(e.y) != len(other.y) {
+		return errors.New("entropy: the number of projections must match")
+	}
+	if e.seed != other.seed {
+		return errors.New("entropy: the seeds must match")
+	}
+	for j, y := range other.y {
+		e.y[j] += y
+	}
+	e.n += other.n
+	return nil
+}
+
+// the j-th variate of a key hashed to h, of the maximally skewed stable distribution (alpha = 1, beta = -1),
+// by the method of Chambers, Mallows and Stuck, given two uniform variates within (0, 1)
+func skewedStable(h uint64, j int) float64 {
+	x := fmix64(h + uint64(j+1)*prime2)
+	u1 := (float64(x>>32) + 0.5) / (1 << 32)
+	u2 := (float64(x&math.MaxUint32) + 0.5) / (1 << 32)
+	w1 := math.Pi * (u1 - 0.5)
+	w2 := -math.Log(u2)
+	return math.Tan(w1)*(math.Pi/2-w1) + math.Log(w2*math.Cos(w1)/(math.Pi/2-w1))
+}
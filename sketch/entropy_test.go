@@ -0,0 +1,97 @@
+/***************************************************
+* Author: Diana Cohen (sch.diana@gmail.com)
+* **************************************************
+* test the entropy sketch against the exact entropy (stats.Entropy) of a synthetic Zipf stream:
+* the estimates of several seeds are within the standard error of sqrt(3/k) nats, and the sketch
+* is linear - updating a flow once by its frequency, or merging the sketches of the parts of a stream,
+* estimates the same entropy as updating a single sketch by the items of the stream
+ */
+
+package sketch
+
+import (
+	"math"
+	"testing"
+
+	"github.com/DianaCohenCS/measure-traces/stats"
+)
+
+// the standard error (in bits) of an entropy sketch of k projections
+func entropyError(k int) float64 {
+	return math.Sqrt(3/float64(k)) / math.Ln2
+}
+
+func TestEntropyError(t *testing.T) {
+	const seeds = 10
+	_, counts := zipfStream(20000)
+	exact := stats.Entropy(func(yield func(uint64) bool) {
+		for _, count := range counts {
+			if !yield(uint64(count)) {
+				return
+			}
+		}
+	})
+	for _, k := range []int{64, 256} {
+		se := entropyError(k)
+		sum_sq := 0.0
+		for seed := uint64(1); seed <= seeds; seed++ {
+			e, err := NewEntropy(k, seed)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for key, count := range counts {
+				e.Update(key, count)
+			}
+			// the estimate is the log of a mean of heavy tailed variates, a single seed may be a few se off
+			diff := e.Estimate() - exact
+			if math.Abs(diff) > 4*se {
+				t.Errorf("k=%d, seed %d: estimate %.3f, exact %.3f, beyond 4 standard errors (%.3f)", k, seed, e.Estimate(), exact, se)
+			}
+			sum_sq += diff * diff
+		}
+		if rms := math.Sqrt(sum_sq / seeds); rms > 2*se {
+			t.Errorf("k=%d: the error of %d seeds is %.3f, beyond twice the standard error (%.3f)", k, seeds, rms, se)
+		}
+	}
+}
+
+func TestEntropyLinear(t *testing.T) {
+	keys, counts := zipfStream(20000)
+	items, _ := NewEntropy(64, 7)
+	parts := []*Entropy{}
+	for p := 0; p < 4; p++ {
+		part, _ := NewEntropy(64, 7)
+		for _, key := range keys[p*5000 : (p+1)*5000] {
+			items.Update(key, 1)
+			part.Update(key, 1)
+		}
+		parts = append(parts, part)
+	}
+	flows, _ := NewEntropy(64, 7)
+	for key, count := range counts {
+		flows.Update(key, count)
+	}
+	merged, _ := NewEntropy(64, 7)
+	for _, part := range parts {
+		if err := merged.Merge(part); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for name, e := range map[string]*Entropy{"flows": flows, "merged": merged} {
+		if math.Abs(e.Estimate()-items.Estimate()) > 1e-9 {
+			t.Errorf("the estimate of %s %v, of the items %v", name, e.Estimate(), items.Estimate())
+		}
+	}
+
+	other_k, _ := NewEntropy(32, 7)
+	other_seed, _ := NewEntropy(64, 8)
+	if merged.Merge(other_k) == nil || merged.Merge(other_seed) == nil {
+		t.Error("merged the sketches of a different k or seed")
+	}
+	if e, _ := NewEntropy(64, 7); e.Estimate() != 0 {
+		t.Errorf("an estimate of %v of an empty stream", e.Estimate())
+	}
+	if _, err := NewEntropy(0, 7); err == nil {
+		t.Error("created a sketch of no projections")
+	}
+}
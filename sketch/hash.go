@@ -0,0 +1,55 @@
+/***************************************************
+* Author: Diana Cohen (sch.diana@gmail.com)
+* **************************************************
+* a deterministic 64-bit hash of a key, given a seed:
+* the key is consumed 8 bytes at a time (multiply-rotate rounds, as in xxhash),
+* followed by the murmur3 finalizer to mix the bits, with no allocation for strings or bytes,
+* unlike hash/maphash the seed is a plain number, so sketches built by different processes merge
+ */
+
+package sketch
+
+import "math/bits"
+
+const (
+	prime1 = 0x9e3779b185ebca87
+	prime2 = 0xc2b2ae3d27d4eb4f
+	prime3 = 0x165667b19e3779f9
+)
+
+// Hash64 returns the 64-bit hash of a key for a given seed
+func Hash64(key string, seed uint64) uint64 {
+	return hash64(key, seed)
+}
+
+// HashBytes returns the 64-bit hash of a key given as bytes, the same as Hash64 of the string
+func HashBytes(key []byte, seed uint64) uint64 {
+	return hash64(key, seed)
+}
+
+func hash64[T string | []byte](key T, seed uint64) uint64 {
+	n := len(key)
+	h := seed + prime3 + uint64(n)*prime1
+	i := 0
+	for ; i+8 <= n; i += 8 {
+		k := uint64(key[i]) | uint64(key[i+1])<<8 | uint64(key[i+2])<<16 | uint64(key[i+3])<<24 |
+			uint64(key[i+4])<<32 | uint64(key[i+5])<<40 | uint64(key[i+6])<<48 | uint64(key[i+7])<<56
+		h ^= bits.RotateLeft64(k*prime2, 31) * prime1
+		h = bits.RotateLeft64(h, 27)*prime1 + prime3
+	}
+	for ; i < n; i++ {
+		h ^= uint64(key[i]) * prime3
+		h = bits.RotateLeft64(h, 11) * prime1
+	}
+	return fmix64(h)
+}
+
+// murmur3 finalizer, every input bit affects every output bit
+func fmix64(h uint64) uint64 {
+	h ^= h >> 33
+	h *= 0xff51afd7ed558ccd
+	h ^= h >> 33
+	h *= 0xc4ceb9fe1a85ec53
+	h ^= h >> 33
+	return h
+}
@@ -0,0 +1,115 @@
+/***************************************************
+* Author: Diana Cohen (sch.diana@gmail.com)
+* **************************************************
+* test the hash of a key and the columns derived from it (double hashing):
+* the hash is pinned by golden values, since sketches of other processes (and the protobuf
+* snapshots, HASH_FAMILY_DOUBLE_HASH64) merge only if it never changes, the strings and bytes agree,
+* a flipped bit of the key flips about half of the hash bits, and the columns spread the keys evenly
+ */
+
+package sketch
+
+import (
+	"fmt"
+	"math"
+	"math/bits"
+	"testing"
+)
+
+func TestHashGolden(t *testing.T) {
+	tests := []struct {
+		key  string
+		hash uint64
+	}{
+		{"", 0x4b3aac35aba45afe},
+		{"a", 0xa0fac93040d7f72d},
+		{"flow1", 0x6bb5e289026da6d9},
+		{"0123456789abcdef!", 0x84a064e452b68b1a}, // two whole blocks of 8 bytes and a tail
+	}
+	for _, test := range tests {
+		if h := Hash64(test.key, 7); h != test.hash {
+			t.Errorf("Hash64(%q, 7) = %#x, expected %#x", test.key, h, test.hash)
+		}
+	}
+}
+
+func TestHashBytes(t *testing.T) {
+	key := "0123456789abcdefghijklmnopqrstuvwxyz"
+	for n := 0; n <= len(key); n++ {
+		for _, seed := range []uint64{0, 7, math.MaxUint64} {
+			if Hash64(key[:n], seed) != HashBytes([]byte(key[:n]), seed) {
+				t.Errorf("the hashes of %q (seed %d) differ as a string and as bytes", key[:n], seed)
+			}
+		}
+	}
+	if Hash64("flow1", 1) == Hash64("flow1", 2) {
+		t.Error("the seed does not affect the hash")
+	}
+}
+
+// a single flipped bit of the key flips half of the hash bits on average
+func TestHashAvalanche(t *testing.T) {
+	for _, n := range []int{1, 5, 8, 13, 16} {
+		key := make([]byte, n)
+		flipped, trials := 0, 0
+		for trial := 0; trial < 200; trial++ {
+			for i := range key {
+				key[i] = byte(trial*31 + i*7)
+			}
+			h := HashBytes(key, 7)
+			for bit := 0; bit < 8*n; bit++ {
+				key[bit/8] ^= 1 << (bit % 8)
+				flipped += bits.OnesCount64(h ^ HashBytes(key, 7))
+				key[bit/8] ^= 1 << (bit % 8)
+				trials++
+			}
+		}
+		if mean := float64(flipped) / float64(trials); mean < 31 || mean > 33 {
+			t.Errorf("keys of %d bytes: a flipped bit flips %.2f bits of the hash, expected 32", n, mean)
+		}
+	}
+}
+
+// the columns of every row are uniform, by a chi-squared test of the keys of the traces ("flow%d")
+func TestColumnsUniform(t *testing.T) {
+	const d, keys = 5, 200000
+	for _, w := range []int{1000, 1024, 2719} {
+		counts := make([][]int, d)
+		for i := range counts {
+			counts[i] = make([]int, w)
+		}
+		for x := 0; x < keys; x++ {
+			h1, h2 := split(Hash64(fmt.Sprintf("flow%d", x), 7))
+			for i := 0; i < d; i++ {
+				counts[i][column(h1, h2, i, w)]++
+			}
+		}
+		expected := float64(keys) / float64(w)
+		df := float64(w - 1)
+		for i, row := range counts {
+			chi2 := 0.0
+			for _, c := range row {
+				chi2 += (float64(c) - expected) * (float64(c) - expected) / expected
+			}
+			// chi2 is about normal of mean df and variance 2*df, 6 sigmas are never exceeded by chance
+			if math.Abs(chi2-df) > 6*math.Sqrt(2*df) {
+				t.Errorf("w=%d, row %d: chi2 %.1f of %g degrees of freedom", w, i, chi2, df)
+			}
+		}
+	}
+}
+
+// h2 is odd, so the d columns of a key are distinct whenever w is a power of two (and d <= w)
+func TestColumnsDistinct(t *testing.T) {
+	const d, w = 8, 64
+	for x := 0; x < 10000; x++ {
+		h1, h2 := split(Hash64(fmt.Sprintf("flow%d", x), 7))
+		seen := map[int]bool{}
+		for i := 0; i < d; i++ {
+			seen[column(h1, h2, i, w)] = true
+		}
+		if len(seen) != d {
+			t.Fatalf("flow%d: %d distinct columns of %d rows", x, len(seen), d)
+		}
+	}
+}
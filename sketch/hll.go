@@ -0,0 +1,167 @@
+/***************************************************
+* Author: Diana Cohen (sch.diana@gmail.com)
+* **************************************************
+* implement the HyperLogLog in go, estimating the number of distinct items (flows):
+* p - precision, m = 2^p registers, standard error is about 1.04/sqrt(m)
+* following HLL++, a 64-bit hash is used (no large range correction is needed),
+* and small cardinalities are kept in a sparse representation of precision p' = 25,
+* converted to the dense registers once it takes more space than the registers themselves
+* the empirical bias correction of HLL++ is not implemented, linear counting is used instead
+* sketches of the same precision and seed are mergeable, e.g. batches into a trace
+ */
+
+package sketch
+
+import (
+	"errors"
+	"math"
+	"math/bits"
+)
+
+const sparsePrecision = 25 // p' of the sparse representation
+
+// the bytes of a sparse entry: the index (4 bytes) and the rank (1 byte) of a map entry, along with
+// the overhead of the buckets of the map (their top hashes, overflow pointers and free slots)
+const sparseEntryBytes = 8
+
+// HyperLogLog struct.
+type HLL struct {
+	p      uint8
+	seed   uint64
+	dense  []uint8          // m registers, nil while sparse
+	sparse map[uint32]uint8 // index (of precision p') -> rank
+}
+
+// NewHLL is a constructor that creates a new HyperLogLog with 2^p registers, in the sparse mode
+func NewHLL(p uint8, seed uint64) (*HLL, error) {
+	if p < 4 || p > 18 {
+		return nil, errors.New("HLL: precision must be in range of [4, 18]")
+	}
+	return &HLL{p: p, seed: seed, sparse: make(map[uint32]uint8)}, nil
+}
+
+// Add a key to the set
+func (hll *HLL) Add(key string) {
+	h := Hash64(key, hll.seed)
+	if hll.dense != nil {
+		idx, rank := denseIndex(h, hll.p)
+		hll.dense[idx] = max(hll.dense[idx], rank)
+		return
+	}
+	idx := uint32(h >> (64 - sparsePrecision))
+	rank := uint8(bits.LeadingZeros64(h<<sparsePrecision|1<<(sparsePrecision-1))) + 1
+	hll.sparse[idx] = max(hll.sparse[idx], rank)
+	if hll.sparseFull() {
+		hll.toDense()
+	}
+}
+
+// Estimate the number of distinct keys
+func (hll *HLL) Estimate() float64 {
+	if hll.dense == nil { // linear counting over the sparse registers
+		mp := float64(uint64(1) << sparsePrecision)
+		return mp * math.Log(mp/(mp-float64(len(hll.sparse))))
+	}
+	m := float64(hll.m())
+	sum := 0.0
+	zeros := 0
+	for _, rank := range hll.dense {
+		sum += 1.0 / float64(uint64(1)<<rank)
+		if rank == 0 {
+			zeros++
+		}
+	}
+	e := alpha(hll.m()) * m * m / sum
+	if e <= 2.5*m && zeros > 0 { // small range correction
+		return m * math.Log(m/float64(zeros))
+	}
+	return e
+}
+
+// Merge other HLL into a current HLL by taking the maximal rank per register
+func (curr *HLL) Merge(other *HLL) error {
+	if curr.p != other.p || curr.seed != other.seed {
+		return errors.New("HLL: precision and seed must match")
+	}
+	if curr.dense == nil && other.dense == nil {
+		for idx, rank := range other.sparse {
+			curr.sparse[idx] = max(curr.sparse[idx], rank)
+		}
+		if curr.sparseFull() {
+			curr.toDense()
+		}
+		return nil
+	}
+	if curr.dense == nil {
+		curr.toDense()
+	}
+	if other.dense == nil {
+		for idx, rank := range other.sparse {
+			i, r := sparseToDense(idx, rank, curr.p)
+			curr.dense[i] = max(curr.dense[i], r)
+		}
+		return nil
+	}
+	for i, rank := range other.dense {
+		curr.dense[i] = max(curr.dense[i], rank)
+	}
+	return nil
+}
+
+// Clear the registers, back to the sparse mode
+func (hll *HLL) Clear() {
+	hll.dense = nil
+	clear(hll.sparse)
+}
+
+// Sparse reports whether the sparse representation is in use
+func (hll *HLL) Sparse() bool {
+	return hll.dense == nil
+}
+
+func (hll *HLL) m() int {
+	return 1 << hll.p
+}
+
+// whether the sparse entries take more space than the dense registers (a byte each)
+func (hll *HLL) sparseFull() bool {
+	return len(hll.sparse)*sparseEntryBytes > hll.m()
+}
+
+func (hll *HLL) toDense() {
+	hll.dense = make([]uint8, hll.m())
+	for idx, rank := range hll.sparse {
+		i, r := sparseToDense(idx, rank, hll.p)
+		hll.dense[i] = max(hll.dense[i], r)
+	}
+	clear(hll.sparse)
+}
+
+// the register index (first p bits) and the rank (leading zeros of the rest + 1) of a hash
+func denseIndex(h uint64, p uint8) (uint32, uint8) {
+	idx := uint32(h >> (64 - p))
+	rank := uint8(bits.LeadingZeros64(h<<p|1<<(p-1))) + 1
+	return idx, rank
+}
+
+// the bits of a sparse index beyond the first p bits are a prefix of the dense rank
+func sparseToDense(idx uint32, rank uint8, p uint8) (uint32, uint8) {
+	extra := sparsePrecision - p
+	rest := idx & (1<<extra - 1)
+	if rest == 0 {
+		return idx >> extra, extra + rank
+	}
+	return idx >> extra, uint8(bits.LeadingZeros32(rest)) - (32 - extra) + 1
+}
+
+func alpha(m int) float64 {
+	switch m {
+	case 16:
+		return 0.673
+	case 32:
+		return 0.697
+	case 64:
+		return 0.709
+	}
+	return 0.7213 / (1 + 1.079/float64(m))
+}
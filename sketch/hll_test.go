@@ -0,0 +1,187 @@
+/***************************************************
+* Author: Diana Cohen (sch.diana@gmail.com)
+* **************************************************
+* test the HyperLogLog: the relative error of the sparse and dense estimates at several cardinalities,
+* the registers of a sparse sketch converted to dense are those of a dense sketch of the same keys,
+* and merging (sparse or dense into sparse or dense) is the same as adding the union of the keys
+* benchmark the HyperLogLog over the Zipf stream of cms_test.go:
+* HLLAdd      - add the keys, at precisions 4 and 14 (sparse until its entries take the space of the registers, then dense)
+* HLLMerge    - merge the HyperLogLog of a batch into that of the trace
+* HLLEstimate - estimate the number of flows of a dense HyperLogLog
+ */
+
+package sketch
+
+import (
+	"fmt"
+	"maps"
+	"math"
+	"slices"
+	"testing"
+)
+
+// an HLL of the keys flow<from> ... flow<to-1>
+func newTestHLL(t *testing.T, p uint8, from, to int) *HLL {
+	t.Helper()
+	hll, err := NewHLL(p, 7)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for x := from; x < to; x++ {
+		hll.Add(fmt.Sprintf("flow%d", x))
+	}
+	return hll
+}
+
+// an HLL that is dense from the start, of the keys flow<from> ... flow<to-1>
+func newDenseHLL(t *testing.T, p uint8, from, to int) *HLL {
+	t.Helper()
+	hll, _ := NewHLL(p, 7)
+	hll.toDense()
+	for x := from; x < to; x++ {
+		hll.Add(fmt.Sprintf("flow%d", x))
+	}
+	return hll
+}
+
+func TestHLLError(t *testing.T) {
+	tests := []struct {
+		p      uint8
+		n      int
+		sparse bool
+	}{
+		{10, 10, true},
+		{10, 100, true},
+		{10, 1000, false},
+		{10, 100000, false},
+		{14, 100, true},
+		{14, 2000, true},
+		{14, 10000, false},
+		{14, 100000, false},
+		{14, 1000000, false},
+		{18, 30000, true},
+		{18, 1000000, false},
+	}
+	for _, test := range tests {
+		t.Run(fmt.Sprintf("p=%d n=%d", test.p, test.n), func(t *testing.T) {
+			hll := newTestHLL(t, test.p, 0, test.n)
+			if hll.Sparse() != test.sparse {
+				t.Errorf("sparse %v, expected %v", hll.Sparse(), test.sparse)
+			}
+			// the sparse mode is linear counting of 2^25 registers, all but exact for a few keys,
+			// the dense mode is within 4 standard errors (1.04/sqrt(m))
+			bound := 4 * 1.04 / math.Sqrt(float64(uint64(1)<<test.p))
+			if test.sparse {
+				bound = 0.005
+			}
+			if err := math.Abs(hll.Estimate()-float64(test.n)) / float64(test.n); err > bound {
+				t.Errorf("estimate %.1f, a relative error of %.4f beyond %.4f", hll.Estimate(), err, bound)
+			}
+		})
+	}
+}
+
+func TestHLLToDense(t *testing.T) {
+	for _, n := range []int{1, 100, 2000} {
+		sparse := newTestHLL(t, 14, 0, n)
+		if !sparse.Sparse() {
+			t.Fatalf("%d keys: not sparse", n)
+		}
+		sparse.toDense()
+		dense := newDenseHLL(t, 14, 0, n)
+		if !slices.Equal(sparse.dense, dense.dense) {
+			t.Errorf("%d keys: the converted registers differ from the dense ones", n)
+		}
+		if sparse.Estimate() != dense.Estimate() {
+			t.Errorf("%d keys: estimate %f converted, %f dense", n, sparse.Estimate(), dense.Estimate())
+		}
+	}
+	// converted by the keys added: the registers remain those of a dense sketch
+	hll := newTestHLL(t, 10, 0, 5000)
+	if hll.Sparse() || !slices.Equal(hll.dense, newDenseHLL(t, 10, 0, 5000).dense) {
+		t.Error("the registers of a sketch converted by its keys differ from the dense ones")
+	}
+}
+
+func TestHLLMerge(t *testing.T) {
+	const p = 12 // dense beyond 512 keys
+	tests := []struct {
+		name         string
+		curr, other  [2]int // the keys of each
+		sparse_union bool
+	}{
+		{"sparse into sparse", [2]int{0, 100}, [2]int{50, 200}, true},
+		{"sparse into sparse, beyond the sparse mode", [2]int{0, 400}, [2]int{400, 800}, false},
+		{"sparse into dense", [2]int{0, 5000}, [2]int{4000, 4100}, false},
+		{"dense into sparse", [2]int{0, 100}, [2]int{50, 5000}, false},
+		{"dense into dense", [2]int{0, 5000}, [2]int{2500, 9000}, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			curr := newTestHLL(t, p, test.curr[0], test.curr[1])
+			other := newTestHLL(t, p, test.other[0], test.other[1])
+			if err := curr.Merge(other); err != nil {
+				t.Fatal(err)
+			}
+			if curr.Sparse() != test.sparse_union {
+				t.Fatalf("sparse %v, expected %v", curr.Sparse(), test.sparse_union)
+			}
+			from, to := min(test.curr[0], test.other[0]), max(test.curr[1], test.other[1])
+			if curr.Sparse() {
+				if !maps.Equal(curr.sparse, newTestHLL(t, p, from, to).sparse) {
+					t.Error("the merged sparse entries differ from those of the union")
+				}
+			} else if !slices.Equal(curr.dense, newDenseHLL(t, p, from, to).dense) {
+				t.Error("the merged registers differ from those of the union")
+			}
+		})
+	}
+
+	hll := newTestHLL(t, p, 0, 10)
+	for _, other := range []*HLL{newTestHLL(t, p+1, 0, 10), func() *HLL { h, _ := NewHLL(p, 8); return h }()} {
+		if hll.Merge(other) == nil {
+			t.Errorf("merged an HLL of precision %d, seed %d", other.p, other.seed)
+		}
+	}
+}
+
+func BenchmarkHLLAdd(b *testing.B) {
+	keys, _ := zipfKeys()
+	for _, p := range []uint8{4, 14} {
+		b.Run(fmt.Sprintf("p=%d", p), func(b *testing.B) {
+			hll, _ := NewHLL(p, 1)
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				hll.Add(keys[i&(len(keys)-1)])
+			}
+		})
+	}
+}
+
+func BenchmarkHLLMerge(b *testing.B) {
+	keys, _ := zipfKeys()
+	batch, _ := NewHLL(14, 1)
+	for _, key := range keys[:1000] {
+		batch.Add(key)
+	}
+	trace, _ := NewHLL(14, 1)
+	for _, key := range keys {
+		trace.Add(key)
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		trace.Merge(batch)
+	}
+}
+
+func BenchmarkHLLEstimate(b *testing.B) {
+	keys, _ := zipfKeys()
+	hll, _ := NewHLL(14, 1)
+	for _, key := range keys {
+		hll.Add(key)
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		hll.Estimate()
+	}
+}
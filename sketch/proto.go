@@ -0,0 +1,362 @@
+/***************************************************
+* Author: Diana Cohen (sch.diana@gmail.com)
+* **************************************************
+* the protobuf wire format of a CMS (CMSSnapshot) and of the CMS of a batch (BatchDelta),
+* as declared by proto/sketch.proto, encoded by hand with no dependencies:
+* a message is a sequence of fields, each a tag (field number << 3 | wire type, a varint) and a value,
+* the numbers are varints, the strings, nested messages and packed counters are length-delimited
+* the encoding omits the fields of zero values (proto3), the counters are packed varints
+* the decoding is as lenient as protobuf: the unknown fields are skipped, a field repeated is the last one,
+* the counters are accepted packed or not, the omitted counters are zeros and the omitted width is 64 bits
+* a stream of messages is delimited by the length of each message (a varint), as writeDelimitedTo
+ */
+
+package sketch
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// the hash families of the snapshots, see HashFamily of proto/sketch.proto
+const (
+	HashFamilyUnspecified  = 0 // read as HashFamilyDoubleHash64
+	HashFamilyDoubleHash64 = 1
+)
+
+// the wire types of protobuf
+const (
+	wireVarint  = 0
+	wireFixed64 = 1
+	wireBytes   = 2
+	wireFixed32 = 5
+)
+
+// the fields of CMSSnapshot
+const (
+	fieldDepth       = 1
+	fieldWidth       = 2
+	fieldCounterBits = 3
+	fieldHashFamily  = 4
+	fieldSeed        = 5
+	fieldSaturations = 6
+	fieldCounters    = 7
+)
+
+// the fields of BatchDelta
+const (
+	fieldSource = 1
+	fieldDomain = 2
+	fieldBatch  = 3
+	fieldItems  = 4
+	fieldFlows  = 5
+	fieldCMS    = 6
+)
+
+// the longest message of a delimited stream
+const maxDelimited = 1 << 30
+
+// MarshalProto encodes the sketch as a CMSSnapshot message
+func (cms *CMS) MarshalProto() ([]byte, error) {
+	return cms.appendProto(nil), nil
+}
+
+func (cms *CMS) appendProto(buf []byte) []byte {
+	buf = appendVarintField(buf, fieldDepth, uint64(cms.d))
+	buf = appendVarintField(buf, fieldWidth, uint64(cms.w))
+	buf = appendVarintField(buf, fieldCounterBits, uint64(cms.bits))
+	buf = appendVarintField(buf, fieldHashFamily, HashFamilyDoubleHash64)
+	buf = appendVarintField(buf, fieldSeed, cms.seed)
+	buf = appendVarintField(buf, fieldSaturations, cms.saturations)
+	var packed []byte
+	for k := 0; k < cms.d*cms.w; k++ {
+		packed = binary.AppendUvarint(packed, cms.at(k))
+	}
+	return appendBytesField(buf, fieldCounters, packed)
+}
+
+// UnmarshalProto decodes a CMSSnapshot message into the sketch, replacing its dimensions, seed and counters
+func (cms *CMS) UnmarshalProto(data []byte) error {
+	var d, w, bits, family, seed, saturations uint64
+	var counters []uint64
+	r := protoReader{data}
+	for !r.done() {
+		field, wire, err := r.tag()
+		if err != nil {
+			return err
+		}
+		switch {
+		case field >= fieldDepth && field <= fieldSaturations && wire == wireVarint:
+			v, err := r.varint()
+			if err != nil {
+				return err
+			}
+			switch field {
+			case fieldDepth:
+				d = v
+			case fieldWidth:
+				w = v
+			case fieldCounterBits:
+				bits = v
+			case fieldHashFamily:
+				family = v
+			case fieldSeed:
+				seed = v
+			default:
+				saturations = v
+			}
+		case field == fieldCounters && wire == wireBytes:
+			packed, err := r.bytes()
+			if err != nil {
+				return err
+			}
+			for p := (protoReader{packed}); !p.done(); {
+				v, err := p.varint()
+				if err != nil {
+					return err
+				}
+				counters = append(counters, v)
+			}
+		case field == fieldCounters && wire == wireVarint:
+			v, err := r.varint()
+			if err != nil {
+				return err
+			}
+			counters = append(counters, v)
+		case field <= fieldCounters:
+			return fmt.Errorf("CMS: field %d of wire type %d", field, wire)
+		default:
+			if err := r.skip(wire); err != nil {
+				return err
+			}
+		}
+	}
+
+	if bits == 0 {
+		bits = 64
+	}
+	if err := checkSize(d, w, bits); err != nil {
+		return err
+	}
+	if family != HashFamilyUnspecified && family != HashFamilyDoubleHash64 {
+		return fmt.Errorf("CMS: unsupported hash family %d", family)
+	}
+	decoded, err := NewWithWidth(int(d), int(w), int(bits))
+	if err != nil {
+		return err
+	}
+	if len(counters) != 0 && len(counters) != int(d*w) {
+		return fmt.Errorf("CMS: %d counters, expected %d x %d", len(counters), d, w)
+	}
+	max_val := ^uint64(0) >> (64 - bits)
+	for k, v := range counters {
+		if v > max_val {
+			return fmt.Errorf("CMS: counter %d exceeds %d bits", k, bits)
+		}
+		switch bits {
+		case 16:
+			decoded.count16[k] = uint16(v)
+		case 32:
+			decoded.count32[k] = uint32(v)
+		default:
+			decoded.count64[k] = v
+		}
+	}
+	decoded.seed, decoded.saturations = seed, saturations
+	*cms = *decoded
+	return nil
+}
+
+// Delta is the CMS of a single batch, see BatchDelta of proto/sketch.proto
+type Delta struct {
+	Source string
+	Domain uint32 // the batch size, or the time window in microseconds
+	Batch  uint32
+	Items  uint64 // B
+	Flows  uint64 // b
+	CMS    *CMS
+}
+
+// MarshalProto encodes the delta as a BatchDelta message
+func (delta *Delta) MarshalProto() ([]byte, error) {
+	if delta.CMS == nil {
+		return nil, errors.New("delta: the CMS is missing")
+	}
+	buf := appendBytesField(nil, fieldSource, []byte(delta.Source))
+	buf = appendVarintField(buf, fieldDomain, uint64(delta.Domain))
+	buf = appendVarintField(buf, fieldBatch, uint64(delta.Batch))
+	buf = appendVarintField(buf, fieldItems, delta.Items)
+	buf = appendVarintField(buf, fieldFlows, delta.Flows)
+	return appendBytesField(buf, fieldCMS, delta.CMS.appendProto(nil)), nil
+}
+
+// UnmarshalProto decodes a BatchDelta message into the delta
+func (delta *Delta) UnmarshalProto(data []byte) error {
+	decoded := Delta{}
+	var cms []byte
+	r := protoReader{data}
+	for !r.done() {
+		field, wire, err := r.tag()
+		if err != nil {
+			return err
+		}
+		switch {
+		case (field == fieldSource || field == fieldCMS) && wire == wireBytes:
+			value, err := r.bytes()
+			if err != nil {
+				return err
+			}
+			if field == fieldSource {
+				decoded.Source = string(value)
+			} else {
+				cms = value
+			}
+		case field >= fieldDomain && field <= fieldFlows && wire == wireVarint:
+			v, err := r.varint()
+			if err != nil {
+				return err
+			}
+			switch field {
+			case fieldDomain:
+				decoded.Domain = uint32(v)
+			case fieldBatch:
+				decoded.Batch = uint32(v)
+			case fieldItems:
+				decoded.Items = v
+			default:
+				decoded.Flows = v
+			}
+		case field <= fieldCMS:
+			return fmt.Errorf("delta: field %d of wire type %d", field, wire)
+		default:
+			if err := r.skip(wire); err != nil {
+				return err
+			}
+		}
+	}
+	if cms == nil {
+		return errors.New("delta: the CMS is missing")
+	}
+	decoded.CMS = &CMS{}
+	if err := decoded.CMS.UnmarshalProto(cms); err != nil {
+		return err
+	}
+	*delta = decoded
+	return nil
+}
+
+// AppendDelimited appends a message prefixed by its length, for a stream of messages
+func AppendDelimited(buf, msg []byte) []byte {
+	buf = binary.AppendUvarint(buf, uint64(len(msg)))
+	return append(buf, msg...)
+}
+
+// ReadDelimited reads a message prefixed by its length, io.EOF if the stream is over before it starts
+func ReadDelimited(r interface {
+	io.Reader
+	io.ByteReader
+}) ([]byte, error) {
+	length, err := binary.ReadUvarint(r)
+	if err != nil {
+		if err == io.EOF {
+			return nil, err
+		}
+		return nil, fmt.Errorf("protobuf: bad message length: %w", err)
+	}
+	if length > maxDelimited {
+		return nil, fmt.Errorf("protobuf: a message of %d bytes is too long", length)
+	}
+	msg := make([]byte, length)
+	if _, err := io.ReadFull(r, msg); err != nil {
+		return nil, errors.New("protobuf: truncated message")
+	}
+	return msg, nil
+}
+
+// append a varint field, omitted if zero
+func appendVarintField(buf []byte, field int, v uint64) []byte {
+	if v == 0 {
+		return buf
+	}
+	buf = binary.AppendUvarint(buf, uint64(field)<<3|wireVarint)
+	return binary.AppendUvarint(buf, v)
+}
+
+// append a length-delimited field, omitted if empty
+func appendBytesField(buf []byte, field int, value []byte) []byte {
+	if len(value) == 0 {
+		return buf
+	}
+	buf = binary.AppendUvarint(buf, uint64(field)<<3|wireBytes)
+	buf = binary.AppendUvarint(buf, uint64(len(value)))
+	return append(buf, value...)
+}
+
+// protoReader reads the fields of a message
+type protoReader struct {
+	data []byte
+}
+
+func (r *protoReader) done() bool {
+	return len(r.data) == 0
+}
+
+// the field number and wire type of the next field
+func (r *protoReader) tag() (field int, wire int, err error) {
+	v, err := r.varint()
+	if err != nil {
+		return 0, 0, err
+	}
+	if v>>3 == 0 || v>>3 > 1<<29-1 {
+		return 0, 0, fmt.Errorf("protobuf: bad field number %d", v>>3)
+	}
+	return int(v >> 3), int(v & 7), nil
+}
+
+func (r *protoReader) varint() (uint64, error) {
+	v, n := binary.Uvarint(r.data)
+	if n <= 0 {
+		return 0, errors.New("protobuf: bad varint")
+	}
+	r.data = r.data[n:]
+	return v, nil
+}
+
+func (r *protoReader) bytes() ([]byte, error) {
+	length, err := r.varint()
+	if err != nil {
+		return nil, err
+	}
+	if length > uint64(len(r.data)) {
+		return nil, errors.New("protobuf: truncated field")
+	}
+	value := r.data[:length]
+	r.data = r.data[length:]
+	return value, nil
+}
+
+// skip the value of an unknown field
+func (r *protoReader) skip(wire int) error {
+	var n int
+	switch wire {
+	case wireVarint:
+		_, err := r.varint()
+		return err
+	case wireBytes:
+		_, err := r.bytes()
+		return err
+	case wireFixed64:
+		n = 8
+	case wireFixed32:
+		n = 4
+	default: // the groups are deprecated, and not a part of proto3
+		return fmt.Errorf("protobuf: unsupported wire type %d", wire)
+	}
+	if len(r.data) < n {
+		return errors.New("protobuf: truncated field")
+	}
+	r.data = r.data[n:]
+	return nil
+}
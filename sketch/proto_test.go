@@ -0,0 +1,242 @@
+/***************************************************
+* Author: Diana Cohen (sch.diana@gmail.com)
+* **************************************************
+* test the compatibility of the protobuf wire format of the sketches (proto/sketch.proto):
+* golden   - fixed messages, encoded by the schema, are decoded (and encoded) byte for byte,
+*            so a change of the encoding that breaks the other components is caught
+* round trip - a CMS of every counter width is the same after protobuf
+* lenient  - the decoding of what other encoders may write: unknown fields of every wire type, unpacked
+*            counters, omitted defaults, a repeated field (the last one wins)
+* strict   - the decoding rejects the messages that do not describe a valid sketch
+* stream   - the length-delimited deltas, and their merge into an accumulated CMS
+* the test messages are encoded by hand (see pbVarint), not by the encoder under test
+ */
+
+package sketch
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"io"
+	"reflect"
+	"slices"
+	"testing"
+)
+
+// the golden messages: a CMS of 2 x 3 counters of 16 bits, seed 7, a saturation,
+// the counters [0 1 2] [300 0 65535], and a delta of it (source "t", domain 100, batch 3, B 303, b 3)
+const (
+	goldenSnapshot = "0802100318102001280730013a09000102ac0200ffff03"
+	goldenDelta    = "0a01741064180320af02280332170802100318102001280730013a09000102ac0200ffff03"
+)
+
+var goldenCounters = []uint64{0, 1, 2, 300, 0, 65535}
+
+func goldenBytes(t *testing.T, golden string) []byte {
+	t.Helper()
+	data, err := hex.DecodeString(golden)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+// check that a CMS is the golden one
+func checkGolden(t *testing.T, cms *CMS) {
+	t.Helper()
+	if cms.d != 2 || cms.w != 3 || cms.bits != 16 || cms.seed != 7 || cms.saturations != 1 {
+		t.Fatalf("decoded %d x %d counters of %d bits, seed %d, %d saturations", cms.d, cms.w, cms.bits, cms.seed, cms.saturations)
+	}
+	counters := make([]uint64, cms.d*cms.w)
+	for k := range counters {
+		counters[k] = cms.at(k)
+	}
+	if !slices.Equal(counters, goldenCounters) {
+		t.Errorf("decoded the counters %v, expected %v", counters, goldenCounters)
+	}
+}
+
+func TestProtoGolden(t *testing.T) {
+	cms := &CMS{}
+	if err := cms.UnmarshalProto(goldenBytes(t, goldenSnapshot)); err != nil {
+		t.Fatal(err)
+	}
+	checkGolden(t, cms)
+	if data, _ := cms.MarshalProto(); hex.EncodeToString(data) != goldenSnapshot {
+		t.Errorf("encoded %x, expected %s", data, goldenSnapshot)
+	}
+
+	var delta Delta
+	if err := delta.UnmarshalProto(goldenBytes(t, goldenDelta)); err != nil {
+		t.Fatal(err)
+	}
+	if delta.Source != "t" || delta.Domain != 100 || delta.Batch != 3 || delta.Items != 303 || delta.Flows != 3 {
+		t.Errorf("decoded %+v", delta)
+	}
+	checkGolden(t, delta.CMS)
+	if data, _ := delta.MarshalProto(); hex.EncodeToString(data) != goldenDelta {
+		t.Errorf("encoded %x, expected %s", data, goldenDelta)
+	}
+}
+
+func TestProtoRoundTrip(t *testing.T) {
+	keys, _ := zipfStream(10000)
+	for _, bits := range counterBits {
+		cms := newTestCMS(t, 4, 1021, bits)
+		for i, key := range keys {
+			cms.Update(key, 1+i%7)
+		}
+		cms.Update("flow1", 1<<20) // saturates the 16-bit counters
+		data, _ := cms.MarshalProto()
+		decoded := &CMS{}
+		if err := decoded.UnmarshalProto(data); err != nil {
+			t.Fatalf("%d bits: %v", bits, err)
+		}
+		if !reflect.DeepEqual(decoded, cms) {
+			t.Errorf("%d bits: the decoded sketch differs", bits)
+		}
+	}
+}
+
+func TestProtoLenient(t *testing.T) {
+	golden := goldenBytes(t, goldenSnapshot)
+	unpacked := slices.Concat(pbVarint(1, 2), pbVarint(2, 3), pbVarint(3, 16), pbVarint(5, 7), pbVarint(6, 1))
+	for _, v := range goldenCounters {
+		unpacked = append(unpacked, pbVarint(7, v)...)
+	}
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"unknown fields", slices.Concat(
+			pbFixed(100, 1, 8), // fixed64, before the known fields
+			golden,
+			pbVarint(15, 1<<40),
+			pbBytes(16, []byte("a newer field")),
+			pbFixed(101, 5, 4), // fixed32
+		)},
+		{"unpacked counters", unpacked},
+		{"the last field wins", slices.Concat(pbVarint(5, 99), golden)}, // an earlier seed, overridden by the golden one
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cms := &CMS{}
+			if err := cms.UnmarshalProto(test.data); err != nil {
+				t.Fatal(err)
+			}
+			checkGolden(t, cms)
+		})
+	}
+
+	// the counter width, hash family, seed and counters omitted: 64 bits, the default family, zeros
+	cms := &CMS{}
+	if err := cms.UnmarshalProto(slices.Concat(pbVarint(1, 2), pbVarint(2, 3))); err != nil {
+		t.Fatal(err)
+	}
+	if cms.bits != 64 || cms.Total() != 0 || cms.seed != 0 {
+		t.Errorf("decoded the defaults as %d bits, total %d, seed %d", cms.bits, cms.Total(), cms.seed)
+	}
+}
+
+func TestProtoStrict(t *testing.T) {
+	golden := goldenBytes(t, goldenSnapshot)
+	dims := slices.Concat(pbVarint(1, 2), pbVarint(2, 3))
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"no dimensions", pbVarint(3, 16)},
+		{"a counter too many", slices.Concat(golden, pbVarint(7, 1))},
+		{"a counter beyond its width", slices.Concat(dims, pbVarint(3, 16), pbBytes(7, packed(0, 1, 2, 3, 4, 1<<16)))},
+		{"a counter width of 8 bits", slices.Concat(dims, pbVarint(3, 8))},
+		{"an unknown hash family", slices.Concat(golden, pbVarint(4, 2))},
+		{"the depth as bytes", slices.Concat(pbBytes(1, []byte{2}), pbVarint(2, 3))},
+		{"beyond MaxBytes", slices.Concat(pbVarint(1, 8), pbVarint(2, 1<<24+1), pbVarint(3, 64))},
+		{"a truncated message", golden[:len(golden)-2]},
+		{"a group", slices.Concat(golden, pbFixed(20, 3, 0))},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if (&CMS{}).UnmarshalProto(test.data) == nil {
+				t.Error("accepted")
+			}
+		})
+	}
+
+	var delta Delta
+	if delta.UnmarshalProto(pbBytes(1, []byte("t"))) == nil {
+		t.Error("accepted a delta of no CMS")
+	}
+}
+
+func TestProtoStream(t *testing.T) {
+	golden := goldenBytes(t, goldenDelta)
+	var stream []byte
+	for range 3 {
+		stream = AppendDelimited(stream, golden)
+	}
+	acc := newTestCMS(t, 2, 3, 16)
+	r := bufio.NewReader(bytes.NewReader(stream))
+	n := 0
+	for {
+		msg, err := ReadDelimited(r)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		var delta Delta
+		if err := delta.UnmarshalProto(msg); err != nil {
+			t.Fatal(err)
+		}
+		if err := acc.Merge(delta.CMS); err != nil {
+			t.Fatal(err)
+		}
+		n++
+	}
+	if n != 3 || acc.Total() != 3*3 { // the first row of the golden CMS totals 3
+		t.Errorf("merged %d deltas, total %d", n, acc.Total())
+	}
+
+	tests := []struct {
+		name   string
+		stream []byte
+		whole  bool // the first message is whole
+	}{
+		{"the last message truncated", stream[:len(stream)-1], true},
+		{"the first message truncated", stream[:len(golden)/2], false},
+	}
+	for _, test := range tests {
+		_, err := ReadDelimited(bufio.NewReader(bytes.NewReader(test.stream)))
+		if (err == nil) != test.whole || err == io.EOF {
+			t.Errorf("%s: read %v", test.name, err)
+		}
+	}
+}
+
+// the protobuf encoding of the test messages: a varint field, a length-delimited field,
+// a fixed field of a given wire type and length (zeros), and the packed varints
+func pbVarint(field int, v uint64) []byte {
+	return binary.AppendUvarint(binary.AppendUvarint(nil, uint64(field)<<3), v)
+}
+
+func pbBytes(field int, value []byte) []byte {
+	buf := binary.AppendUvarint(nil, uint64(field)<<3|2)
+	buf = binary.AppendUvarint(buf, uint64(len(value)))
+	return append(buf, value...)
+}
+
+func pbFixed(field, wire, n int) []byte {
+	return append(binary.AppendUvarint(nil, uint64(field)<<3|uint64(wire)), make([]byte, n)...)
+}
+
+func packed(values ...uint64) []byte {
+	var buf []byte
+	for _, v := range values {
+		buf = binary.AppendUvarint(buf, v)
+	}
+	return buf
+}
@@ -0,0 +1,90 @@
+/***************************************************
+* Author: Diana Cohen (sch.diana@gmail.com)
+* **************************************************
+* implement a sliding-window Count-Min Sketch, using panes (a jumping window):
+* the window is split into k panes, each pane is a CMS of its own (sharing the seeds),
+* while the sum of the panes is kept in an aggregate CMS that answers the queries
+* on slide, the oldest pane is subtracted from the aggregate and reused as the newest pane,
+* hence the sketch covers between (k-1)/k and all of the window
+* the caller decides when to slide: every W/k items, or every W/k time units
+ */
+
+package sketch
+
+import "errors"
+
+// SlidingCMS is a Count-Min Sketch over the latest k panes of a stream
+type SlidingCMS struct {
+	panes []*CMS
+	curr  int  // index of the newest pane
+	sum   *CMS // aggregate of all panes
+}
+
+// NewSliding creates a sliding CMS with d X w matrix of counters per pane, and k panes
+func NewSliding(d, w, k int) (*SlidingCMS, error) {
+	if k <= 0 {
+		return nil, errors.New("CMS: the number of panes must be greater than 0")
+	}
+	sum, err := New(d, w)
+	if err != nil {
+		return nil, err
+	}
+	s := &SlidingCMS{panes: make([]*CMS, k), sum: sum}
+	for i := range s.panes {
+		s.panes[i], _ = New(d, w)
+		s.panes[i].CopySeeds(sum) // use the same seeds for all
+	}
+	return s, nil
+}
+
+// NewSlidingWithEstimates creates a sliding CMS with given error rate and confidence per pane
+func NewSlidingWithEstimates(epsilon, delta float64, k int) (*SlidingCMS, error) {
+	d, w, err := Dimensions(epsilon, delta)
+	if err != nil {
+		return nil, err
+	}
+	return NewSliding(d, w, k)
+}
+
+// Seed returns the seed of the hash functions, shared by the panes
+func (s *SlidingCMS) Seed() uint64 {
+	return s.sum.seed
+}
+
+// SetSeed sets the seed of the hash functions of all the panes, before any update
+func (s *SlidingCMS) SetSeed(seed uint64) {
+	s.sum.seed = seed
+	for _, pane := range s.panes {
+		pane.seed = seed
+	}
+}
+
+// Update the frequency of a given key within the newest pane
+func (s *SlidingCMS) Update(key string, cnt int) {
+	h := Hash64(key, s.sum.seed)
+	s.panes[s.curr].update(h, cnt)
+	s.sum.update(h, cnt)
+}
+
+// Slide the window by a single pane, expiring the oldest one
+func (s *SlidingCMS) Slide() {
+	s.curr = (s.curr + 1) % len(s.panes)
+	oldest := s.panes[s.curr]
+	s.sum.subtract(oldest)
+	oldest.Clear()
+}
+
+// Estimate the frequency of a key within the window. This is a point query.
+func (s *SlidingCMS) Estimate(key string) int {
+	return s.sum.Estimate(key)
+}
+
+// Distinct estimates the number of distinct keys within the window
+func (s *SlidingCMS) Distinct() float64 {
+	return s.sum.Distinct()
+}
+
+// Panes returns the number of panes
+func (s *SlidingCMS) Panes() int {
+	return len(s.panes)
+}
@@ -0,0 +1,68 @@
+/***************************************************
+* Author: Diana Cohen (sch.diana@gmail.com)
+* **************************************************
+* test the sliding CMS: an update is counted until its pane expires (k slides later), and not after,
+* the aggregate of the panes is always the sum of the panes, the same as a CMS of the latest k panes
+ */
+
+package sketch
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+)
+
+func TestSlidingExpiry(t *testing.T) {
+	const k = 3
+	s, err := NewSliding(4, 64, k)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.SetSeed(7)
+	s.Update("flow1", 5)
+	for slides := 0; slides <= k; slides++ {
+		want := 5
+		if slides == k { // the pane of the update is the oldest one, reused as the newest pane
+			want = 0
+		}
+		if got := s.Estimate("flow1"); got != want {
+			t.Errorf("after %d slides: estimate %d, expected %d", slides, got, want)
+		}
+		s.Slide()
+	}
+	if _, err := NewSliding(4, 64, 0); err == nil {
+		t.Error("created a sliding CMS of no panes")
+	}
+}
+
+// the aggregate equals a CMS updated by the keys of the latest k panes alone
+func TestSlidingWindow(t *testing.T) {
+	const k, pane_items = 4, 500
+	keys, _ := zipfStream(20 * pane_items)
+	s, _ := NewSliding(4, 256, k)
+	s.SetSeed(7)
+	for p := 0; p < 20; p++ {
+		if p > 0 {
+			s.Slide()
+		}
+		for _, key := range keys[p*pane_items : (p+1)*pane_items] {
+			s.Update(key, 1)
+		}
+		window := newTestCMS(t, 4, 256, DefaultCounterBits)
+		for _, key := range keys[max(0, p-k+1)*pane_items : (p+1)*pane_items] {
+			window.Update(key, 1)
+		}
+		a, _ := s.sum.MarshalBinary()
+		b, _ := window.MarshalBinary()
+		if !bytes.Equal(a, b) {
+			t.Fatalf("pane %d: the aggregate differs from a CMS of the window", p)
+		}
+		for i := 0; i < 10; i++ {
+			key := fmt.Sprintf("flow%d", i)
+			if s.Estimate(key) != window.Estimate(key) {
+				t.Errorf("pane %d: the estimate of %s differs", p, key)
+			}
+		}
+	}
+}
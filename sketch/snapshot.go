@@ -0,0 +1,156 @@
+/***************************************************
+* Author: Diana Cohen (sch.diana@gmail.com)
+* **************************************************
+* serialize a CMS as a binary snapshot, e.g. to persist it, or to ship the delta of a batch
+* to a collector that merges it into an accumulated sketch:
+* a header of 32 bytes - magic "CMS\x01", d, w and the counter width (uint32 each),
+* the seed and the saturations (uint64 each), followed by the d*w counters of the counter width,
+* all little-endian, row by row
+* the snapshot is self-delimiting (the header gives the length of the counters), so the snapshots
+* may follow each other on a stream; a decoded sketch holds at most MaxBytes of counters, and
+* the counters of a stream are read before the sketch is allocated, so a header that lies costs
+* no more memory than the bytes that actually follow it
+ */
+
+package sketch
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// SnapshotMagic starts every snapshot
+const SnapshotMagic = "CMS\x01"
+
+// MaxBytes bounds the counters of a decoded sketch, 1 GiB: 2^29 16-bit, 2^28 32-bit or 2^27 64-bit counters
+const MaxBytes = 1 << 30
+
+const snapshotHeaderLen = 32
+
+// MarshalBinary encodes the sketch as a snapshot
+func (cms *CMS) MarshalBinary() ([]byte, error) {
+	buf := make([]byte, 0, snapshotHeaderLen+cms.Bytes())
+	buf = append(buf, SnapshotMagic...)
+	buf = binary.LittleEndian.AppendUint32(buf, uint32(cms.d))
+	buf = binary.LittleEndian.AppendUint32(buf, uint32(cms.w))
+	buf = binary.LittleEndian.AppendUint32(buf, uint32(cms.bits))
+	buf = binary.LittleEndian.AppendUint64(buf, cms.seed)
+	buf = binary.LittleEndian.AppendUint64(buf, cms.saturations)
+	switch cms.bits {
+	case 16:
+		for _, value := range cms.count16 {
+			buf = binary.LittleEndian.AppendUint16(buf, value)
+		}
+	case 32:
+		for _, value := range cms.count32 {
+			buf = binary.LittleEndian.AppendUint32(buf, value)
+		}
+	default:
+		for _, value := range cms.count64 {
+			buf = binary.LittleEndian.AppendUint64(buf, value)
+		}
+	}
+	return buf, nil
+}
+
+// UnmarshalBinary decodes a snapshot into the sketch, replacing its dimensions, seed and counters
+func (cms *CMS) UnmarshalBinary(data []byte) error {
+	header, n, err := parseHeader(data)
+	if err != nil {
+		return err
+	}
+	if uint64(len(data)) != n {
+		return fmt.Errorf("CMS: a snapshot of %d bytes, expected %d", len(data), n)
+	}
+	decoded, err := header.decode(data[snapshotHeaderLen:])
+	if err != nil {
+		return err
+	}
+	*cms = *decoded
+	return nil
+}
+
+// ReadSnapshot reads a single snapshot from a stream, io.EOF if the stream is over before it starts
+func ReadSnapshot(r io.Reader) (*CMS, error) {
+	header := make([]byte, snapshotHeaderLen)
+	if _, err := io.ReadFull(r, header); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			return nil, errors.New("CMS: truncated snapshot")
+		}
+		return nil, err
+	}
+	parsed, n, err := parseHeader(header)
+	if err != nil {
+		return nil, err
+	}
+	// read the counters as they arrive (rather than allocating them by the header), then the sketch
+	counters, err := io.ReadAll(io.LimitReader(r, int64(n-snapshotHeaderLen)))
+	if err != nil {
+		return nil, err
+	}
+	if uint64(len(counters)) != n-snapshotHeaderLen {
+		return nil, errors.New("CMS: truncated snapshot")
+	}
+	return parsed.decode(counters)
+}
+
+// the header of a snapshot
+type snapshotHeader struct {
+	d, w, bits        uint64
+	seed, saturations uint64
+}
+
+// parse the header of a snapshot, returning the length of the whole snapshot
+func parseHeader(data []byte) (snapshotHeader, uint64, error) {
+	if len(data) < snapshotHeaderLen || string(data[:4]) != SnapshotMagic {
+		return snapshotHeader{}, 0, errors.New("CMS: not a snapshot")
+	}
+	h := snapshotHeader{
+		d:           uint64(binary.LittleEndian.Uint32(data[4:])),
+		w:           uint64(binary.LittleEndian.Uint32(data[8:])),
+		bits:        uint64(binary.LittleEndian.Uint32(data[12:])),
+		seed:        binary.LittleEndian.Uint64(data[16:]),
+		saturations: binary.LittleEndian.Uint64(data[24:]),
+	}
+	if err := checkSize(h.d, h.w, h.bits); err != nil {
+		return snapshotHeader{}, 0, err
+	}
+	return h, snapshotHeaderLen + h.d*h.w*h.bits/8, nil
+}
+
+// decode the sketch of the header from its little-endian counters
+func (h snapshotHeader) decode(counters []byte) (*CMS, error) {
+	cms, err := NewWithWidth(int(h.d), int(h.w), int(h.bits))
+	if err != nil {
+		return nil, err
+	}
+	cms.seed, cms.saturations = h.seed, h.saturations
+	cms.setCounters(counters)
+	return cms, nil
+}
+
+// check the dimensions and counter width of a decoded sketch, whose counters must fit within MaxBytes
+func checkSize(d, w, bits uint64) error {
+	if bits != 16 && bits != 32 && bits != 64 {
+		return errors.New("CMS: counter width must be 16, 32 or 64 bits")
+	}
+	if d == 0 || w == 0 || d > MaxBytes || w > MaxBytes || d*w*bits/8 > MaxBytes {
+		return fmt.Errorf("CMS: bad snapshot dimensions %d x %d of %d-bit counters", d, w, bits)
+	}
+	return nil
+}
+
+// set the counters from their little-endian encoding
+func (cms *CMS) setCounters(data []byte) {
+	for k := range cms.count16 {
+		cms.count16[k] = binary.LittleEndian.Uint16(data[2*k:])
+	}
+	for k := range cms.count32 {
+		cms.count32[k] = binary.LittleEndian.Uint32(data[4*k:])
+	}
+	for k := range cms.count64 {
+		cms.count64[k] = binary.LittleEndian.Uint64(data[8*k:])
+	}
+}
@@ -0,0 +1,119 @@
+/***************************************************
+* Author: Diana Cohen (sch.diana@gmail.com)
+* **************************************************
+* test the binary snapshots: a sketch of every counter width survives a round trip (alone, and on a stream),
+* malformed headers are rejected, and a header is not trusted for the memory of the sketch: the counters
+* are bounded by MaxBytes, and a stream of fewer counters than its header claims is read without allocating them
+ */
+
+package sketch
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"reflect"
+	"runtime"
+	"testing"
+)
+
+func TestSnapshotRoundTrip(t *testing.T) {
+	keys, _ := zipfStream(10000)
+	var stream bytes.Buffer
+	var sketches []*CMS
+	for _, bits := range counterBits {
+		cms := newTestCMS(t, 3, 100, bits)
+		for _, key := range keys {
+			cms.Update(key, 1)
+		}
+		cms.Update("flow1", 1<<20) // saturates the 16-bit counters
+		data, _ := cms.MarshalBinary()
+		if len(data) != snapshotHeaderLen+cms.Bytes() {
+			t.Errorf("%d bits: a snapshot of %d bytes, expected %d", bits, len(data), snapshotHeaderLen+cms.Bytes())
+		}
+		decoded := &CMS{}
+		if err := decoded.UnmarshalBinary(data); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(decoded, cms) {
+			t.Errorf("%d bits: the decoded sketch differs", bits)
+		}
+		stream.Write(data)
+		sketches = append(sketches, cms)
+	}
+
+	for _, cms := range sketches {
+		read, err := ReadSnapshot(&stream)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(read, cms) {
+			t.Errorf("%d bits: the sketch read from the stream differs", cms.bits)
+		}
+	}
+	if _, err := ReadSnapshot(&stream); err != io.EOF {
+		t.Errorf("read %v at the end of the stream, expected EOF", err)
+	}
+}
+
+// the header of a snapshot of given dimensions
+func snapshotHeaderOf(d, w, bits uint32) []byte {
+	header := []byte(SnapshotMagic)
+	header = binary.LittleEndian.AppendUint32(header, d)
+	header = binary.LittleEndian.AppendUint32(header, w)
+	header = binary.LittleEndian.AppendUint32(header, bits)
+	return append(header, make([]byte, 16)...) // seed, saturations
+}
+
+func TestSnapshotMalformed(t *testing.T) {
+	valid, _ := newTestCMS(t, 2, 3, 16).MarshalBinary()
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"empty", nil},
+		{"a truncated header", valid[:snapshotHeaderLen-1]},
+		{"no magic", append([]byte("CMS\x02"), valid[4:]...)},
+		{"truncated counters", valid[:len(valid)-1]},
+		{"no depth", snapshotHeaderOf(0, 3, 16)},
+		{"no width", snapshotHeaderOf(2, 0, 16)},
+		{"8-bit counters", snapshotHeaderOf(2, 3, 8)},
+		{"beyond MaxBytes of 16-bit counters", snapshotHeaderOf(1<<15, 1<<14+1, 16)},
+		{"beyond MaxBytes of 64-bit counters", snapshotHeaderOf(8, 1<<24+1, 64)},
+		{"dimensions of 2^64 bits", snapshotHeaderOf(1<<31, 1<<31, 64)},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if (&CMS{}).UnmarshalBinary(test.data) == nil {
+				t.Error("decoded by UnmarshalBinary")
+			}
+			if _, err := ReadSnapshot(bytes.NewReader(test.data)); err == nil || (len(test.data) == 0) != (err == io.EOF) {
+				t.Errorf("read by ReadSnapshot: %v", err)
+			}
+		})
+	}
+
+	// the largest sketch is within MaxBytes
+	if _, _, err := parseHeader(snapshotHeaderOf(8, 1<<24, 64)); err != nil {
+		t.Errorf("a sketch of MaxBytes: %v", err)
+	}
+	if (&CMS{}).UnmarshalBinary(append(valid, 0)) == nil {
+		t.Error("decoded a snapshot followed by a byte")
+	}
+}
+
+// a header of a sketch of MaxBytes, followed by a few counters, is read with no more memory than those counters
+func TestSnapshotLyingHeader(t *testing.T) {
+	stream := append(snapshotHeaderOf(8, 1<<24, 64), make([]byte, 1000)...)
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	_, err := ReadSnapshot(bytes.NewReader(stream))
+	runtime.ReadMemStats(&after)
+	if err == nil || errors.Is(err, io.EOF) {
+		t.Fatalf("read %v, expected a truncated snapshot", err)
+	}
+	if allocated := after.TotalAlloc - before.TotalAlloc; allocated > 1<<20 {
+		t.Errorf("allocated %d bytes for a truncated snapshot of %d bytes", allocated, len(stream))
+	}
+}
@@ -0,0 +1,114 @@
+/***************************************************
+* Author: Diana Cohen (sch.diana@gmail.com)
+* **************************************************
+* track the heavy hitters (top-k) of a stream alongside a CMS, which keeps no keys:
+* the candidates are the k keys of the largest estimates seen so far, in a min-heap by estimate,
+* a key is offered along with its estimate after each update, replacing the smallest candidate
+* once its estimate is larger
+* the keys of a merged sketch are unknown, so a merge only refreshes the estimates of the candidates
+ */
+
+package sketch
+
+import (
+	"cmp"
+	"container/heap"
+	"errors"
+	"slices"
+)
+
+// HeavyHitter is a key along with its estimated frequency
+type HeavyHitter struct {
+	Key   string
+	Count int
+}
+
+// TopK tracks the k keys of the largest estimates
+type TopK struct {
+	k     int
+	items topHeap
+	index map[string]int // key -> position within the heap
+}
+
+// NewTopK creates a tracker of the top k keys
+func NewTopK(k int) (*TopK, error) {
+	if k <= 0 {
+		return nil, errors.New("top-k: k must be greater than 0")
+	}
+	t := &TopK{k: k, index: make(map[string]int)}
+	t.items.index = t.index
+	return t, nil
+}
+
+// Offer a key along with its current estimate
+func (t *TopK) Offer(key string, estimate int) {
+	if i, found := t.index[key]; found {
+		t.items.list[i].Count = estimate
+		heap.Fix(&t.items, i)
+		return
+	}
+	if len(t.items.list) < t.k {
+		heap.Push(&t.items, HeavyHitter{key, estimate})
+		return
+	}
+	if min := t.items.list[0]; estimate > min.Count {
+		delete(t.index, min.Key)
+		t.items.list[0] = HeavyHitter{key, estimate}
+		t.index[key] = 0
+		heap.Fix(&t.items, 0)
+	}
+}
+
+// Refresh the estimates of all the candidates, e.g. after a merge
+func (t *TopK) Refresh(estimate func(key string) int) {
+	for i := range t.items.list {
+		t.items.list[i].Count = estimate(t.items.list[i].Key)
+	}
+	heap.Init(&t.items)
+}
+
+// List the top n candidates (all of them if n <= 0), by descending estimate
+func (t *TopK) List(n int) []HeavyHitter {
+	list := make([]HeavyHitter, len(t.items.list))
+	copy(list, t.items.list)
+	slices.SortFunc(list, func(a, b HeavyHitter) int {
+		return cmp.Or(cmp.Compare(b.Count, a.Count), cmp.Compare(a.Key, b.Key))
+	})
+	if n > 0 && n < len(list) {
+		list = list[:n]
+	}
+	return list
+}
+
+// K returns the number of candidates that are tracked
+func (t *TopK) K() int {
+	return t.k
+}
+
+// topHeap is a min-heap of the candidates by estimate, along with the position of every key
+type topHeap struct {
+	list  []HeavyHitter
+	index map[string]int
+}
+
+func (h *topHeap) Len() int           { return len(h.list) }
+func (h *topHeap) Less(i, j int) bool { return h.list[i].Count < h.list[j].Count }
+
+func (h *topHeap) Swap(i, j int) {
+	h.list[i], h.list[j] = h.list[j], h.list[i]
+	h.index[h.list[i].Key] = i
+	h.index[h.list[j].Key] = j
+}
+
+func (h *topHeap) Push(x any) {
+	item := x.(HeavyHitter)
+	h.index[item.Key] = len(h.list)
+	h.list = append(h.list, item)
+}
+
+func (h *topHeap) Pop() any {
+	item := h.list[len(h.list)-1]
+	h.list = h.list[:len(h.list)-1]
+	delete(h.index, item.Key)
+	return item
+}
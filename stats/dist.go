@@ -0,0 +1,200 @@
+/***************************************************
+* Author: Diana Cohen (sch.diana@gmail.com)
+* **************************************************
+* characterize the flow-size distribution of a trace (or a batch), given the frequency of every flow:
+* a log-binned histogram - bin k holds the flows of size [2^k, 2^(k+1))
+* the skew - Zipf's law, the size of the flow of rank r is about C/r^s, s is fitted by least squares
+* over log(rank) -> log(size), at log-spaced ranks (so the long tail of small flows does not dominate),
+* the goodness-of-fit is given by R^2 of the regression, and by the KS distance, i.e., the largest gap
+* between the empirical share of traffic of the top r flows and the share predicted by the fit
+* the heavy hitters - the share of traffic of the top fraction of flows (e.g. top 1%, 10%)
+* the Shannon entropy of the flow frequencies, in bits
+* the summary of a sample (e.g. beta per batch): mean, std, min, max and percentiles
+ */
+
+package stats
+
+import (
+	"iter"
+	"math"
+	"math/bits"
+	"slices"
+)
+
+// Bin is a bin of a log-binned histogram of the flow sizes
+type Bin struct {
+	Lower, Upper uint64 // the flow sizes of the bin: [Lower, Upper)
+	Flows        uint64 // number of flows within the bin
+	Items        uint64 // number of items (traffic) of these flows
+}
+
+// LogHistogram bins the flow sizes by powers of 2, the bins range from size 1 to the largest flow
+func LogHistogram(sizes []uint64) []Bin {
+	var bins []Bin
+	for _, size := range sizes {
+		if size == 0 {
+			continue
+		}
+		k := bits.Len64(size) - 1 // 2^k <= size < 2^(k+1)
+		for len(bins) <= k {
+			lower := uint64(1) << len(bins)
+			bins = append(bins, Bin{Lower: lower, Upper: lower << 1})
+		}
+		bins[k].Flows++
+		bins[k].Items += size
+	}
+	return bins
+}
+
+// Zipf is a fit of the rank-size distribution: size(r) = C/r^S
+type Zipf struct {
+	S  float64 // the exponent
+	C  float64 // the size of the largest flow, as fitted
+	R2 float64 // the coefficient of determination of the log-log regression
+	KS float64 // the largest gap between the empirical and the fitted cumulative traffic share
+}
+
+// the ratio between consecutive ranks of the fit
+const rankStep = 1.1
+
+// FitZipf fits Zipf's law to flow sizes sorted in descending order, see SortDesc
+func FitZipf(sorted []uint64) Zipf {
+	n := len(sorted)
+	if n < 2 {
+		return Zipf{S: math.NaN(), C: math.NaN(), R2: math.NaN(), KS: math.NaN()}
+	}
+	// least squares over the log-spaced ranks
+	var sx, sy, sxx, sxy, syy, m float64
+	for r := 1; r <= n; r = max(r+1, int(float64(r)*rankStep)) {
+		x, y := math.Log(float64(r)), math.Log(float64(sorted[r-1]))
+		sx += x
+		sy += y
+		sxx += x * x
+		sxy += x * y
+		syy += y * y
+		m++
+	}
+	cov := sxy - sx*sy/m
+	var_x := sxx - sx*sx/m
+	var_y := syy - sy*sy/m
+	slope := cov / var_x
+	z := Zipf{S: -slope, C: math.Exp((sy - slope*sx) / m), R2: 1}
+	if var_y > 0 {
+		z.R2 = cov * cov / (var_x * var_y)
+	}
+
+	// the KS distance between the cumulative traffic shares
+	total, harmonic := 0.0, 0.0 // the traffic, and the generalized harmonic number H(n, s)
+	for r, size := range sorted {
+		total += float64(size)
+		harmonic += math.Pow(float64(r+1), -z.S)
+	}
+	emp, fit := 0.0, 0.0
+	for r, size := range sorted {
+		emp += float64(size) / total
+		fit += math.Pow(float64(r+1), -z.S) / harmonic
+		z.KS = max(z.KS, math.Abs(emp-fit))
+	}
+	return z
+}
+
+// TopShare returns the share of traffic of the top fraction of flows (at least a single flow),
+// given the flow sizes sorted in descending order
+func TopShare(sorted []uint64, fraction float64) float64 {
+	if len(sorted) == 0 {
+		return math.NaN()
+	}
+	top := max(1, int(math.Ceil(fraction*float64(len(sorted)))))
+	total, top_total := uint64(0), uint64(0)
+	for r, size := range sorted {
+		total += size
+		if r < top {
+			top_total += size
+		}
+	}
+	return float64(top_total) / float64(total)
+}
+
+// SortDesc returns the flow sizes sorted in descending order
+func SortDesc(sizes []uint64) []uint64 {
+	sorted := slices.Clone(sizes)
+	slices.SortFunc(sorted, func(a, b uint64) int {
+		switch {
+		case a > b:
+			return -1
+		case a < b:
+			return 1
+		}
+		return 0
+	})
+	return sorted
+}
+
+// Entropy returns the Shannon entropy (in bits) of the flow frequencies: -sum (f/N) log2(f/N),
+// computed as log2(N) - sum f log2(f) / N
+func Entropy(sizes iter.Seq[uint64]) float64 {
+	total, sum := 0.0, 0.0
+	for size := range sizes {
+		if size > 0 {
+			f := float64(size)
+			total += f
+			sum += f * math.Log2(f)
+		}
+	}
+	if total == 0 {
+		return 0
+	}
+	return max(0, math.Log2(total)-sum/total)
+}
+
+// Summary describes a sample: mean, standard deviation (of the population, as numpy's std),
+// min, max and the requested percentiles
+type Summary struct {
+	Count       int
+	Mean, Std   float64
+	Min, Max    float64
+	Percentiles []float64 // in order of the requested percentiles
+}
+
+// Summarize a sample, given the percentiles within [0, 100]
+func Summarize(values []float64, percentiles []float64) Summary {
+	s := Summary{Count: len(values), Percentiles: make([]float64, len(percentiles))}
+	if len(values) == 0 {
+		s.Mean, s.Std, s.Min, s.Max = math.NaN(), math.NaN(), math.NaN(), math.NaN()
+		for i := range s.Percentiles {
+			s.Percentiles[i] = math.NaN()
+		}
+		return s
+	}
+	sorted := slices.Clone(values)
+	slices.Sort(sorted)
+	sum := 0.0
+	for _, v := range sorted {
+		sum += v
+	}
+	s.Mean = sum / float64(len(sorted))
+	sum_sq := 0.0
+	for _, v := range sorted {
+		sum_sq += (v - s.Mean) * (v - s.Mean)
+	}
+	s.Std = math.Sqrt(sum_sq / float64(len(sorted)))
+	s.Min, s.Max = sorted[0], sorted[len(sorted)-1]
+	for i, p := range percentiles {
+		s.Percentiles[i] = Percentile(sorted, p)
+	}
+	return s
+}
+
+// Percentile of a sorted sample, p within [0, 100], interpolating linearly between the closest ranks
+// (the default method of numpy's percentile)
+func Percentile(sorted []float64, p float64) float64 {
+	if len(sorted) == 0 {
+		return math.NaN()
+	}
+	rank := p / 100 * float64(len(sorted)-1)
+	lower := int(math.Floor(rank))
+	if lower >= len(sorted)-1 {
+		return sorted[len(sorted)-1]
+	}
+	return sorted[lower] + (rank-float64(lower))*(sorted[lower+1]-sorted[lower])
+}
@@ -0,0 +1,171 @@
+/***************************************************
+* Author: Diana Cohen (sch.diana@gmail.com)
+* **************************************************
+* test the flow-size distribution: the bins of the log-binned histogram (empty bins included),
+* the exponent of Zipf's law recovered from synthetic Zipf(s) flow sizes, and the top share of traffic
+* the summary of a sample, and its percentiles against hand-computed values (a single value and ties included)
+ */
+
+package stats
+
+import (
+	"math"
+	"reflect"
+	"slices"
+	"testing"
+)
+
+func TestLogHistogram(t *testing.T) {
+	tests := []struct {
+		name  string
+		sizes []uint64
+		bins  []Bin
+	}{
+		{"empty", nil, nil},
+		{"zeros alone", []uint64{0, 0}, nil},
+		{"powers of 2", []uint64{1, 2, 3, 4, 7, 8, 0, 1}, []Bin{
+			{Lower: 1, Upper: 2, Flows: 2, Items: 2},
+			{Lower: 2, Upper: 4, Flows: 2, Items: 5},
+			{Lower: 4, Upper: 8, Flows: 2, Items: 11},
+			{Lower: 8, Upper: 16, Flows: 1, Items: 8},
+		}},
+		{"empty bins between", []uint64{17, 1}, []Bin{
+			{Lower: 1, Upper: 2, Flows: 1, Items: 1},
+			{Lower: 2, Upper: 4},
+			{Lower: 4, Upper: 8},
+			{Lower: 8, Upper: 16},
+			{Lower: 16, Upper: 32, Flows: 1, Items: 17},
+		}},
+	}
+	for _, test := range tests {
+		if bins := LogHistogram(test.sizes); !reflect.DeepEqual(bins, test.bins) {
+			t.Errorf("%s: bins %+v, expected %+v", test.name, bins, test.bins)
+		}
+	}
+	// the bin of the largest size, whose upper bound wraps around
+	bins := LogHistogram([]uint64{math.MaxUint64})
+	if last := bins[len(bins)-1]; len(bins) != 64 || last.Lower != 1<<63 || last.Flows != 1 || last.Items != math.MaxUint64 {
+		t.Errorf("the largest size: %d bins, the last one %+v", len(bins), last)
+	}
+}
+
+// the flow sizes of an exact Zipf(s) distribution: the flow of rank r is of size c/r^s (rounded)
+func zipfSizes(n int, s, c float64) []uint64 {
+	sizes := make([]uint64, n)
+	for r := 1; r <= n; r++ {
+		sizes[r-1] = uint64(math.Round(c / math.Pow(float64(r), s)))
+	}
+	return sizes
+}
+
+func TestFitZipf(t *testing.T) {
+	for _, s := range []float64{0.6, 0.9, 1.0, 1.2, 1.5} {
+		z := FitZipf(zipfSizes(2000, s, 1e7))
+		if math.Abs(z.S-s) > 0.01 {
+			t.Errorf("s=%v: fitted s %.4f", s, z.S)
+		}
+		if math.Abs(z.C-1e7)/1e7 > 0.01 {
+			t.Errorf("s=%v: fitted C %.0f, expected 1e7", s, z.C)
+		}
+		if z.R2 < 0.999 || z.KS > 0.01 {
+			t.Errorf("s=%v: R2 %.5f, KS %.5f of an exact Zipf", s, z.R2, z.KS)
+		}
+	}
+	// a sorted shuffle of the sizes is fitted alike
+	sizes := zipfSizes(500, 1.1, 1e6)
+	for i, j := 0, len(sizes)-1; i < j; i, j = i+1, j-1 {
+		sizes[i], sizes[j] = sizes[j], sizes[i]
+	}
+	if z := FitZipf(SortDesc(sizes)); math.Abs(z.S-1.1) > 0.01 {
+		t.Errorf("the reversed sizes, sorted: fitted s %.4f, expected 1.1", z.S)
+	}
+	// uniform sizes have no skew
+	if z := FitZipf([]uint64{5, 5, 5, 5}); z.S != 0 || z.R2 != 1 || z.KS > 1e-12 {
+		t.Errorf("uniform sizes: %+v", z)
+	}
+	for _, sizes := range [][]uint64{nil, {7}} {
+		if z := FitZipf(sizes); !math.IsNaN(z.S) || !math.IsNaN(z.R2) {
+			t.Errorf("%d flows: %+v, expected NaN", len(sizes), z)
+		}
+	}
+}
+
+func TestTopShare(t *testing.T) {
+	sorted := []uint64{50, 30, 10, 5, 5}
+	tests := []struct {
+		fraction float64
+		share    float64
+	}{
+		{0, 0.5}, // at least a single flow
+		{0.01, 0.5},
+		{0.2, 0.5},
+		{0.3, 0.8}, // the top 1.5 flows are rounded up to 2
+		{0.4, 0.8},
+		{0.8, 0.95},
+		{1, 1},
+	}
+	for _, test := range tests {
+		if share := TopShare(sorted, test.fraction); math.Abs(share-test.share) > 1e-12 {
+			t.Errorf("top %v: share %v, expected %v", test.fraction, share, test.share)
+		}
+	}
+	if share := TopShare(nil, 0.1); !math.IsNaN(share) {
+		t.Errorf("share %v of no flows, expected NaN", share)
+	}
+}
+
+func TestPercentile(t *testing.T) {
+	tests := []struct {
+		sorted     []float64
+		p          float64
+		percentile float64
+	}{
+		{[]float64{1, 2, 3, 4}, 0, 1},
+		{[]float64{1, 2, 3, 4}, 25, 1.75}, // rank 0.75
+		{[]float64{1, 2, 3, 4}, 50, 2.5},
+		{[]float64{1, 2, 3, 4}, 90, 3.7}, // rank 2.7
+		{[]float64{1, 2, 3, 4}, 100, 4},
+		{[]float64{7}, 0, 7},
+		{[]float64{7}, 50, 7},
+		{[]float64{7}, 100, 7},
+		{[]float64{2, 2, 2, 5}, 50, 2}, // between ties
+		{[]float64{2, 2, 2, 5}, 75, 2.75},
+		{[]float64{3, 3}, 99, 3},
+	}
+	for _, test := range tests {
+		if percentile := Percentile(test.sorted, test.p); math.Abs(percentile-test.percentile) > 1e-12 {
+			t.Errorf("the %vth percentile of %v: %v, expected %v", test.p, test.sorted, percentile, test.percentile)
+		}
+	}
+	if percentile := Percentile(nil, 50); !math.IsNaN(percentile) {
+		t.Errorf("the percentile %v of an empty sample, expected NaN", percentile)
+	}
+}
+
+func TestSummarize(t *testing.T) {
+	tests := []struct {
+		name   string
+		values []float64
+		want   Summary
+	}{
+		{"unsorted", []float64{4, 1, 3, 2}, Summary{Count: 4, Mean: 2.5, Std: math.Sqrt(1.25), Min: 1, Max: 4, Percentiles: []float64{2.5, 3.7}}},
+		{"a single value", []float64{5}, Summary{Count: 1, Mean: 5, Std: 0, Min: 5, Max: 5, Percentiles: []float64{5, 5}}},
+		{"ties", []float64{2, 5, 2, 2}, Summary{Count: 4, Mean: 2.75, Std: math.Sqrt(1.6875), Min: 2, Max: 5, Percentiles: []float64{2, 4.1}}},
+	}
+	for _, test := range tests {
+		values := slices.Clone(test.values)
+		s := Summarize(values, []float64{50, 90})
+		if !slices.Equal(values, test.values) {
+			t.Errorf("%s: the sample is modified to %v", test.name, values)
+		}
+		near := func(a, b float64) bool { return math.Abs(a-b) < 1e-12 }
+		if s.Count != test.want.Count || !near(s.Mean, test.want.Mean) || !near(s.Std, test.want.Std) ||
+			s.Min != test.want.Min || s.Max != test.want.Max || !slices.EqualFunc(s.Percentiles, test.want.Percentiles, near) {
+			t.Errorf("%s: %+v, expected %+v", test.name, s, test.want)
+		}
+	}
+	s := Summarize(nil, []float64{50})
+	if s.Count != 0 || !math.IsNaN(s.Mean) || !math.IsNaN(s.Std) || !math.IsNaN(s.Min) || !math.IsNaN(s.Max) || !math.IsNaN(s.Percentiles[0]) {
+		t.Errorf("the summary of an empty sample: %+v, expected NaN", s)
+	}
+}
@@ -0,0 +1,93 @@
+/***************************************************
+* Author: Diana Cohen (sch.diana@gmail.com)
+* **************************************************
+* read ahead: decode the items of a trace on a separate goroutine,
+* handing them over in chunks, so decoding overlaps the processing of the items
+* a panic of the reader goroutine is raised again by Scan, on the goroutine of the caller
+ */
+
+package trace
+
+import "fmt"
+
+// AheadScanner is a Scanner that reads the items ahead, by a reader goroutine
+type AheadScanner struct {
+	chunks      chan []Item
+	done        chan struct{} // closed by Close, stops the reader
+	chunk       []Item        // the current chunk
+	pos         int
+	item        Item
+	err         error // the error of the underlying scanner, set before chunks is closed
+	panicked    any   // the panic of the reader goroutine, set before chunks is closed
+	timestamped bool
+}
+
+// ReadAhead starts reading the items of the scanner by a reader goroutine,
+// in chunks of a given size, up to ahead chunks are buffered.
+// The caller should not use the underlying scanner anymore, and should Close the returned one.
+func ReadAhead(scanner Scanner, size, ahead int) *AheadScanner {
+	s := &AheadScanner{
+		chunks:      make(chan []Item, max(ahead, 0)),
+		done:        make(chan struct{}),
+		timestamped: scanner.Timestamped(),
+	}
+	size = max(size, 1)
+	go func() {
+		defer close(s.chunks)
+		defer func() {
+			s.panicked = recover()
+		}()
+		chunk := make([]Item, 0, size)
+		for scanner.Scan() {
+			chunk = append(chunk, scanner.Item())
+			if len(chunk) == size {
+				select {
+				case s.chunks <- chunk:
+				case <-s.done:
+					return
+				}
+				chunk = make([]Item, 0, size)
+			}
+		}
+		s.err = scanner.Err()
+		if len(chunk) > 0 {
+			select {
+			case s.chunks <- chunk:
+			case <-s.done:
+			}
+		}
+	}()
+	return s
+}
+
+func (s *AheadScanner) Scan() bool {
+	for s.pos == len(s.chunk) {
+		chunk, ok := <-s.chunks
+		if !ok {
+			if s.panicked != nil {
+				panic(fmt.Sprintf("the reader goroutine panicked: %v", s.panicked))
+			}
+			return false
+		}
+		s.chunk, s.pos = chunk, 0
+	}
+	s.item = s.chunk[s.pos]
+	s.pos++
+	return true
+}
+
+func (s *AheadScanner) Item() Item { return s.item }
+
+// Err is valid once Scan returns false
+func (s *AheadScanner) Err() error { return s.err }
+
+func (s *AheadScanner) Timestamped() bool { return s.timestamped }
+
+// Close stops the reader goroutine, in case the items were not read to the end
+func (s *AheadScanner) Close() {
+	select {
+	case <-s.done:
+	default:
+		close(s.done)
+	}
+}
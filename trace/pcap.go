@@ -0,0 +1,226 @@
+/***************************************************
+* Author: Diana Cohen (sch.diana@gmail.com)
+* **************************************************
+* read a libpcap capture file (not pcapng), microsecond or nanosecond resolution,
+* the flow-id of a packet is its 5-tuple: "src:sport-dst:dport-proto"
+* supported link types: ethernet (incl. VLAN tags), raw IP and linux cooked capture
+* non-IP packets are skipped
+* a record longer than the snapshot length of the capture (at most 256 KiB) is rejected, as corrupt
+ */
+
+package trace
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net/netip"
+	"time"
+)
+
+const (
+	pcapMagicMicro = 0xa1b2c3d4
+	pcapMagicNano  = 0xa1b23c4d
+
+	linkEthernet = 1
+	linkRaw      = 101
+	linkRawBSD   = 12
+	linkLinuxSLL = 113
+	linkIPv4     = 228
+	linkIPv6     = 229
+
+	// the longest record, the snapshot length of tcpdump
+	maxPcapRecord = 256 << 10
+)
+
+type pcapScanner struct {
+	r       io.Reader
+	order   binary.ByteOrder
+	nano    bool
+	link    uint32
+	snaplen uint32
+	header  [16]byte
+	data    []byte
+	item    Item
+	err     error
+}
+
+func newPcapScanner(r io.Reader) (*pcapScanner, error) {
+	var global [24]byte
+	if _, err := io.ReadFull(r, global[:]); err != nil {
+		return nil, fmt.Errorf("trace: reading pcap header: %w", err)
+	}
+	s := &pcapScanner{r: r}
+	switch {
+	case binary.LittleEndian.Uint32(global[0:4]) == pcapMagicMicro:
+		s.order = binary.LittleEndian
+	case binary.BigEndian.Uint32(global[0:4]) == pcapMagicMicro:
+		s.order = binary.BigEndian
+	case binary.LittleEndian.Uint32(global[0:4]) == pcapMagicNano:
+		s.order, s.nano = binary.LittleEndian, true
+	case binary.BigEndian.Uint32(global[0:4]) == pcapMagicNano:
+		s.order, s.nano = binary.BigEndian, true
+	default:
+		return nil, errors.New("trace: not a pcap file (pcapng is not supported)")
+	}
+	s.link = s.order.Uint32(global[20:24]) & 0x0fffffff // upper bits may carry FCS info
+	switch s.link {
+	case linkEthernet, linkRaw, linkRawBSD, linkLinuxSLL, linkIPv4, linkIPv6:
+	default:
+		return nil, fmt.Errorf("trace: unsupported pcap link type %d", s.link)
+	}
+	s.snaplen = s.order.Uint32(global[16:20])
+	if s.snaplen == 0 || s.snaplen > maxPcapRecord {
+		s.snaplen = maxPcapRecord
+	}
+	return s, nil
+}
+
+func (s *pcapScanner) Scan() bool {
+	for s.err == nil {
+		if _, err := io.ReadFull(s.r, s.header[:]); err != nil {
+			if err != io.EOF {
+				s.err = fmt.Errorf("trace: reading pcap record: %w", err)
+			}
+			return false
+		}
+		sec := s.order.Uint32(s.header[0:4])
+		frac := s.order.Uint32(s.header[4:8])
+		incl_len := s.order.Uint32(s.header[8:12])
+		if incl_len > s.snaplen {
+			s.err = fmt.Errorf("trace: pcap record of %d bytes exceeds the snapshot length %d", incl_len, s.snaplen)
+			return false
+		}
+		if cap(s.data) < int(incl_len) {
+			s.data = make([]byte, incl_len)
+		}
+		s.data = s.data[:incl_len]
+		if _, err := io.ReadFull(s.r, s.data); err != nil {
+			s.err = fmt.Errorf("trace: reading pcap record: %w", err)
+			return false
+		}
+		id, err := s.flowID(s.data)
+		if err != nil { // skip non-IP and truncated packets
+			continue
+		}
+		nsec := int64(frac)
+		if !s.nano {
+			nsec *= 1000
+		}
+		s.item = Item{ID: id, Time: time.Unix(int64(sec), nsec)}
+		return true
+	}
+	return false
+}
+
+func (s *pcapScanner) Item() Item { return s.item }
+
+func (s *pcapScanner) Err() error { return s.err }
+
+func (s *pcapScanner) Timestamped() bool { return true }
+
+// strip the link layer and extract the 5-tuple of an IP packet
+func (s *pcapScanner) flowID(pkt []byte) (string, error) {
+	var ethertype uint16
+	switch s.link {
+	case linkEthernet:
+		if len(pkt) < 14 {
+			return "", errNoIP
+		}
+		ethertype = binary.BigEndian.Uint16(pkt[12:14])
+		pkt = pkt[14:]
+		for ethertype == 0x8100 || ethertype == 0x88a8 { // VLAN tags
+			if len(pkt) < 4 {
+				return "", errNoIP
+			}
+			ethertype = binary.BigEndian.Uint16(pkt[2:4])
+			pkt = pkt[4:]
+		}
+	case linkLinuxSLL:
+		if len(pkt) < 16 {
+			return "", errNoIP
+		}
+		ethertype = binary.BigEndian.Uint16(pkt[14:16])
+		pkt = pkt[16:]
+	default: // raw IP, the version is in the first nibble
+		if len(pkt) < 1 {
+			return "", errNoIP
+		}
+		switch pkt[0] >> 4 {
+		case 4:
+			ethertype = 0x0800
+		case 6:
+			ethertype = 0x86dd
+		}
+	}
+
+	switch ethertype {
+	case 0x0800:
+		return ipv4FlowID(pkt)
+	case 0x86dd:
+		return ipv6FlowID(pkt)
+	}
+	return "", errNoIP
+}
+
+func ipv4FlowID(pkt []byte) (string, error) {
+	if len(pkt) < 20 || pkt[0]>>4 != 4 {
+		return "", errNoIP
+	}
+	ihl := int(pkt[0]&0x0f) * 4
+	if ihl < 20 || len(pkt) < ihl {
+		return "", errNoIP
+	}
+	proto := pkt[9]
+	src := netip.AddrFrom4([4]byte(pkt[12:16]))
+	dst := netip.AddrFrom4([4]byte(pkt[16:20]))
+	var l4 []byte
+	if binary.BigEndian.Uint16(pkt[6:8])&0x1fff == 0 { // only the first fragment carries the ports
+		l4 = pkt[ihl:]
+	}
+	return formatFlowID(src, dst, proto, l4), nil
+}
+
+func ipv6FlowID(pkt []byte) (string, error) {
+	if len(pkt) < 40 || pkt[0]>>4 != 6 {
+		return "", errNoIP
+	}
+	proto := pkt[6]
+	src := netip.AddrFrom16([16]byte(pkt[8:24]))
+	dst := netip.AddrFrom16([16]byte(pkt[24:40]))
+	l4 := pkt[40:]
+	// skip the extension headers
+	for {
+		switch proto {
+		case 0, 43, 60: // hop-by-hop, routing, destination options
+			if len(l4) < 8 || len(l4) < (int(l4[1])+1)*8 {
+				return formatFlowID(src, dst, proto, nil), nil
+			}
+			proto = l4[0]
+			l4 = l4[(int(l4[1])+1)*8:]
+			continue
+		case 44: // fragment
+			if len(l4) < 8 {
+				return formatFlowID(src, dst, proto, nil), nil
+			}
+			proto = l4[0]
+			if binary.BigEndian.Uint16(l4[2:4])&0xfff8 != 0 {
+				return formatFlowID(src, dst, proto, nil), nil
+			}
+			l4 = l4[8:]
+			continue
+		}
+		return formatFlowID(src, dst, proto, l4), nil
+	}
+}
+
+// ports are taken for TCP, UDP and SCTP only, otherwise they are zero
+func formatFlowID(src, dst netip.Addr, proto byte, l4 []byte) string {
+	var sport, dport uint16
+	if (proto == 6 || proto == 17 || proto == 132) && len(l4) >= 4 {
+		sport = binary.BigEndian.Uint16(l4[0:2])
+		dport = binary.BigEndian.Uint16(l4[2:4])
+	}
+	return fmt.Sprintf("%s-%s-%d", netip.AddrPortFrom(src, sport), netip.AddrPortFrom(dst, dport), proto)
+}
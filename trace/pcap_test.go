@@ -0,0 +1,106 @@
+/***************************************************
+* Author: Diana Cohen (sch.diana@gmail.com)
+* **************************************************
+* test the pcap scanner against captures built in memory: whole, truncated and oversized records
+ */
+
+package trace
+
+import (
+	"bytes"
+	"encoding/binary"
+	"strings"
+	"testing"
+)
+
+// a pcap header of raw IP, little-endian, microseconds, of a given snapshot length
+func pcapHeader(snaplen uint32) []byte {
+	header := make([]byte, 24)
+	binary.LittleEndian.PutUint32(header[0:4], pcapMagicMicro)
+	binary.LittleEndian.PutUint16(header[4:6], 2)
+	binary.LittleEndian.PutUint16(header[6:8], 4)
+	binary.LittleEndian.PutUint32(header[16:20], snaplen)
+	binary.LittleEndian.PutUint32(header[20:24], linkRaw)
+	return header
+}
+
+// a record of a packet, of a given included length (the packet itself may be shorter, as truncated)
+func pcapRecord(sec uint32, incl_len uint32, pkt []byte) []byte {
+	record := make([]byte, 16, 16+len(pkt))
+	binary.LittleEndian.PutUint32(record[0:4], sec)
+	binary.LittleEndian.PutUint32(record[8:12], incl_len)
+	binary.LittleEndian.PutUint32(record[12:16], incl_len)
+	return append(record, pkt...)
+}
+
+// an IPv4 UDP packet of 10.0.0.1:1000 -> 10.0.0.2:53
+func udpPacket() []byte {
+	pkt := make([]byte, 28)
+	pkt[0] = 0x45
+	pkt[9] = 17
+	copy(pkt[12:16], []byte{10, 0, 0, 1})
+	copy(pkt[16:20], []byte{10, 0, 0, 2})
+	binary.BigEndian.PutUint16(pkt[20:22], 1000)
+	binary.BigEndian.PutUint16(pkt[22:24], 53)
+	return pkt
+}
+
+func TestPcapScanner(t *testing.T) {
+	pkt := udpPacket()
+	whole := pcapRecord(1, uint32(len(pkt)), pkt)
+	tests := []struct {
+		name    string
+		snaplen uint32
+		records [][]byte
+		items   int
+		err     string // a part of the error, none if empty
+	}{
+		{"whole records", 65535, [][]byte{whole, whole}, 2, ""},
+		{"no records", 65535, nil, 0, ""},
+		{"a non-IP packet is skipped", 65535, [][]byte{pcapRecord(1, 4, []byte{0, 0, 0, 0}), whole}, 1, ""},
+		{"a truncated record header", 65535, [][]byte{whole, whole[:10]}, 1, "reading pcap record"},
+		{"a truncated packet", 65535, [][]byte{whole, whole[:len(whole)-1]}, 1, "reading pcap record"},
+		{"a record beyond the snapshot length", 16, [][]byte{whole}, 0, "exceeds the snapshot length"},
+		{"a record of 4 GiB", 0, [][]byte{pcapRecord(1, 1<<32-1, pkt)}, 0, "exceeds the snapshot length"},
+		{"a record beyond 256 KiB", 1 << 31, [][]byte{pcapRecord(1, maxPcapRecord+1, pkt)}, 0, "exceeds the snapshot length"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			data := pcapHeader(test.snaplen)
+			for _, record := range test.records {
+				data = append(data, record...)
+			}
+			s, err := NewScanner(bytes.NewReader(data), FormatPcap)
+			if err != nil {
+				t.Fatal(err)
+			}
+			items := 0
+			for s.Scan() {
+				if id := s.Item().ID; id != "10.0.0.1:1000-10.0.0.2:53-17" {
+					t.Errorf("flow-id %q", id)
+				}
+				items++
+			}
+			if items != test.items {
+				t.Errorf("scanned %d items, expected %d", items, test.items)
+			}
+			switch {
+			case test.err == "" && s.Err() != nil:
+				t.Errorf("unexpected error: %v", s.Err())
+			case test.err != "" && (s.Err() == nil || !strings.Contains(s.Err().Error(), test.err)):
+				t.Errorf("error %v, expected %q", s.Err(), test.err)
+			}
+		})
+	}
+}
+
+func TestPcapHeader(t *testing.T) {
+	if _, err := NewScanner(bytes.NewReader(pcapHeader(0)[:20]), FormatPcap); err == nil {
+		t.Error("accepted a truncated header")
+	}
+	header := pcapHeader(0)
+	header[0] = 0
+	if _, err := NewScanner(bytes.NewReader(header), FormatPcap); err == nil {
+		t.Error("accepted a bad magic")
+	}
+}
@@ -0,0 +1,164 @@
+/***************************************************
+* Author: Diana Cohen (sch.diana@gmail.com)
+* **************************************************
+* read the items of a trace, one by one, from one of the supported formats:
+* txt   - a flow-id per line, no timestamps (the default)
+* tstxt - a timestamped flow-id per line: "<seconds>[.<fraction>] <flow-id>", the blank lines are skipped
+* pcap  - a libpcap capture, the flow-id is the 5-tuple of an IPv4/IPv6 packet
+ */
+
+package trace
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// supported input formats
+const (
+	FormatTxt   = "txt"
+	FormatTsTxt = "tstxt"
+	FormatPcap  = "pcap"
+)
+
+// Item is a single packet (item) of a trace: the flow-id and the arrival time.
+// Time is the zero value for formats that carry no timestamps.
+type Item struct {
+	ID   string
+	Time time.Time
+}
+
+// Scanner provides an interface similar to bufio.Scanner for reading the items of a trace.
+type Scanner interface {
+	Scan() bool        // advance to the next item, false at the end of input or on error
+	Item() Item        // the most recent item read by Scan
+	Err() error        // the first non-EOF error encountered by Scan
+	Timestamped() bool // whether the items carry arrival times
+}
+
+// Path returns the location of a trace file within the data directory for a given format
+func Path(data_dir, trace, format string) string {
+	if format == FormatPcap {
+		return filepath.Join(data_dir, trace+".pcap")
+	}
+	return filepath.Join(data_dir, trace+".txt")
+}
+
+// NewScanner creates a scanner over r for a given format
+func NewScanner(r io.Reader, format string) (Scanner, error) {
+	switch format {
+	case FormatTxt, "":
+		return &textScanner{scanner: bufio.NewScanner(r)}, nil
+	case FormatTsTxt:
+		return &textScanner{scanner: bufio.NewScanner(r), timestamped: true}, nil
+	case FormatPcap:
+		return newPcapScanner(r)
+	}
+	return nil, fmt.Errorf("trace: unknown format %q (expected %s, %s or %s)", format, FormatTxt, FormatTsTxt, FormatPcap)
+}
+
+// Open opens the trace file and creates a scanner over it, the caller should close the file
+func Open(path, format string) (*os.File, Scanner, error) {
+	infile, err := os.Open(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	scanner, err := NewScanner(infile, format)
+	if err != nil {
+		infile.Close()
+		return nil, nil, err
+	}
+	return infile, scanner, nil
+}
+
+/*
+* line-oriented text formats
+ */
+
+type textScanner struct {
+	scanner     *bufio.Scanner
+	timestamped bool
+	item        Item
+	line        int
+	err         error
+}
+
+func (s *textScanner) Scan() bool {
+	for s.err == nil && s.scanner.Scan() {
+		s.line++
+		text := s.scanner.Text()
+		if !s.timestamped {
+			s.item = Item{ID: text}
+			return true
+		}
+		// timestamped line: "<seconds>[.<fraction>] <flow-id>"
+		ts, id, _ := strings.Cut(strings.TrimSpace(text), " ")
+		if ts == "" { // a blank line has no arrival time (nor a flow), skipped
+			continue
+		}
+		t, err := parseSeconds(ts)
+		if err != nil {
+			s.err = fmt.Errorf("trace: line %d: %w", s.line, err)
+			return false
+		}
+		s.item = Item{ID: strings.TrimSpace(id), Time: t}
+		return true
+	}
+	return false
+}
+
+func (s *textScanner) Item() Item { return s.item }
+
+func (s *textScanner) Err() error {
+	if s.err != nil {
+		return s.err
+	}
+	return s.scanner.Err()
+}
+
+func (s *textScanner) Timestamped() bool { return s.timestamped }
+
+// parse a decimal number of seconds since the epoch without losing the sub-microsecond digits
+func parseSeconds(ts string) (time.Time, error) {
+	sec_str, frac_str, _ := strings.Cut(ts, ".")
+	sec, err := strconv.ParseInt(sec_str, 10, 64)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("bad timestamp %q", ts)
+	}
+	nsec := int64(0)
+	if frac_str != "" {
+		if len(frac_str) > 9 { // nanosecond resolution is enough
+			frac_str = frac_str[:9]
+		}
+		frac, err := strconv.ParseUint(frac_str, 10, 32)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("bad timestamp %q", ts)
+		}
+		nsec = int64(frac)
+		for i := len(frac_str); i < 9; i++ {
+			nsec *= 10
+		}
+	}
+	return time.Unix(sec, nsec), nil
+}
+
+var errNoIP = errors.New("not an IP packet")
+
+// WindowIndex returns the 0-based index of the time window (of a given length) that contains t,
+// where the first window starts at start; a t before start is within a negative window (-1 just
+// before start), it is up to the caller to keep such a late item within its current window
+func WindowIndex(start, t time.Time, window time.Duration) int {
+	d := t.Sub(start)
+	index := d / window
+	if d%window < 0 { // round down, rather than towards start
+		index--
+	}
+	return int(index)
+}
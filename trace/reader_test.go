@@ -0,0 +1,52 @@
+/***************************************************
+* Author: Diana Cohen (sch.diana@gmail.com)
+* **************************************************
+* test the text scanners: the flow-ids of a txt trace (empty lines included), and the timestamped
+* items of a tstxt trace, whose blank lines are skipped (they have no arrival time)
+ */
+
+package trace
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTextScanner(t *testing.T) {
+	at := func(sec, usec int64) time.Time { return time.Unix(sec, usec*1000) }
+	tests := []struct {
+		name   string
+		format string
+		text   string
+		items  []Item
+		err    string // a part of the error, none if empty
+	}{
+		{"txt", FormatTxt, "a\nb\n", []Item{{ID: "a"}, {ID: "b"}}, ""},
+		{"txt keeps the empty lines", FormatTxt, "a\n\nb", []Item{{ID: "a"}, {ID: ""}, {ID: "b"}}, ""},
+		{"tstxt", FormatTsTxt, "1.5 a\n2.000001 b\n", []Item{{"a", at(1, 500000)}, {"b", at(2, 1)}}, ""},
+		{"tstxt of a first blank line", FormatTsTxt, "\n  \n1 a\n\n2 b\n", []Item{{"a", at(1, 0)}, {"b", at(2, 0)}}, ""},
+		{"tstxt of blank lines alone", FormatTsTxt, "\n\n", nil, ""},
+		{"tstxt of no flow-id", FormatTsTxt, "1\n", []Item{{"", at(1, 0)}}, ""},
+		{"tstxt of a bad timestamp", FormatTsTxt, "1 a\n\nx b\n", []Item{{"a", at(1, 0)}}, "line 3"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			scanner, err := NewScanner(strings.NewReader(test.text), test.format)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var items []Item
+			for scanner.Scan() {
+				items = append(items, scanner.Item())
+			}
+			if !reflect.DeepEqual(items, test.items) {
+				t.Errorf("scanned %v, expected %v", items, test.items)
+			}
+			if err := scanner.Err(); (err == nil) != (test.err == "") || err != nil && !strings.Contains(err.Error(), test.err) {
+				t.Errorf("error %v, expected %q", err, test.err)
+			}
+		})
+	}
+}
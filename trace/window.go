@@ -0,0 +1,80 @@
+/***************************************************
+* Author: Diana Cohen (sch.diana@gmail.com)
+* **************************************************
+* track the exact flow statistics of a sliding window:
+* B - the number of items within the window (the queue of the latest items)
+* b - the number of flows (distinct items) within the window
+* the caller expires the items, either by count (the latest W items),
+* or by time (the items that arrived within the latest W time units)
+ */
+
+package trace
+
+import "time"
+
+// Window is an exact windowed counter of flow frequencies
+type Window struct {
+	items  []Item // queue of the items within the window, the oldest at head
+	head   int
+	counts map[string]int
+}
+
+// NewWindow creates an empty window
+func NewWindow() *Window {
+	return &Window{counts: make(map[string]int)}
+}
+
+// Push a new item into the window
+func (w *Window) Push(item Item) {
+	w.items = append(w.items, item)
+	w.counts[item.ID]++
+}
+
+// ExpireCount drops the oldest items, keeping at most size items within the window
+func (w *Window) ExpireCount(size int) {
+	for w.Len() > size {
+		w.pop()
+	}
+	w.compact()
+}
+
+// ExpireBefore drops the oldest items that arrived before t
+func (w *Window) ExpireBefore(t time.Time) {
+	for w.Len() > 0 && w.items[w.head].Time.Before(t) {
+		w.pop()
+	}
+	w.compact()
+}
+
+// Len returns B - the number of items within the window
+func (w *Window) Len() int {
+	return len(w.items) - w.head
+}
+
+// Flows returns b - the number of flows within the window
+func (w *Window) Flows() int {
+	return len(w.counts)
+}
+
+// Counts returns the frequencies of the flows within the window, it must not be modified
+func (w *Window) Counts() map[string]int {
+	return w.counts
+}
+
+func (w *Window) pop() {
+	id := w.items[w.head].ID
+	w.items[w.head] = Item{} // release the flow-id
+	w.head++
+	if w.counts[id]--; w.counts[id] == 0 {
+		delete(w.counts, id)
+	}
+}
+
+// reclaim the space of the expired items once they are the majority of the queue
+func (w *Window) compact() {
+	if w.head > 1024 && w.head*2 > len(w.items) {
+		n := copy(w.items, w.items[w.head:])
+		w.items = w.items[:n]
+		w.head = 0
+	}
+}
@@ -0,0 +1,84 @@
+/***************************************************
+* Author: Diana Cohen (sch.diana@gmail.com)
+* **************************************************
+* test the time windows: the index of the window of an item (including the items before the start),
+* and the expiry of a sliding window, by count and by time, at the boundary of the window
+ */
+
+package trace
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestWindowIndex(t *testing.T) {
+	start := time.Unix(100, 0)
+	tests := []struct {
+		t     time.Duration // since start
+		index int
+	}{
+		{0, 0},
+		{999 * time.Millisecond, 0},
+		{time.Second, 1}, // a window starts at its boundary
+		{10*time.Second + 1, 10},
+		{-1, -1}, // just before start
+		{-time.Second, -1},
+		{-time.Second - 1, -2},
+	}
+	for _, test := range tests {
+		if index := WindowIndex(start, start.Add(test.t), time.Second); index != test.index {
+			t.Errorf("the window of start%+v is %d, expected %d", test.t, index, test.index)
+		}
+	}
+}
+
+func TestWindowExpireCount(t *testing.T) {
+	w := NewWindow()
+	for i := 0; i < 5000; i++ { // beyond the compaction of the queue
+		w.Push(Item{ID: fmt.Sprintf("flow%d", i%3)})
+		w.ExpireCount(4)
+	}
+	if w.Len() != 4 || w.Flows() != 3 {
+		t.Errorf("B %d, b %d, expected 4, 3", w.Len(), w.Flows())
+	}
+	// the latest 4 items: 4996 % 3 = 1, 2, 0, 1
+	if want := map[string]int{"flow0": 1, "flow1": 2, "flow2": 1}; !reflect.DeepEqual(w.Counts(), want) {
+		t.Errorf("counts %v, expected %v", w.Counts(), want)
+	}
+	w.ExpireCount(4) // at the boundary, nothing expires
+	if w.Len() != 4 {
+		t.Errorf("B %d at the boundary, expected 4", w.Len())
+	}
+	w.ExpireCount(0)
+	if w.Len() != 0 || w.Flows() != 0 {
+		t.Errorf("B %d, b %d of an empty window", w.Len(), w.Flows())
+	}
+}
+
+func TestWindowExpireBefore(t *testing.T) {
+	at := func(sec int64) time.Time { return time.Unix(sec, 0) }
+	w := NewWindow()
+	for sec, id := range []string{"a", "b", "a", "c", "a"} {
+		w.Push(Item{ID: id, Time: at(int64(sec))})
+	}
+	tests := []struct {
+		before time.Time
+		counts map[string]int
+	}{
+		{at(0), map[string]int{"a": 3, "b": 1, "c": 1}},         // an item at the boundary is kept
+		{at(1).Add(-1), map[string]int{"a": 2, "b": 1, "c": 1}}, // just after the oldest item
+		{at(2), map[string]int{"a": 2, "c": 1}},                 // b expires along with its flow
+		{at(1), map[string]int{"a": 2, "c": 1}},                 // an earlier time expires nothing
+		{at(4), map[string]int{"a": 1}},                         // the last item alone
+		{at(5), map[string]int{}},                               // all expired
+	}
+	for _, test := range tests {
+		w.ExpireBefore(test.before)
+		if !reflect.DeepEqual(w.Counts(), test.counts) {
+			t.Errorf("expired before %d: counts %v, expected %v", test.before.Unix(), w.Counts(), test.counts)
+		}
+	}
+}